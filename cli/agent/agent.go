@@ -0,0 +1,135 @@
+// Package agent defines the interface of the AI agents that ok asks to fix
+// failures and perform tasks, and renders their sessions in the terminal.
+// Each provider, like the claude CLI, implements Agent in its own package.
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"ok.build/cli/config"
+)
+
+// defaultProvider is used unless the agent.provider config key is set.
+const defaultProvider = "claude"
+
+// Agent starts sessions with an AI agent.
+type Agent interface {
+	// Name is the name of the provider, like "claude".
+	Name() string
+
+	// Start starts a session that works on the request.
+	Start(req *Request) (Session, error)
+
+	// Resume continues an earlier session, given its ID, with a message from
+	// the user.
+	Resume(sessionID string, message string) (Session, error)
+}
+
+// Request is a task for an agent.
+type Request struct {
+	Prompt string
+	// Context is attached to the prompt, like the errors of a failed build
+	// and their source. It may be nil.
+	Context io.Reader
+	// SystemPrompt tells the agent how to behave, including how to offer
+	// choices to the user.
+	SystemPrompt string
+	// Interactive is set when the user wants to work through the task with
+	// the agent, rather than have it make its own choices. Run adds what it
+	// means to the system prompt, which every provider passes on.
+	Interactive bool
+}
+
+// Session is a conversation with an agent, made of turns in which the agent
+// works until it is done or needs an answer from the user.
+type Session interface {
+	// ID returns the session's ID, which can be passed to Agent.Resume. It
+	// may be empty until the agent has reported it.
+	ID() string
+
+	// Events returns the events of the agent's current turn. The channel is
+	// closed when the turn ends.
+	Events() <-chan *Event
+
+	// Answer answers a choice that the agent offered in the turn that ended,
+	// or otherwise replies to it, which starts the next turn.
+	Answer(text string) error
+
+	// Close ends the session. It returns the error that ended the last turn,
+	// if any.
+	Close() error
+}
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	// Text is text written by the agent.
+	Text EventType = "text"
+	// ToolUse is a call of a tool, like reading a file or running a command.
+	ToolUse EventType = "tool_use"
+	// ToolResult is the result of a call of a tool.
+	ToolResult EventType = "tool_result"
+	// Usage reports the tokens that the agent used.
+	Usage EventType = "usage"
+	// Permission asks the user whether the agent may use a tool, which the
+	// permission policy leaves to them. The answer is sent on Reply.
+	Permission EventType = "permission"
+)
+
+// Event is something that happened in a session.
+type Event struct {
+	Type EventType `json:"type"`
+
+	// Text is set for Text events, and holds the output of ToolResult events.
+	Text string `json:"text,omitempty"`
+	// Continued is set for Text events that continue the text of the one
+	// before, when a provider streams a text in parts. They are rendered in
+	// the same bullet.
+	Continued bool `json:"continued,omitempty"`
+
+	// ToolUseID identifies the tool call of ToolUse and ToolResult events.
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	// ToolName and ToolInput are set for ToolUse events. The input is a JSON
+	// object.
+	ToolName  string          `json:"tool_name,omitempty"`
+	ToolInput json.RawMessage `json:"tool_input,omitempty"`
+	// IsError is set for ToolResult events of tool calls that failed.
+	IsError bool `json:"is_error,omitempty"`
+
+	// Tokens is set for Usage events.
+	Tokens int `json:"tokens,omitempty"`
+
+	// Reply receives whether the user allowed the tool use that a Permission
+	// event asks about. Its ToolName is set, and its Text holds what the tool
+	// would be used on, like a command or a path.
+	Reply chan<- bool `json:"-"`
+}
+
+// Providers create the agents that the agent.provider config key can choose,
+// by name. They are registered by the ok binary, since providers depend on
+// this package.
+var Providers = map[string]func() (Agent, error){}
+
+// New returns the agent of the provider chosen by the agent.provider config
+// key.
+func New() (Agent, error) {
+	name := config.Get("agent.provider")
+	if name == "" {
+		name = defaultProvider
+	}
+	newAgent, ok := Providers[name]
+	if !ok {
+		var names []string
+		for n := range Providers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown agent provider %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return newAgent()
+}
@@ -0,0 +1,133 @@
+// Package anthropic implements agent.Agent with the Anthropic Messages API.
+// ok runs the agent's tools itself, so no agent CLI needs to be installed.
+package anthropic
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	sdk "github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"ok.build/cli/agent"
+	"ok.build/cli/agent/loop"
+	"ok.build/cli/config"
+)
+
+const (
+	// defaultModel is used unless the agent.anthropic.model config key is
+	// set.
+	defaultModel = "claude-sonnet-4-0"
+	// defaultMaxTokens is how long a message may be unless the
+	// agent.anthropic.max_tokens config key is set.
+	defaultMaxTokens = 8192
+)
+
+// Agent runs sessions with a model of the Anthropic API.
+type Agent struct {
+	client    sdk.Client
+	model     string
+	maxTokens int
+}
+
+// New returns an agent for the Anthropic API. The API key is taken from the
+// agent.anthropic.api_key config key or the ANTHROPIC_API_KEY environment
+// variable, and the API's URL can be changed with agent.anthropic.base_url,
+// for example to use a proxy.
+func New() (agent.Agent, error) {
+	var opts []option.RequestOption
+	if key := config.Get("agent.anthropic.api_key"); key != "" {
+		opts = append(opts, option.WithAPIKey(key))
+	} else if os.Getenv("ANTHROPIC_API_KEY") == "" {
+		return nil, fmt.Errorf("the anthropic agent needs an API key; set ANTHROPIC_API_KEY or the agent.anthropic.api_key config key")
+	}
+	if url := config.Get("agent.anthropic.base_url"); url != "" {
+		opts = append(opts, option.WithBaseURL(url))
+	}
+	a := &Agent{
+		client:    sdk.NewClient(opts...),
+		model:     config.Get("agent.anthropic.model"),
+		maxTokens: config.GetInt("agent.anthropic.max_tokens", defaultMaxTokens),
+	}
+	if a.model == "" {
+		a.model = defaultModel
+	}
+	return a, nil
+}
+
+func (a *Agent) Name() string {
+	return "anthropic"
+}
+
+func (a *Agent) Start(req *agent.Request) (agent.Session, error) {
+	return loop.Start(a.Name(), a, req)
+}
+
+func (a *Agent) Resume(sessionID string, message string) (agent.Session, error) {
+	return loop.Resume(a.Name(), a, sessionID, message)
+}
+
+// Complete implements loop.Model.
+func (a *Agent) Complete(ctx context.Context, c *loop.Conversation, tools []*loop.Tool, emit func(*agent.Event)) (*loop.Message, int, error) {
+	params := sdk.MessageNewParams{
+		Model:     sdk.Model(a.model),
+		MaxTokens: int64(a.maxTokens),
+		Messages:  messageParams(c.Messages),
+	}
+	if c.System != "" {
+		params.System = []sdk.TextBlockParam{{Text: c.System}}
+	}
+	for _, t := range tools {
+		params.Tools = append(params.Tools, sdk.ToolUnionParam{OfTool: &sdk.ToolParam{
+			Name:        t.Name,
+			Description: sdk.String(t.Description),
+			InputSchema: sdk.ToolInputSchemaParam{Properties: t.Properties, Required: t.Required},
+		}})
+	}
+	res, err := a.client.Messages.New(ctx, params)
+	if err != nil {
+		return nil, 0, err
+	}
+	msg := &loop.Message{Role: loop.Assistant}
+	for _, block := range res.Content {
+		var part *agent.Event
+		switch block.Type {
+		case "text":
+			part = &agent.Event{Type: agent.Text, Text: block.Text}
+		case "tool_use":
+			part = &agent.Event{Type: agent.ToolUse, ToolUseID: block.ID, ToolName: block.Name, ToolInput: block.Input}
+		default:
+			continue
+		}
+		emit(part)
+		msg.Content = append(msg.Content, part)
+	}
+	return msg, int(res.Usage.InputTokens + res.Usage.OutputTokens), nil
+}
+
+// messageParams converts the messages of a conversation to the API's.
+func messageParams(messages []*loop.Message) []sdk.MessageParam {
+	var params []sdk.MessageParam
+	for _, m := range messages {
+		var blocks []sdk.ContentBlockParamUnion
+		for _, part := range m.Content {
+			switch part.Type {
+			case agent.Text:
+				blocks = append(blocks, sdk.NewTextBlock(part.Text))
+			case agent.ToolUse:
+				blocks = append(blocks, sdk.NewToolUseBlock(part.ToolUseID, loop.Input(part), part.ToolName))
+			case agent.ToolResult:
+				blocks = append(blocks, sdk.NewToolResultBlock(part.ToolUseID, part.Text, part.IsError))
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if m.Role == loop.Assistant {
+			params = append(params, sdk.NewAssistantMessage(blocks...))
+		} else {
+			params = append(params, sdk.NewUserMessage(blocks...))
+		}
+	}
+	return params
+}
@@ -0,0 +1,230 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/config"
+)
+
+// request is the part of a Messages API request that the test checks.
+type request struct {
+	Model    string `json:"model"`
+	System   []struct{ Text string }
+	Messages []struct {
+		Role    string `json:"role"`
+		Content []struct {
+			Type      string          `json:"type"`
+			Text      string          `json:"text"`
+			ID        string          `json:"id"`
+			Name      string          `json:"name"`
+			Input     json.RawMessage `json:"input"`
+			ToolUseID string          `json:"tool_use_id"`
+			Content   json.RawMessage `json:"content"`
+			IsError   bool            `json:"is_error"`
+		} `json:"content"`
+	} `json:"messages"`
+	Tools []*tool `json:"tools"`
+}
+
+type tool struct {
+	Name        string `json:"name"`
+	InputSchema struct {
+		Type       string         `json:"type"`
+		Properties map[string]any `json:"properties"`
+		Required   []string       `json:"required"`
+	} `json:"input_schema"`
+}
+
+// server answers Messages API requests with the given responses, in order,
+// and records the requests.
+type server struct {
+	t         *testing.T
+	responses []string
+
+	mu       sync.Mutex
+	requests []*request
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/messages" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	req := &request{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		s.t.Errorf("invalid request: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+	if len(s.requests) > len(s.responses) {
+		s.t.Errorf("got %d requests, want %d", len(s.requests), len(s.responses))
+		http.Error(w, "no more responses", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, s.responses[len(s.requests)-1])
+}
+
+// TestMain runs the tests in a workspace with a BUILD file. It is shared by
+// the tests, since the workspace is only looked up once.
+func TestMain(m *testing.M) {
+	ws, err := os.MkdirTemp("", "ws")
+	if err != nil {
+		panic(err)
+	}
+	for name, content := range map[string]string{
+		"MODULE.bazel": "",
+		"BUILD":        "go_library(name = \"lib\")\n",
+	} {
+		if err := os.WriteFile(filepath.Join(ws, name), []byte(content), 0644); err != nil {
+			panic(err)
+		}
+	}
+	if err := os.Chdir(ws); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(ws)
+	os.Exit(code)
+}
+
+// setUp starts a server that answers with the responses and points the
+// agent at it.
+func setUp(t *testing.T, responses ...string) *server {
+	t.Helper()
+	s := &server{t: t, responses: responses}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	t.Setenv("ANTHROPIC_BASE_URL", "")
+	if err := os.MkdirAll(filepath.Join(home, ".ok"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := fmt.Sprintf("[agent.anthropic]\napi_key = test-key\nbase_url = %s\nmodel = test-model\n", ts.URL)
+	if err := os.WriteFile(filepath.Join(home, ".ok", "config"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func message(content string, inputTokens, outputTokens int) string {
+	return fmt.Sprintf(`{"id": "msg_1", "type": "message", "role": "assistant", "model": "test-model", "content": %s, "stop_reason": "end_turn", "usage": {"input_tokens": %d, "output_tokens": %d}}`, content, inputTokens, outputTokens)
+}
+
+// events returns the events of the session's turn.
+func events(s agent.Session) []*agent.Event {
+	var events []*agent.Event
+	for e := range s.Events() {
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestToolRoundTrip(t *testing.T) {
+	srv := setUp(t,
+		message(`[{"type": "text", "text": "Let me read it."}, {"type": "tool_use", "id": "toolu_1", "name": "read_file", "input": {"path":"BUILD"}}]`, 100, 20),
+		message(`[{"type": "text", "text": "It declares //:lib."}]`, 150, 10),
+		message(`[{"type": "text", "text": "Nothing else."}]`, 200, 5),
+	)
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := a.Start(&agent.Request{Prompt: "What does BUILD declare?", Context: strings.NewReader("some errors"), SystemPrompt: "Be brief."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range events(s) {
+		switch e.Type {
+		case agent.Text:
+			got = append(got, "text: "+e.Text)
+		case agent.ToolUse:
+			got = append(got, fmt.Sprintf("tool_use %s: %s %s", e.ToolUseID, e.ToolName, e.ToolInput))
+		case agent.ToolResult:
+			got = append(got, fmt.Sprintf("tool_result %s: %q error=%t", e.ToolUseID, e.Text, e.IsError))
+		case agent.Usage:
+			got = append(got, fmt.Sprintf("usage: %d", e.Tokens))
+		default:
+			got = append(got, string(e.Type))
+		}
+	}
+	want := []string{
+		"text: Let me read it.",
+		`tool_use toolu_1: read_file {"path":"BUILD"}`,
+		"usage: 120",
+		`tool_result toolu_1: "go_library(name = \"lib\")\n" error=false`,
+		"text: It declares //:lib.",
+		"usage: 160",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+
+	if len(srv.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(srv.requests))
+	}
+	first := srv.requests[0]
+	if first.Model != "test-model" || len(first.System) != 1 || first.System[0].Text != "Be brief." {
+		t.Errorf("got model %q and system %v, want test-model and the system prompt", first.Model, first.System)
+	}
+	if len(first.Messages) != 1 || !strings.Contains(first.Messages[0].Content[0].Text, "some errors") || !strings.Contains(first.Messages[0].Content[0].Text, "What does BUILD declare?") {
+		t.Errorf("got first messages %+v, want the prompt with its context", first.Messages)
+	}
+	i := slices.IndexFunc(first.Tools, func(t *tool) bool { return t.Name == "read_file" })
+	if i < 0 {
+		t.Fatalf("got tools %+v, want read_file", first.Tools)
+	}
+	if schema := first.Tools[i].InputSchema; schema.Type != "object" || schema.Properties["path"] == nil || !slices.Equal(schema.Required, []string{"path"}) {
+		t.Errorf("got read_file schema %+v, want an object with a required path", schema)
+	}
+
+	second := srv.requests[1].Messages
+	if len(second) != 3 {
+		t.Fatalf("got %d messages in the second request, want 3", len(second))
+	}
+	if m := second[1]; m.Role != "assistant" || len(m.Content) != 2 || m.Content[1].Type != "tool_use" || m.Content[1].ID != "toolu_1" {
+		t.Errorf("got assistant message %+v, want it to call read_file", m)
+	}
+	result := second[2]
+	if result.Role != "user" || len(result.Content) != 1 || result.Content[0].Type != "tool_result" || result.Content[0].ToolUseID != "toolu_1" || !strings.Contains(string(result.Content[0].Content), "go_library") {
+		t.Errorf("got user message %+v, want the result of read_file", result)
+	}
+
+	// The conversation is kept, so that the session can be resumed.
+	s, err = a.Resume(s.ID(), "Anything else?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events(s)
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if n := len(srv.requests[2].Messages); n != 5 {
+		t.Errorf("got %d messages after resuming, want 5", n)
+	}
+}
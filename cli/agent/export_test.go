@@ -0,0 +1,9 @@
+package agent
+
+// Test hooks for the agent_test package, which can't be in this package
+// since it drives sessions with the fake provider, which imports it.
+var (
+	RenderTurn = renderTurn
+	Pick       = &pick
+	Stdout     = &stdout
+)
@@ -0,0 +1,154 @@
+// Package fake implements an agent that replays scripted events, for testing
+// ok's handling of agent sessions without running a real agent.
+//
+// It is registered as the "fake" provider, which replays the script at the
+// path given by the agent.fake_script config key. A script is a JSON array
+// of turns, each an array of events:
+//
+//	[
+//	  [{"type": "text", "text": "Which fix? <select><option>A</option><option>B</option></select>"}],
+//	  [{"type": "tool_use", "tool_use_id": "1", "tool_name": "Read", "tool_input": {"file_path": "BUILD"}},
+//	   {"type": "tool_result", "tool_use_id": "1", "text": "..."},
+//	   {"type": "text", "text": "Done."}]
+//	]
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/config"
+)
+
+// Agent replays its turns: the first when a session starts, and the next one
+// each time a session is answered or resumed. Sessions end early when the
+// turns run out.
+type Agent struct {
+	Turns [][]*agent.Event
+
+	mu sync.Mutex
+	// messages are the prompts, contexts and answers the agent was given.
+	messages []string
+	next     int
+	sessions int
+}
+
+// New returns an agent that replays the given turns.
+func New(turns ...[]*agent.Event) *Agent {
+	return &Agent{Turns: turns}
+}
+
+// Load returns an agent that replays the script in path.
+func Load(path string) (*Agent, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &Agent{}
+	if err := json.Unmarshal(b, &a.Turns); err != nil {
+		return nil, fmt.Errorf("invalid fake agent script %s: %s", path, err)
+	}
+	return a, nil
+}
+
+// FromConfig returns an agent that replays the script given by the
+// agent.fake_script config key.
+func FromConfig() (agent.Agent, error) {
+	path := config.Get("agent.fake_script")
+	if path == "" {
+		return nil, fmt.Errorf("the fake agent needs a script; set the agent.fake_script config key")
+	}
+	return Load(path)
+}
+
+// Messages returns the prompts, contexts and answers that the agent was
+// given, in order.
+func (a *Agent) Messages() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string{}, a.messages...)
+}
+
+func (a *Agent) Name() string {
+	return "fake"
+}
+
+func (a *Agent) Start(req *agent.Request) (agent.Session, error) {
+	if req.Context != nil {
+		b, err := io.ReadAll(req.Context)
+		if err != nil {
+			return nil, err
+		}
+		a.record(string(b))
+	}
+	a.mu.Lock()
+	a.sessions++
+	id := fmt.Sprintf("fake-%d", a.sessions)
+	a.mu.Unlock()
+	return a.session(id, req.Prompt), nil
+}
+
+func (a *Agent) Resume(sessionID string, message string) (agent.Session, error) {
+	return a.session(sessionID, message), nil
+}
+
+func (a *Agent) session(id, message string) *session {
+	s := &session{agent: a, id: id}
+	s.play(message)
+	return s
+}
+
+func (a *Agent) record(message string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.messages = append(a.messages, message)
+}
+
+// nextTurn returns the events of the next turn, or nil if there are none.
+func (a *Agent) nextTurn() []*agent.Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.next >= len(a.Turns) {
+		return nil
+	}
+	a.next++
+	return a.Turns[a.next-1]
+}
+
+type session struct {
+	agent  *Agent
+	id     string
+	events chan *agent.Event
+}
+
+// play records the message and sends the events of the next turn.
+func (s *session) play(message string) {
+	s.agent.record(message)
+	events := s.agent.nextTurn()
+	s.events = make(chan *agent.Event, len(events))
+	for _, e := range events {
+		s.events <- e
+	}
+	close(s.events)
+}
+
+func (s *session) ID() string {
+	return s.id
+}
+
+func (s *session) Events() <-chan *agent.Event {
+	return s.events
+}
+
+func (s *session) Answer(text string) error {
+	s.play(text)
+	return nil
+}
+
+func (s *session) Close() error {
+	return nil
+}
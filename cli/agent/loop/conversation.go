@@ -0,0 +1,88 @@
+package loop
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/config"
+)
+
+// Roles of the messages of a conversation.
+const (
+	User      = "user"
+	Assistant = "assistant"
+)
+
+// Message is a message of a conversation. Its content is made of text, tool
+// use and tool result parts, which are the events that the session reports.
+type Message struct {
+	Role    string         `json:"role"`
+	Content []*agent.Event `json:"content"`
+}
+
+// Conversation is the history of a session, which is kept under
+// ~/.ok/sessions so that the session can be resumed.
+type Conversation struct {
+	ID       string     `json:"id"`
+	Provider string     `json:"provider"`
+	System   string     `json:"system"`
+	Messages []*Message `json:"messages"`
+}
+
+func newConversation(provider, system string) *Conversation {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &Conversation{
+		ID:       time.Now().Format("20060102-150405-") + hex.EncodeToString(b),
+		Provider: provider,
+		System:   system,
+	}
+}
+
+func loadConversation(provider, id string) (*Conversation, error) {
+	path, err := conversationPath(provider, id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("there is no %s session %q", provider, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Conversation{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %s", id, err)
+	}
+	return c, nil
+}
+
+func (c *Conversation) save() error {
+	path, err := conversationPath(c.Provider, c.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+func conversationPath(provider, id string) (string, error) {
+	okDir, err := config.OkDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(okDir, "sessions", provider, id+".json"), nil
+}
@@ -0,0 +1,169 @@
+// Package loop runs agent sessions for providers that only offer a model,
+// like the Anthropic and OpenAI APIs: it keeps the conversation, and runs the
+// tools that the model calls in ok itself, until the model is done.
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/agent/permission"
+	"ok.build/cli/log"
+)
+
+// maxSteps is how many messages the model may write in a turn, which stops
+// a model that keeps calling tools without finishing.
+const maxSteps = 50
+
+// Model is a language model that can call tools.
+type Model interface {
+	// Complete returns the model's next message in the conversation, which
+	// may call the tools, and the number of tokens that it used. It calls emit
+	// with each text and tool use part of the message, which models that
+	// stream their messages do as the part is written, and for text may do
+	// in several pieces.
+	Complete(ctx context.Context, c *Conversation, tools []*Tool, emit func(*agent.Event)) (*Message, int, error)
+}
+
+// Start starts a session with the model, for a provider with the given name.
+func Start(provider string, m Model, req *agent.Request) (agent.Session, error) {
+	policy, err := permission.Load()
+	if err != nil {
+		return nil, err
+	}
+	prompt := req.Prompt
+	if req.Context != nil {
+		b, err := io.ReadAll(req.Context)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 0 {
+			prompt = fmt.Sprintf("<context>\n%s\n</context>\n\n%s", b, prompt)
+		}
+	}
+	s := &session{model: m, conv: newConversation(provider, req.SystemPrompt), tools: Tools(), policy: policy}
+	s.turn(prompt)
+	return s, nil
+}
+
+// Resume continues an earlier session with the model.
+func Resume(provider string, m Model, sessionID string, message string) (agent.Session, error) {
+	policy, err := permission.Load()
+	if err != nil {
+		return nil, err
+	}
+	c, err := loadConversation(provider, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{model: m, conv: c, tools: Tools(), policy: policy}
+	s.turn(message)
+	return s, nil
+}
+
+type session struct {
+	model  Model
+	conv   *Conversation
+	tools  []*Tool
+	policy *permission.Policy
+	events chan *agent.Event
+	// err is the error that ended the last turn. It is set before events is
+	// closed.
+	err error
+}
+
+func (s *session) ID() string {
+	return s.conv.ID
+}
+
+func (s *session) Events() <-chan *agent.Event {
+	return s.events
+}
+
+func (s *session) Answer(text string) error {
+	s.turn(text)
+	return nil
+}
+
+func (s *session) Close() error {
+	return s.err
+}
+
+// turn adds the user's message to the conversation and starts the model's
+// turn.
+func (s *session) turn(text string) {
+	s.conv.Messages = append(s.conv.Messages, &Message{
+		Role:    User,
+		Content: []*agent.Event{{Type: agent.Text, Text: text}},
+	})
+	s.err = nil
+	s.events = make(chan *agent.Event)
+	go s.run(s.events)
+}
+
+// run asks the model for messages and runs the tools they call, until the
+// model writes a message that calls no tools.
+func (s *session) run(events chan<- *agent.Event) {
+	defer close(events)
+	defer func() {
+		if err := s.conv.save(); err != nil {
+			log.Debugf("Failed to save the session: %s", err)
+		}
+	}()
+	for range maxSteps {
+		emit := func(e *agent.Event) { events <- e }
+		msg, tokens, err := s.model.Complete(context.Background(), s.conv, s.tools, emit)
+		if err != nil {
+			s.err = err
+			return
+		}
+		events <- &agent.Event{Type: agent.Usage, Tokens: tokens}
+		s.conv.Messages = append(s.conv.Messages, msg)
+
+		var results []*agent.Event
+		for _, part := range msg.Content {
+			if part.Type != agent.ToolUse {
+				continue
+			}
+			result := &agent.Event{Type: agent.ToolResult, ToolUseID: part.ToolUseID}
+			result.Text, err = s.call(events, part)
+			if err != nil {
+				result.Text, result.IsError = err.Error(), true
+			}
+			events <- result
+			results = append(results, result)
+		}
+		if len(results) == 0 {
+			return
+		}
+		s.conv.Messages = append(s.conv.Messages, &Message{Role: User, Content: results})
+	}
+	s.err = fmt.Errorf("the agent stopped after %d steps without finishing", maxSteps)
+}
+
+// call runs the tool that a tool use calls, if the permission policy allows
+// it, and returns its output.
+func (s *session) call(events chan<- *agent.Event, part *agent.Event) (string, error) {
+	t := find(s.tools, part.ToolName)
+	if t == nil {
+		return "", fmt.Errorf("there is no tool named %q", part.ToolName)
+	}
+	input := Input(part)
+	if tool, subject := t.Permission(input); !s.policy.Allowed(events, tool, subject) {
+		return "", fmt.Errorf("the user doesn't allow using %s on %s", tool, subject)
+	}
+	out, err := t.Run(input)
+	return truncate(out), err
+}
+
+// Input returns the input of a tool use as a JSON object, which is empty if
+// the model gave none.
+func Input(part *agent.Event) json.RawMessage {
+	if len(part.ToolInput) == 0 {
+		return json.RawMessage("{}")
+	}
+	return part.ToolInput
+}
@@ -0,0 +1,267 @@
+package loop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"ok.build/cli/agent/permission"
+	"ok.build/cli/arg"
+	"ok.build/cli/bazelisk"
+)
+
+// maxOutput is how much of a tool's output is given to the agent. Longer
+// outputs keep their beginning and end.
+const maxOutput = 32 * 1024
+
+// bazelCommands are the bazel commands that the bazel tool may run.
+var bazelCommands = []string{"query", "cquery", "build"}
+
+// Tool is a tool that the agent can call, which ok runs itself.
+type Tool struct {
+	Name        string
+	Description string
+	// Properties describes the properties of the tool's input object as JSON
+	// schemas, and Required lists those that must be set.
+	Properties map[string]any
+	Required   []string
+	// Run runs the tool with its input object and returns its output.
+	Run func(input json.RawMessage) (string, error)
+	// Permission returns the tool and subject that the permission policy
+	// checks for a use of the tool.
+	Permission func(input json.RawMessage) (tool, subject string)
+}
+
+// Tools returns the tools that agents get: reading, editing and listing
+// files in the workspace, and running bazel.
+func Tools() []*Tool {
+	return []*Tool{
+		{
+			Name:        "read_file",
+			Description: "Reads a file of the workspace. Paths are relative to the workspace root.",
+			Properties: map[string]any{
+				"path": map[string]any{"type": "string", "description": "The path of the file."},
+			},
+			Required:   []string{"path"},
+			Run:        readFile,
+			Permission: pathPermission("Read"),
+		},
+		{
+			Name:        "edit_file",
+			Description: "Edits a file of the workspace by replacing old_text, which must occur exactly once in the file, with new_text. If old_text is empty, the file is created, or replaced, with new_text.",
+			Properties: map[string]any{
+				"path":     map[string]any{"type": "string", "description": "The path of the file, relative to the workspace root."},
+				"old_text": map[string]any{"type": "string", "description": "The text to replace, including enough context to be unique."},
+				"new_text": map[string]any{"type": "string", "description": "The text to replace it with."},
+			},
+			Required:   []string{"path", "old_text", "new_text"},
+			Run:        editFile,
+			Permission: pathPermission("Edit"),
+		},
+		{
+			Name:        "list_directory",
+			Description: "Lists a directory of the workspace. Subdirectories end with a slash.",
+			Properties: map[string]any{
+				"path": map[string]any{"type": "string", "description": "The path of the directory, relative to the workspace root. Defaults to the root."},
+			},
+			Run:        listDirectory,
+			Permission: pathPermission("Read"),
+		},
+		{
+			Name:        "bazel",
+			Description: "Runs `bazel query`, `bazel cquery` or `bazel build` in the workspace and returns its output and exit code. Use query to find targets and their dependencies, and build to check a fix.",
+			Properties: map[string]any{
+				"command": map[string]any{"type": "string", "enum": bazelCommands},
+				"target":  map[string]any{"type": "string", "description": "The target pattern to build, or the query expression, like deps(//foo:bar)."},
+			},
+			Required:   []string{"command", "target"},
+			Run:        runBazel,
+			Permission: bazelPermission,
+		},
+	}
+}
+
+// find returns the tool with the given name, or nil if there is none.
+func find(tools []*Tool, name string) *Tool {
+	i := slices.IndexFunc(tools, func(t *Tool) bool { return t.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return tools[i]
+}
+
+// pathPermission returns the permission of tools that take a path, which
+// are checked as the given tool of the claude CLI.
+func pathPermission(tool string) func(json.RawMessage) (string, string) {
+	return func(input json.RawMessage) (string, string) {
+		var in struct {
+			Path string `json:"path"`
+		}
+		json.Unmarshal(input, &in)
+		return tool, permission.Path(in.Path)
+	}
+}
+
+// bazelPermission checks the bazel tool as the bazel command line it runs.
+// The target is quoted, since it is a single argument of bazel: query
+// expressions like deps(//foo:bar) hold characters that the shell would
+// read as operators.
+func bazelPermission(input json.RawMessage) (string, string) {
+	var in struct {
+		Command string `json:"command"`
+		Target  string `json:"target"`
+	}
+	json.Unmarshal(input, &in)
+	return "Bash", arg.JoinShell([]string{"bazel", in.Command, in.Target})
+}
+
+func readFile(input json.RawMessage) (string, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		return "", err
+	}
+	path, err := absPath(in.Path)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	return string(b), err
+}
+
+func editFile(input json.RawMessage) (string, error) {
+	var in struct {
+		Path    string `json:"path"`
+		OldText string `json:"old_text"`
+		NewText string `json:"new_text"`
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		return "", err
+	}
+	path, err := resolve(in.Path)
+	if err != nil {
+		return "", err
+	}
+	if in.OldText == "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(path, []byte(in.NewText), 0644); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Wrote %s.", in.Path), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	switch n := strings.Count(string(b), in.OldText); n {
+	case 0:
+		return "", fmt.Errorf("old_text was not found in %s", in.Path)
+	case 1:
+	default:
+		return "", fmt.Errorf("old_text occurs %d times in %s; include more context to make it unique", n, in.Path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(b), in.OldText, in.NewText, 1)), fi.Mode()); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Edited %s.", in.Path), nil
+}
+
+func listDirectory(input json.RawMessage) (string, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		return "", err
+	}
+	path, err := absPath(in.Path)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\n"), nil
+}
+
+func runBazel(input json.RawMessage) (string, error) {
+	var in struct {
+		Command string `json:"command"`
+		Target  string `json:"target"`
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		return "", err
+	}
+	if !slices.Contains(bazelCommands, in.Command) {
+		return "", fmt.Errorf("unsupported bazel command %q, expected one of %s", in.Command, strings.Join(bazelCommands, ", "))
+	}
+	if in.Target == "" {
+		return "", fmt.Errorf("no target given")
+	}
+	out := &bytes.Buffer{}
+	exitCode, err := bazelisk.Run([]string{in.Command, "--color=no", "--curses=no", "--", in.Target}, &bazelisk.RunOpts{Stdout: out, Stderr: out})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("bazel %s exited with code %d.\n%s", in.Command, exitCode, out.String()), nil
+}
+
+// absPath returns the absolute path of a path given by the agent, which is
+// relative to the workspace root, or the current directory outside of a
+// workspace. Reads may go wherever the Read rules allow, including through
+// symlinks like bazel-testlogs.
+func absPath(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	root, err := permission.Root()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, path), nil
+}
+
+// resolve is like absPath for the files that the agent edits, which must be
+// in the workspace. Paths outside of it, including through symlinks like
+// bazel-bin, are refused.
+func resolve(path string) (string, error) {
+	abs, rel, err := permission.Resolve(path)
+	if err != nil {
+		return "", err
+	}
+	if rel == "" {
+		return "", fmt.Errorf("%s is outside of the workspace", abs)
+	}
+	return abs, nil
+}
+
+// truncate shortens s to maxOutput, keeping its beginning and end, which
+// hold what a command did and how it ended.
+func truncate(s string) string {
+	if len(s) <= maxOutput {
+		return s
+	}
+	half := maxOutput / 2
+	return strings.ToValidUTF8(s[:half], "") + fmt.Sprintf("\n… (%d bytes omitted) …\n", len(s)-2*half) + strings.ToValidUTF8(s[len(s)-half:], "")
+}
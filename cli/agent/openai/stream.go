@@ -0,0 +1,163 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/agent/loop"
+)
+
+// maxLineSize is the longest event that the stream may send.
+const maxLineSize = 16 * 1024 * 1024
+
+// chunk is an event of a streamed message.
+type chunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string      `json:"content"`
+			ToolCalls []*toolCall `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// stream puts together the message that the model streams.
+type stream struct {
+	emit func(*agent.Event)
+	msg  *loop.Message
+	text strings.Builder
+	// flushed is how much of text was emitted.
+	flushed int
+	call    *toolCall
+	tokens  int
+}
+
+// readStream reads a message streamed as server-sent events, and returns it
+// with the number of tokens used. Text is emitted a line at a time as it
+// arrives, and tool calls once they are complete.
+func readStream(r io.Reader, emit func(*agent.Event)) (*loop.Message, int, error) {
+	s := &stream{emit: emit, msg: &loop.Message{Role: loop.Assistant}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	done := false
+	for !done && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			// Comments, event names and the blank lines between events.
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		c := &chunk{}
+		if err := json.Unmarshal([]byte(data), c); err != nil {
+			return nil, 0, fmt.Errorf("invalid event in the streamed message: %s", err)
+		}
+		if err := s.add(c); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.endText()
+	s.endCall()
+	return s.msg, s.tokens, nil
+}
+
+func (s *stream) add(c *chunk) error {
+	if c.Error != nil {
+		return fmt.Errorf("the model failed: %s", c.Error.Message)
+	}
+	if c.Usage != nil {
+		s.tokens = c.Usage.TotalTokens
+	}
+	for _, choice := range c.Choices {
+		s.text.WriteString(choice.Delta.Content)
+		for _, delta := range choice.Delta.ToolCalls {
+			// Text written before a tool call is complete once the call
+			// starts, and a call once the next one starts.
+			s.endText()
+			if s.call != nil && s.call.Index != delta.Index {
+				s.endCall()
+			}
+			if s.call == nil {
+				s.call = &toolCall{Index: delta.Index}
+			}
+			if delta.ID != "" {
+				s.call.ID = delta.ID
+			}
+			s.call.Function.Name += delta.Function.Name
+			s.call.Function.Arguments += delta.Function.Arguments
+		}
+	}
+	s.flushText(false)
+	return nil
+}
+
+// flushText emits the text that arrived since it was last flushed, up to the
+// last complete line unless all is set, as a continuation of the text
+// emitted before. A choice offered with <select> is
+// held back until it is complete, since it is only recognized in a single
+// event.
+func (s *stream) flushText(all bool) {
+	text := s.text.String()
+	end := len(text)
+	if !all {
+		end = strings.LastIndex(text, "\n") + 1
+		if i := strings.LastIndex(text, "<select>"); i >= 0 && i < end && !strings.Contains(text[i:], "</select>") {
+			end = i
+		}
+	}
+	if end <= s.flushed {
+		return
+	}
+	if pending := text[s.flushed:end]; strings.TrimSpace(pending) != "" {
+		continued := strings.TrimSpace(text[:s.flushed]) != ""
+		s.emit(&agent.Event{Type: agent.Text, Text: pending, Continued: continued})
+	}
+	s.flushed = end
+}
+
+// endText emits the rest of the text, which is complete once a tool call
+// starts or the message ends, and adds the whole text to the message.
+func (s *stream) endText() {
+	s.flushText(true)
+	if text := s.text.String(); strings.TrimSpace(text) != "" {
+		s.msg.Content = append(s.msg.Content, &agent.Event{Type: agent.Text, Text: text})
+	}
+	s.text.Reset()
+	s.flushed = 0
+}
+
+func (s *stream) endCall() {
+	if s.call == nil {
+		return
+	}
+	e := &agent.Event{Type: agent.ToolUse, ToolUseID: s.call.ID, ToolName: s.call.Function.Name}
+	if args := s.call.Function.Arguments; args != "" {
+		if json.Valid([]byte(args)) {
+			e.ToolInput = json.RawMessage(args)
+		} else {
+			// The tool will fail to read it, and tell the model why.
+			e.ToolInput, _ = json.Marshal(args)
+		}
+	}
+	if e.ToolUseID == "" {
+		e.ToolUseID = fmt.Sprintf("call_%d", len(s.msg.Content))
+	}
+	s.msg.Content = append(s.msg.Content, e)
+	s.emit(e)
+	s.call = nil
+}
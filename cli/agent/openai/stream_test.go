@@ -0,0 +1,154 @@
+package openai
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"ok.build/cli/agent"
+)
+
+// describe formats an event for comparison.
+func describe(e *agent.Event) string {
+	switch e.Type {
+	case agent.Text:
+		if e.Continued {
+			return fmt.Sprintf("text continued %q", e.Text)
+		}
+		return fmt.Sprintf("text %q", e.Text)
+	case agent.ToolUse:
+		return fmt.Sprintf("tool_use %s: %s %s", e.ToolUseID, e.ToolName, e.ToolInput)
+	}
+	return string(e.Type)
+}
+
+// sse formats chunks as server-sent events.
+func sse(chunks ...string) string {
+	var b strings.Builder
+	for _, c := range chunks {
+		fmt.Fprintf(&b, "data: %s\n\n", c)
+	}
+	return b.String()
+}
+
+func text(s string) string {
+	return fmt.Sprintf(`{"choices": [{"delta": {"content": %q}}]}`, s)
+}
+
+func call(index int, id, name, args string) string {
+	return fmt.Sprintf(`{"choices": [{"delta": {"tool_calls": [{"index": %d, "id": %q, "function": {"name": %q, "arguments": %q}}]}}]}`, index, id, name, args)
+}
+
+func TestReadStream(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		stream string
+		// emitted are the events in the order they are emitted, and parts
+		// the parts of the message.
+		emitted []string
+		parts   []string
+		tokens  int
+		err     string
+	}{
+		{
+			name:    "text is emitted a line at a time",
+			stream:  sse(text("Hel"), text("lo\nWor"), text("ld"), "[DONE]"),
+			emitted: []string{`text "Hello\n"`, `text continued "World"`},
+			parts:   []string{`text "Hello\nWorld"`},
+		},
+		{
+			name:    "choices are held back until complete",
+			stream:  sse(text("Which fix?\n<select>\n<option>A</option>\n"), text("<option>B</option>\n</select>\n"), "[DONE]"),
+			emitted: []string{`text "Which fix?\n"`, `text continued "<select>\n<option>A</option>\n<option>B</option>\n</select>\n"`},
+			parts:   []string{`text "Which fix?\n<select>\n<option>A</option>\n<option>B</option>\n</select>\n"`},
+		},
+		{
+			name: "interleaved text and tool calls",
+			stream: sse(
+				text("Let me look"),
+				text(" at the file."),
+				call(0, "call_a", "read_file", `{"pa`),
+				call(0, "", "", `th":"BUILD"}`),
+				call(1, "call_b", "list_directory", ""),
+				call(1, "", "", "{}"),
+				"[DONE]",
+			),
+			emitted: []string{
+				`text "Let me look at the file."`,
+				`tool_use call_a: read_file {"path":"BUILD"}`,
+				`tool_use call_b: list_directory {}`,
+			},
+			parts: []string{
+				`text "Let me look at the file."`,
+				`tool_use call_a: read_file {"path":"BUILD"}`,
+				`tool_use call_b: list_directory {}`,
+			},
+		},
+		{
+			name:    "tool calls without an ID or with invalid arguments",
+			stream:  sse(call(0, "", "read_file", `{"path": `), "[DONE]"),
+			emitted: []string{`tool_use call_0: read_file "{\"path\": "`},
+			parts:   []string{`tool_use call_0: read_file "{\"path\": "`},
+		},
+		{
+			name:    "usage",
+			stream:  sse(text("Done."), `{"choices": [], "usage": {"prompt_tokens": 30, "completion_tokens": 12, "total_tokens": 42}}`, "[DONE]"),
+			emitted: []string{`text "Done."`},
+			parts:   []string{`text "Done."`},
+			tokens:  42,
+		},
+		{
+			name:    "events after done are ignored",
+			stream:  sse(text("Done."), "[DONE]", text("More.")),
+			emitted: []string{`text "Done."`},
+			parts:   []string{`text "Done."`},
+		},
+		{
+			name:    "comments and event names are skipped",
+			stream:  ": keep-alive\n\nevent: chunk\n" + sse(text("Done.")),
+			emitted: []string{`text "Done."`},
+			parts:   []string{`text "Done."`},
+		},
+		{
+			name:    "error",
+			stream:  sse(text("Let me\n"), `{"error": {"message": "the server is overloaded"}}`),
+			emitted: []string{`text "Let me\n"`},
+			err:     "the model failed: the server is overloaded",
+		},
+		{
+			name:   "invalid event",
+			stream: sse("{"),
+			err:    "invalid event in the streamed message: unexpected end of JSON input",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var emitted []string
+			msg, tokens, err := readStream(strings.NewReader(tc.stream), func(e *agent.Event) {
+				emitted = append(emitted, describe(e))
+			})
+			if !slices.Equal(emitted, tc.emitted) {
+				t.Errorf("got emitted\n%s\nwant\n%s", strings.Join(emitted, "\n"), strings.Join(tc.emitted, "\n"))
+			}
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var parts []string
+			for _, part := range msg.Content {
+				parts = append(parts, describe(part))
+			}
+			if !slices.Equal(parts, tc.parts) {
+				t.Errorf("got parts\n%s\nwant\n%s", strings.Join(parts, "\n"), strings.Join(tc.parts, "\n"))
+			}
+			if tokens != tc.tokens {
+				t.Errorf("got %d tokens, want %d", tokens, tc.tokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+// Package permission decides which tools agents may use, following a policy
+// set in the [permissions] section of the config:
+//
+//	[permissions]
+//	allow = Bash(bazel query *)
+//	ask = Bash(rm *)
+//	deny = Edit(/*)
+//	default = ask
+//
+// Rules name a tool, optionally followed by a pattern in parentheses that the
+// command or path the tool is used on must match, in which * matches any
+// text. Paths in the workspace are relative to its root, and paths outside of
+// it are absolute, so Edit(/*) denies writes outside of the workspace. Tool
+// names are those of the claude CLI; the tools of the other agents are
+// checked as Read, Edit and Bash.
+//
+// Deny rules take precedence over ask rules, which take precedence over allow
+// rules. Tool uses that no rule matches get the default decision, which is to
+// ask. Answers are remembered for the rest of the session.
+package permission
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/config"
+	"ok.build/cli/workspace"
+)
+
+// Decision is what a policy decides about a tool use.
+type Decision string
+
+const (
+	Allow Decision = "allow"
+	Ask   Decision = "ask"
+	Deny  Decision = "deny"
+)
+
+// defaultRules come before the rules of the config. They allow what the
+// agents can't change anything with, and deny writes outside of the
+// workspace.
+var defaultRules = []*Rule{
+	{Decision: Allow, Tool: "Read"},
+	{Decision: Allow, Tool: "TodoWrite"},
+	{Decision: Allow, Tool: "Bash", Pattern: "bazel query *"},
+	{Decision: Allow, Tool: "Bash", Pattern: "bazel cquery *"},
+	{Decision: Deny, Tool: "Edit", Pattern: "/*"},
+}
+
+// aliases are tools that rules for another tool apply to.
+var aliases = map[string]string{
+	"Glob":         "Read",
+	"Grep":         "Read",
+	"LS":           "Read",
+	"NotebookRead": "Read",
+	"Write":        "Edit",
+	"MultiEdit":    "Edit",
+	"NotebookEdit": "Edit",
+}
+
+var rulePattern = regexp.MustCompile(`^(\w+)(?:\((.*)\))?$`)
+
+// Rule decides about the uses of a tool, or of those that match a pattern.
+type Rule struct {
+	Decision Decision
+	Tool     string
+	// Pattern is empty for rules that match every use of the tool.
+	Pattern string
+}
+
+// ParseRule parses a rule like Bash(rm *).
+func ParseRule(d Decision, s string) (*Rule, error) {
+	m := rulePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, fmt.Errorf("invalid permission rule %q, expected Tool or Tool(pattern)", s)
+	}
+	return &Rule{Decision: d, Tool: m[1], Pattern: m[2]}, nil
+}
+
+func (r *Rule) String() string {
+	if r.Pattern == "" {
+		return r.Tool
+	}
+	return fmt.Sprintf("%s(%s)", r.Tool, r.Pattern)
+}
+
+// AppliesTo tells whether the rule is about the given tool.
+func (r *Rule) AppliesTo(tool string) bool {
+	return r.Tool == tool || r.Tool == aliases[tool]
+}
+
+// Matches tells whether the rule applies to the use of a tool on subject. In
+// the patterns of rules that allow Bash commands, * doesn't match the shell's
+// operators, so that an allowed command can't be chained with another one.
+func (r *Rule) Matches(tool, subject string) bool {
+	if !r.AppliesTo(tool) {
+		return false
+	}
+	if r.Pattern == "" {
+		return true
+	}
+	if r.Decision == Allow && r.Tool == "Bash" {
+		ops := operatorCounts(subject)
+		return match(r.Pattern, subject, func(i, j int) bool { return ops[i] == ops[j] })
+	}
+	return Match(r.Pattern, subject)
+}
+
+// Match tells whether s matches the pattern, in which * matches any text. A
+// pattern ending with :*, as the claude CLI writes prefixes, matches the
+// text before it followed by anything.
+func Match(pattern, s string) bool {
+	return match(pattern, s, func(int, int) bool { return true })
+}
+
+// match is like Match, but * only matches the text s[i:j] if star(i, j).
+func match(pattern, s string, star func(i, j int) bool) bool {
+	if prefix, ok := strings.CutSuffix(pattern, ":*"); ok {
+		pattern = prefix + "*"
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s[pos:], part)
+		if i < 0 || !star(pos, pos+i) {
+			return false
+		}
+		pos += i + len(part)
+	}
+	last := parts[len(parts)-1]
+	return len(s)-pos >= len(last) && strings.HasSuffix(s, last) && star(pos, len(s)-len(last))
+}
+
+// Policy decides which tools an agent may use in a session.
+type Policy struct {
+	Rules   []*Rule
+	Default Decision
+
+	mu sync.Mutex
+	// answers are the user's answers about tool uses, by tool and subject.
+	answers map[string]bool
+}
+
+// Load returns the policy set in the config, for a new session.
+func Load() (*Policy, error) {
+	p := &Policy{Rules: append([]*Rule{}, defaultRules...), Default: Ask}
+	for _, d := range []Decision{Allow, Ask, Deny} {
+		for _, s := range config.GetAll("permissions." + string(d)) {
+			r, err := ParseRule(d, s)
+			if err != nil {
+				return nil, err
+			}
+			p.Rules = append(p.Rules, r)
+		}
+	}
+	switch d := Decision(config.Get("permissions.default")); d {
+	case "":
+	case Allow, Ask, Deny:
+		p.Default = d
+	default:
+		return nil, fmt.Errorf("invalid permissions.default %q, expected allow, ask or deny", d)
+	}
+	return p, nil
+}
+
+// Check returns the decision about the use of a tool on subject, which is
+// the user's answer if they were already asked. A Bash command line is only
+// allowed if each command that it runs is, and denied if any is.
+func (p *Policy) Check(tool, subject string) Decision {
+	d := p.decide(tool, subject)
+	if commands := SplitCommands(subject); tool == "Bash" && d != Deny && len(commands) > 0 {
+		// Rules about the whole command line still apply.
+		if !p.matches(Ask, tool, subject) {
+			d = Allow
+		}
+		for _, c := range commands {
+			switch p.decide(tool, c) {
+			case Deny:
+				return Deny
+			case Ask:
+				d = Ask
+			}
+		}
+	}
+	if d != Ask {
+		return d
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if allowed, ok := p.answers[tool+"\x00"+subject]; ok {
+		if allowed {
+			return Allow
+		}
+		return Deny
+	}
+	return Ask
+}
+
+// decide returns the decision of the rules about the use of a tool on
+// subject.
+func (p *Policy) decide(tool, subject string) Decision {
+	for _, d := range []Decision{Deny, Ask, Allow} {
+		if p.matches(d, tool, subject) {
+			return d
+		}
+	}
+	return p.Default
+}
+
+func (p *Policy) matches(d Decision, tool, subject string) bool {
+	for _, r := range p.Rules {
+		if r.Decision == d && r.Matches(tool, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remember records the user's answer about the use of a tool on subject.
+func (p *Policy) Remember(tool, subject string, allowed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.answers == nil {
+		p.answers = map[string]bool{}
+	}
+	p.answers[tool+"\x00"+subject] = allowed
+}
+
+// Allowed tells whether the agent may use a tool on subject. When the policy
+// leaves it to the user, they are asked with a Permission event sent on
+// events, which waits for their answer.
+func (p *Policy) Allowed(events chan<- *agent.Event, tool, subject string) bool {
+	switch p.Check(tool, subject) {
+	case Allow:
+		return true
+	case Deny:
+		return false
+	}
+	reply := make(chan bool, 1)
+	events <- &agent.Event{Type: agent.Permission, ToolName: tool, Text: subject, Reply: reply}
+	allowed := <-reply
+	p.Remember(tool, subject, allowed)
+	return allowed
+}
+
+// Root returns the directory that relative paths of rules are in: the
+// workspace root, or the current directory outside of a workspace.
+func Root() (string, error) {
+	if root, err := workspace.Path(); err == nil {
+		return root, nil
+	}
+	return os.Getwd()
+}
+
+// Path returns the subject of a tool use on path, as rules see it: relative
+// to the workspace root if it is in the workspace, and absolute otherwise.
+func Path(path string) string {
+	abs, rel, err := Resolve(path)
+	if err != nil {
+		return path
+	}
+	if rel == "" {
+		return abs
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Resolve returns the absolute path of path, which is relative to Root
+// unless it is absolute, and its path relative to Root, which is empty if
+// it is outside of it. Symlinks are resolved, so that links like bazel-bin,
+// which point out of the workspace, don't count as in it.
+func Resolve(path string) (abs, rel string, err error) {
+	root, err := Root()
+	if err != nil {
+		return "", "", err
+	}
+	root = evalSymlinks(root)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	abs = evalSymlinks(filepath.Clean(path))
+	rel, err = filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return abs, "", nil
+	}
+	return abs, rel, nil
+}
+
+// evalSymlinks resolves the symlinks in path, including in paths that don't
+// exist yet, like a file that is about to be written: the longest prefix of
+// the path that exists is resolved.
+func evalSymlinks(path string) string {
+	rest := ""
+	for p := path; ; p = filepath.Dir(p) {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		if filepath.Dir(p) == p {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+	}
+}
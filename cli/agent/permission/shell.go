@@ -0,0 +1,161 @@
+package permission
+
+import (
+	"strings"
+)
+
+// shellOperators are the characters that end a command or start another one
+// in a shell command line, or redirect its input or output.
+const shellOperators = ";&|\n()`$<>"
+
+// operatorCounts returns, for each position in a command line, the number of
+// operators before it that the shell interprets, which aren't quoted or
+// escaped.
+func operatorCounts(line string) []int {
+	counts := make([]int, len(line)+1)
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		op := false
+		switch {
+		case quote == '\'':
+			if c == quote {
+				quote = 0
+			}
+		case c == '\\' && i+1 < len(line):
+			counts[i+1] = counts[i]
+			i++
+		case c == '"':
+			quote ^= '"'
+		case c == '`' || (c == '$' && strings.HasPrefix(line[i+1:], "(")):
+			op = true
+		case quote == 0 && c == '\'':
+			quote = c
+		case quote == 0:
+			op = strings.IndexByte(shellOperators, c) >= 0 && c != '$'
+		}
+		counts[i+1] = counts[i]
+		if op {
+			counts[i+1]++
+		}
+	}
+	return counts
+}
+
+// SplitCommands splits a shell command line into the commands that it runs:
+// those separated by ;, &, &&, |, || and newlines, those in subshells, and
+// those substituted with $(...) or backticks. Redirections are split off too,
+// like "> out.txt", except those to /dev/null and between file descriptors.
+// Text in single quotes is never split, and text in double quotes only for
+// substitutions.
+func SplitCommands(line string) []string {
+	s := &splitter{line: line}
+	s.list(0)
+	return s.commands
+}
+
+type splitter struct {
+	line     string
+	i        int
+	commands []string
+}
+
+// list reads commands until the byte that ends the substitution or subshell
+// they are in, which is 0 at the top level.
+func (s *splitter) list(until byte) {
+	cur := &strings.Builder{}
+	end := func() {
+		if c := strings.TrimSpace(cur.String()); c != "" {
+			s.commands = append(s.commands, c)
+		}
+		cur.Reset()
+	}
+	defer end()
+	quoted := false
+	for s.i < len(s.line) {
+		c := s.line[s.i]
+		s.i++
+		switch {
+		case c == '\\' && s.i < len(s.line):
+			cur.WriteByte(c)
+			cur.WriteByte(s.line[s.i])
+			s.i++
+		case c == '\'' && !quoted:
+			n := strings.IndexByte(s.line[s.i:], '\'') + 1
+			if n == 0 {
+				n = len(s.line) - s.i
+			}
+			cur.WriteByte(c)
+			cur.WriteString(s.line[s.i : s.i+n])
+			s.i += n
+		case c == '"':
+			quoted = !quoted
+			cur.WriteByte(c)
+		case c == '$' && strings.HasPrefix(s.line[s.i:], "("):
+			s.i++
+			s.list(')')
+		case c == '`':
+			if until == '`' {
+				return
+			}
+			s.list('`')
+		case quoted:
+			cur.WriteByte(c)
+		case c == ')' && until == ')':
+			return
+		case c == '(' || c == ')':
+			end()
+			if c == '(' {
+				s.list(')')
+			}
+		case c == '<' || c == '>' || (c == '&' && strings.HasPrefix(s.line[s.i:], ">")):
+			s.i--
+			s.redirect(cur)
+		case c == ';' || c == '&' || c == '|' || c == '\n':
+			end()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+}
+
+// redirect reads a redirection, and adds it to the commands unless it is to
+// /dev/null or between file descriptors. The file descriptor that it may
+// start with is taken off cur.
+func (s *splitter) redirect(cur *strings.Builder) {
+	if text := cur.String(); len(text) > 0 {
+		trimmed := strings.TrimRight(text, "0123456789")
+		if trimmed != text && (trimmed == "" || strings.HasSuffix(trimmed, " ")) {
+			cur.Reset()
+			cur.WriteString(trimmed)
+		}
+	}
+	start := s.i
+	if strings.HasPrefix(s.line[s.i:], "&") {
+		s.i++
+	}
+	for s.i < len(s.line) && strings.IndexByte("<>", s.line[s.i]) >= 0 {
+		s.i++
+	}
+	if s.i < len(s.line) && strings.IndexByte("&|", s.line[s.i]) >= 0 {
+		s.i++
+	}
+	op := s.line[start:s.i]
+	for s.i < len(s.line) && s.line[s.i] == ' ' {
+		s.i++
+	}
+	wordStart := s.i
+	for s.i < len(s.line) && !strings.ContainsRune(" \t"+shellOperators, rune(s.line[s.i])) {
+		if q := s.line[s.i]; q == '\'' || q == '"' {
+			if n := strings.IndexByte(s.line[s.i+1:], q); n >= 0 {
+				s.i += n + 1
+			}
+		}
+		s.i++
+	}
+	word := s.line[wordStart:s.i]
+	if word == "/dev/null" || (strings.HasSuffix(op, "&") && strings.Trim(word, "0123456789-") == "") {
+		return
+	}
+	s.commands = append(s.commands, strings.TrimSpace(op+" "+word))
+}
@@ -0,0 +1,457 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+	"ok.build/cli/checkpoint"
+	"ok.build/cli/config"
+	"ok.build/cli/picker"
+	"ok.build/cli/textarea"
+)
+
+// defaultSystemPrompt is used unless the `agent.system_prompt` config key is
+// set.
+const defaultSystemPrompt = "You are a Bazel expert and you are helping the user fix a Bazel error. " +
+	"If no workspace is found, you will help the user migrate the project to Bazel using bzlmod. " +
+	"If the fix is not straightforward, think of 3 possible fixes and present them to the user using the <select><option>...</option></select> syntax. " +
+	"If asking the user a yes/no question, use the <select><option>...</option></select> syntax. "
+
+// interactivePrompt and autonomousPrompt are added to the system prompt of
+// requests that are and aren't interactive.
+const (
+	interactivePrompt = "The user wants to work through this with you: before changing any file, explain what you found and let the user choose how to proceed."
+	autonomousPrompt  = "The user wants you to do this on your own: rather than offering choices, pick the most likely fix, apply it and check it, and only ask the user a question if you can't go on without their answer."
+)
+
+var (
+	selectPattern = regexp.MustCompile(`<select>((?s).*?)</select>`)
+	// Extract options with attributes - match anything between <option and > for attributes
+	optionPattern = regexp.MustCompile(`<option([^>]*)>([^<]+)</option>`)
+)
+
+// stdout is the terminal that sessions are rendered on. Tools that the agent
+// runs in ok, like bazel, may redirect os.Stdout while they run.
+var stdout = os.Stdout
+
+var usedTokens int = 0
+var startTime time.Time = time.Now()
+
+// SystemPrompt returns the system prompt given to agents, which tells them
+// how to offer choices to the user.
+func SystemPrompt() string {
+	if p := config.Get("agent.system_prompt"); p != "" {
+		return p
+	}
+	return defaultSystemPrompt
+}
+
+// Run starts a session with the agent and renders it, until the agent is
+// done. When the agent offers choices, the user picks one with the picker and
+// the agent is given the answer. It returns the ID of the session. The
+// system prompt tells the agent whether the request is interactive.
+//
+// The working tree is checkpointed before the session, and the changes that
+// the agent made are listed after it, so that ok undo can revert them.
+func Run(a Agent, req *Request) (sessionID string, err error) {
+	if req.SystemPrompt == "" {
+		req.SystemPrompt = SystemPrompt()
+	}
+	if req.Interactive {
+		req.SystemPrompt += "\n\n" + interactivePrompt
+	} else {
+		req.SystemPrompt += "\n\n" + autonomousPrompt
+	}
+	return run(func() (Session, error) { return a.Start(req) })
+}
+
+// Continue resumes an earlier session of the agent with a message from the
+// user, and renders it like Run. It returns the ID of the session.
+func Continue(a Agent, sessionID, message string) (string, error) {
+	return run(func() (Session, error) { return a.Resume(sessionID, message) })
+}
+
+func run(start func() (Session, error)) (sessionID string, err error) {
+	cp, err := checkpoint.Create("ok " + strings.Join(os.Args[1:], " "))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to checkpoint the working tree, so ok undo can't revert this session: %s\n", err)
+	}
+	defer printChanges(cp)
+	startTime = time.Now()
+
+	renderThinking(0)
+	s, err := start()
+	if err != nil {
+		renderDone()
+		return "", err
+	}
+	for {
+		options := renderTurn(s.Events())
+		if len(options) == 0 {
+			break
+		}
+		answer, err := pick(options)
+		if err != nil || answer == "" {
+			break
+		}
+		renderThinking(usedTokens)
+		if err := s.Answer(answer); err != nil {
+			renderDone()
+			s.Close()
+			return s.ID(), err
+		}
+	}
+	return s.ID(), s.Close()
+}
+
+// printChanges lists the changes made since the checkpoint, or drops it if
+// there are none.
+func printChanges(cp *checkpoint.Checkpoint) {
+	if cp == nil {
+		return
+	}
+	changes, err := cp.Changes()
+	if err != nil {
+		log.Printf("Failed to list the changes of the session: %v", err)
+		return
+	}
+	if changes == "" {
+		if err := cp.Drop(); err != nil {
+			log.Printf("Failed to drop the checkpoint: %v", err)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nChanged since the session started (run ok undo to revert):\n%s\n", changes)
+}
+
+// renderTurn renders the events of a turn. It returns the options of the
+// last choice that the agent offered, if any.
+func renderTurn(events <-chan *Event) []picker.Option {
+	toolUseLines := make(map[string]int) // Map tool use IDs to line numbers
+	currentNumLines := 0
+	var options []picker.Option
+	// afterText is set while the last event rendered is text, which a
+	// continued Text event is rendered in the bullet of.
+	afterText := false
+
+	for e := range events {
+		continued := e.Type == Text && e.Continued && afterText
+		if e.Type != Usage {
+			afterText = e.Type == Text
+		}
+		switch e.Type {
+		case ToolUse:
+			renderDone()
+			bullet, numLines := renderBullet(renderToolUse(e.ToolName, e.ToolInput), "  ", "\033[1m⏺\033[0m ", true)
+			fmt.Fprintf(stdout, "%s", bullet)
+			toolUseLines[e.ToolUseID] = currentNumLines // Store line count for this tool use
+			currentNumLines += numLines
+		case Text:
+			renderDone()
+			text := selectPattern.ReplaceAllString(e.Text, "")
+			bullet, numLines := renderBullet(text, "  ", "\033[1m⏺\033[0m ", true)
+			if continued {
+				bullet, numLines = renderBullet(text, "  ", "  ", false)
+			}
+			fmt.Fprintf(stdout, "%s", bullet)
+			currentNumLines += numLines
+
+			// Check for select/option tags in the text
+			for _, match := range selectPattern.FindAllStringSubmatch(e.Text, -1) {
+				options = nil
+				for _, opt := range optionPattern.FindAllStringSubmatch(match[1], -1) {
+					options = append(options, picker.Option{Label: opt[2], Value: opt[2]})
+				}
+			}
+			// todo tab rendering of long text
+		case ToolResult:
+			renderDone()
+			if toolLine, ok := toolUseLines[e.ToolUseID]; ok {
+				if e.IsError {
+					fmt.Fprintf(stdout, "%s", renderColoredBullet(currentNumLines-toolLine-1, "red", "⏺", ""))
+				} else {
+					fmt.Fprintf(stdout, "%s", renderColoredBullet(currentNumLines-toolLine-1, "green", "⏺", ""))
+				}
+			}
+		case Usage:
+			usedTokens += e.Tokens
+		case Permission:
+			renderDone()
+			e.Reply <- askPermission(e.ToolName, e.Text)
+			// The picker moved the cursor, so the bullets of earlier tool uses
+			// can't be found anymore.
+			toolUseLines = make(map[string]int)
+			currentNumLines = 0
+		}
+
+		renderThinking(usedTokens)
+	}
+
+	renderDone()
+	return options
+}
+
+// pick asks the user to pick one of the options that the agent offered, or
+// to describe something else to do. It returns "" if the user doesn't answer.
+// Tests replace it to answer instead of the user.
+var pick = func(options []picker.Option) (string, error) {
+	options = append(options, picker.Option{
+		Label: "Something else",
+		Value: "Something else",
+	})
+
+	// Show picker and get selection
+	selected, err := picker.ShowPicker("Which would you like to do?", options)
+	if err != nil {
+		return "", err
+	}
+
+	if selected == "Something else" {
+		// Get custom input from user
+		return textarea.ShowTextarea("What would you like to do instead?", "Type here... For example: "+options[0].Label)
+	}
+	return selected, nil
+}
+
+// askPermission asks the user whether the agent may use a tool on subject.
+// Without a terminal to ask in, it may not.
+func askPermission(tool, subject string) bool {
+	use := tool
+	if subject != "" {
+		use = fmt.Sprintf("%s(%s)", tool, subject)
+	}
+	allowed := false
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		selected, err := picker.ShowPicker(fmt.Sprintf("Allow the agent to use %s?", use), []picker.Option{
+			{Label: "Allow", Value: "allow"},
+			{Label: "Deny", Value: "deny"},
+		})
+		allowed = err == nil && selected == "allow"
+	}
+	verdict := "\033[31mDenied\033[0m"
+	if allowed {
+		verdict = "\033[32mAllowed\033[0m"
+	}
+	bullet, _ := renderBullet(fmt.Sprintf("%s %s for this session", verdict, use), "  ", "\033[1m⏺\033[0m ", true)
+	fmt.Fprintf(stdout, "%s", bullet)
+	return allowed
+}
+
+func renderPath(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(cwd, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+func renderToolUse(name string, input json.RawMessage) string {
+	var jsonMap map[string]interface{}
+	if err := json.Unmarshal(input, &jsonMap); err != nil {
+		log.Printf("Failed to unmarshal input: %v", err)
+		return ""
+	}
+
+	inputs := ""
+
+	if name == "LS" {
+		name = "List"
+		if path, ok := jsonMap["path"].(string); ok {
+			inputs = fmt.Sprintf("(%s)", renderPath(path))
+		}
+	} else if name == "Grep" {
+		name = "Find"
+	} else if name == "TodoWrite" {
+		name = "Update Todos"
+		var todoItems []string
+		// jsonMap["todos"] contains the array of todo items
+		if todosArray, ok := jsonMap["todos"].([]interface{}); ok {
+			for _, todo := range todosArray {
+				todoMap, ok := todo.(map[string]interface{})
+				if !ok {
+					log.Printf("Failed to unmarshal todo: %v", todo)
+					continue
+				}
+				content, _ := todoMap["content"].(string)
+				status, _ := todoMap["status"].(string)
+
+				item := content
+				if status == "completed" {
+					item = fmt.Sprintf("☒ \033[9m%s\033[0m", item)
+				} else {
+					item = fmt.Sprintf("☐ %s", item)
+				}
+				todoItems = append(todoItems, item)
+			}
+		} else {
+			log.Printf("Failed to get todos array from jsonMap: %v", jsonMap)
+		}
+		inputs = fmt.Sprintf("\n%s", strings.Join(todoItems, "\n"))
+	} else if name == "Read" {
+		if path, ok := jsonMap["file_path"].(string); ok {
+			inputs = fmt.Sprintf("(%s)", renderPath(path))
+		}
+	} else if name == "Bash" {
+		if command, ok := jsonMap["command"].(string); ok {
+			inputs = fmt.Sprintf("(%s)", command)
+		}
+	}
+
+	if inputs == "" {
+		var values []string
+		for _, v := range jsonMap {
+			values = append(values, fmt.Sprintf("%v", v))
+		}
+		inputs = fmt.Sprintf("(%s)", strings.Join(values, ", "))
+	}
+
+	return fmt.Sprintf("\033[1m%s\033[0m%s", name, inputs)
+}
+
+func renderBullet(text string, indent string, bulletPrefix string, newLine bool) (string, int) {
+	width := 80 // Default width
+	if w, _, err := term.GetSize(int(stdout.Fd())); err == nil {
+		width = w
+	}
+
+	// Split text into words
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return "", 0
+	}
+
+	// First line gets the bullet with 2 space indent
+	// bulletPrefix := "⏺ "
+	// indent := "  "
+	lineWidth := width - len(indent)
+
+	var result strings.Builder
+	var currentLine strings.Builder
+	currentLine.WriteString(bulletPrefix)
+	lineLen := len(bulletPrefix)
+
+	// Count number of lines
+	numLines := 1
+	if newLine {
+		result.WriteString("\n")
+		numLines++
+	}
+
+	// Build lines word by word
+	for _, word := range words {
+		if lineLen+len(word)+1 > lineWidth && currentLine.Len() > len(bulletPrefix) {
+			// Line would be too long, start a new one
+			result.WriteString(currentLine.String())
+			result.WriteString("\n")
+			currentLine.Reset()
+			currentLine.WriteString(indent)
+			lineLen = len(indent)
+			numLines++
+		}
+		currentLine.WriteString(" ")
+		lineLen++
+		currentLine.WriteString(word)
+		lineLen += len(word)
+	}
+
+	// Add final line
+	if currentLine.Len() > 0 {
+		result.WriteString(currentLine.String())
+	}
+	result.WriteString("\n")
+
+	return result.String(), numLines
+}
+
+func renderColoredBullet(height int, color string, bullet string, suffix string) string {
+	// ANSI escape codes
+	greenColor := "\033[32m"
+	redColor := "\033[31m"
+	blueColor := "\033[96m"
+	resetColor := "\033[0m"
+
+	if color == "green" {
+		color = greenColor
+	} else if color == "red" {
+		color = redColor
+	} else if color == "blue" {
+		color = blueColor
+	}
+
+	// Move up N lines, back to start, replace bullet, then move back down N lines
+	return fmt.Sprintf("\033[s\033[%dA\r%s%s%s%s\033[u", height, color, bullet, resetColor, suffix)
+}
+
+var isThinking = false
+var stopThinking = make(chan bool)
+var renderedTokenCount int = 0
+var tickCount int = 0
+
+func renderThinkingString(thinkingString string, dots string, spaces string, renderedTokenCount int) string {
+	tokensString := fmt.Sprintf(" (%s)", time.Since(startTime).Round(time.Second))
+	if renderedTokenCount > 0 {
+		tokensString = fmt.Sprintf(" (%s, %d tokens)", time.Since(startTime).Round(time.Second), renderedTokenCount)
+	}
+
+	return fmt.Sprintf("  %s%s%s%s\033[K", thinkingString, dots, spaces, tokensString)
+}
+
+var thinkingIndex int = 0
+
+func renderThinking(tokens int) {
+	if isThinking || !term.IsTerminal(int(stdout.Fd())) {
+		return
+	}
+
+	thinkingStringOptions := []string{
+		"Thinking", "Reticulating", "Building", "Analyzing", "Querying", "Optimizing", "Refactoring", "Debugging", "Checking", "Fixing", "Enhancing", "Testing", "Validating", "Improving",
+	}
+
+	isThinking = true
+	fmt.Fprintf(stdout, "\n\r\033[36m⣿\033[0m%s\n\n", renderThinkingString(thinkingStringOptions[thinkingIndex], "...", "", renderedTokenCount))
+
+	go func() {
+		spinChars := []rune{'⣾', '⣽', '⣻', '⢿', '⡿', '⣟', '⣯', '⣷'}
+		for {
+			select {
+			case <-stopThinking:
+				return
+			case <-time.After(66 * time.Millisecond):
+				if renderedTokenCount < usedTokens {
+					renderedTokenCount += int(math.Max(1, float64((tokens-renderedTokenCount)/50)))
+				}
+				numDots := (tickCount / 10) % 4
+				thinkingIndex = (tickCount / 80) % len(thinkingStringOptions)
+
+				dots := strings.Repeat(".", numDots)
+				spaces := strings.Repeat(" ", 3-numDots)
+				fmt.Fprintf(stdout, "%s", renderColoredBullet(2, "blue", fmt.Sprintf("%c", spinChars[tickCount%len(spinChars)]), renderThinkingString(thinkingStringOptions[thinkingIndex], dots, spaces, renderedTokenCount)))
+				tickCount = (tickCount + 1)
+			}
+		}
+	}()
+}
+
+func renderDone() {
+	// Only the spinner started by renderThinking can be stopped; nothing
+	// would receive from stopThinking otherwise.
+	if !isThinking {
+		return
+	}
+
+	stopThinking <- true
+	fmt.Fprint(stdout, "\033[1A\033[K\033[1A\033[K\033[1A\033[K")
+	isThinking = false
+}
@@ -0,0 +1,234 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"ok.build/cli/agent"
+	"ok.build/cli/agent/fake"
+	"ok.build/cli/picker"
+)
+
+// setUp runs the test outside of a git repository, so that sessions aren't
+// checkpointed, and captures what is rendered. It returns a function that
+// returns the output so far.
+func setUp(t *testing.T) func() string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, pick := *agent.Stdout, *agent.Pick
+	*agent.Stdout = out
+	t.Cleanup(func() {
+		*agent.Stdout, *agent.Pick = stdout, pick
+		out.Close()
+		os.Chdir(wd)
+	})
+	*agent.Pick = func([]picker.Option) (string, error) {
+		t.Fatal("the agent offered no choice, but the user was asked to pick one")
+		return "", nil
+	}
+	return func() string {
+		b, err := os.ReadFile(out.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+func text(s string) *agent.Event {
+	return &agent.Event{Type: agent.Text, Text: s}
+}
+
+func TestRunAnswersChoices(t *testing.T) {
+	output := setUp(t)
+	a := fake.New(
+		[]*agent.Event{text("Which fix? <select><option>Add the dep</option><option>Remove the import</option></select>")},
+		[]*agent.Event{text("Sure. <select><option>Yes</option><option>No</option></select>")},
+		[]*agent.Event{text("Removed the import.")},
+	)
+	var offered [][]string
+	*agent.Pick = func(options []picker.Option) (string, error) {
+		var labels []string
+		for _, o := range options {
+			labels = append(labels, o.Label)
+		}
+		offered = append(offered, labels)
+		return options[len(options)-1].Value, nil
+	}
+
+	id, err := agent.Run(a, &agent.Request{Prompt: "Fix it", Context: strings.NewReader("the errors")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "fake-1" {
+		t.Errorf("got session ID %q, want fake-1", id)
+	}
+	wantOffered := [][]string{
+		{"Add the dep", "Remove the import"},
+		{"Yes", "No"},
+	}
+	if !slices.EqualFunc(offered, wantOffered, slices.Equal) {
+		t.Errorf("got choices %q, want %q", offered, wantOffered)
+	}
+	if got, want := a.Messages(), []string{"the errors", "Fix it", "Remove the import", "No"}; !slices.Equal(got, want) {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+	out := output()
+	for _, s := range []string{"Which fix?", "Sure.", "Removed the import."} {
+		if !strings.Contains(out, s) {
+			t.Errorf("got output\n%s\nwant it to contain %q", out, s)
+		}
+	}
+	if strings.Contains(out, "<select>") {
+		t.Errorf("got output\n%s\nwant the choices left out", out)
+	}
+}
+
+func TestRunStopsWithoutAnswer(t *testing.T) {
+	setUp(t)
+	a := fake.New(
+		[]*agent.Event{text("Which fix? <select><option>A</option><option>B</option></select>")},
+		[]*agent.Event{text("Never played.")},
+	)
+	*agent.Pick = func([]picker.Option) (string, error) { return "", nil }
+
+	if _, err := agent.Run(a, &agent.Request{Prompt: "Fix it"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := a.Messages(), []string{"Fix it"}; !slices.Equal(got, want) {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+}
+
+func TestRunSessionIDs(t *testing.T) {
+	setUp(t)
+	a := fake.New([]*agent.Event{text("One.")}, []*agent.Event{text("Two.")}, []*agent.Event{text("Three.")})
+	for _, want := range []string{"fake-1", "fake-2"} {
+		id, err := agent.Run(a, &agent.Request{Prompt: "Fix it"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("got session ID %q, want %q", id, want)
+		}
+	}
+
+	id, err := agent.Continue(a, "fake-1", "Go on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "fake-1" {
+		t.Errorf("got session ID %q after resuming fake-1", id)
+	}
+	if got, want := a.Messages(), []string{"Fix it", "Fix it", "Go on"}; !slices.Equal(got, want) {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+}
+
+func TestRenderTurn(t *testing.T) {
+	output := setUp(t)
+	replies := make(chan bool, 1)
+	events := make(chan *agent.Event, 10)
+	for _, e := range []*agent.Event{
+		text("Let me look. <select><option>Stale</option></select>"),
+		{Type: agent.ToolUse, ToolUseID: "1", ToolName: "Bash", ToolInput: json.RawMessage(`{"command": "bazel query //foo"}`)},
+		{Type: agent.ToolResult, ToolUseID: "1", Text: "//foo:bar"},
+		{Type: agent.Usage, Tokens: 100},
+		{Type: agent.Permission, ToolName: "Bash", Text: "rm -rf foo", Reply: replies},
+		text("Which one? <select><option>A</option><option>B</option></select>"),
+	} {
+		events <- e
+	}
+	close(events)
+
+	options := agent.RenderTurn(events)
+	var labels []string
+	for _, o := range options {
+		labels = append(labels, o.Label)
+	}
+	if want := []string{"A", "B"}; !slices.Equal(labels, want) {
+		t.Errorf("got options %q, want the last choice %q", labels, want)
+	}
+	// Without a terminal to ask in, tool uses that need permission are
+	// denied.
+	if allowed := <-replies; allowed {
+		t.Error("got the tool use allowed without a terminal")
+	}
+	out := output()
+	for _, s := range []string{"Let me look.", "Bash", "(bazel query //foo)", "Denied", "Bash(rm -rf foo)", "Which one?"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("got output\n%s\nwant it to contain %q", out, s)
+		}
+	}
+}
+
+// Text that a provider streams in parts is rendered in a single bullet.
+func TestRenderTurnContinuedText(t *testing.T) {
+	output := setUp(t)
+	events := make(chan *agent.Event, 10)
+	for _, e := range []*agent.Event{
+		text("Let me look\n"),
+		{Type: agent.Usage, Tokens: 100},
+		{Type: agent.Text, Text: "at the file.\n", Continued: true},
+		{Type: agent.ToolUse, ToolUseID: "1", ToolName: "Read", ToolInput: json.RawMessage(`{"file_path": "BUILD"}`)},
+		{Type: agent.Text, Text: "It declares foo.", Continued: true},
+	} {
+		events <- e
+	}
+	close(events)
+
+	agent.RenderTurn(events)
+	out := output()
+	if got := strings.Count(out, "⏺"); got != 3 {
+		t.Errorf("got output\n%s\nwith %d bullets, want 3: the text, the tool use and the text after it", out, got)
+	}
+	for _, s := range []string{"Let me look", "at the file.", "It declares foo."} {
+		if !strings.Contains(out, s) {
+			t.Errorf("got output\n%s\nwant it to contain %q", out, s)
+		}
+	}
+}
+
+// recorder records the requests that sessions are started with.
+type recorder struct {
+	*fake.Agent
+	reqs []*agent.Request
+}
+
+func (r *recorder) Start(req *agent.Request) (agent.Session, error) {
+	r.reqs = append(r.reqs, req)
+	return r.Agent.Start(req)
+}
+
+func TestRunTellsAgentWhetherInteractive(t *testing.T) {
+	setUp(t)
+	a := &recorder{Agent: fake.New([]*agent.Event{text("Fixed.")}, []*agent.Event{text("Fixed.")})}
+	for _, interactive := range []bool{true, false} {
+		if _, err := agent.Run(a, &agent.Request{Prompt: "Fix it", Interactive: interactive}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a.reqs) != 2 || a.reqs[0].SystemPrompt == a.reqs[1].SystemPrompt {
+		t.Fatal("got the same system prompt for interactive and other requests")
+	}
+	for i, want := range []string{"work through this with you", "do this on your own"} {
+		if !strings.Contains(a.reqs[i].SystemPrompt, want) {
+			t.Errorf("got system prompt %q, want it to contain %q", a.reqs[i].SystemPrompt, want)
+		}
+	}
+}
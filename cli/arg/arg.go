@@ -2,7 +2,9 @@ package arg
 
 import (
 	"fmt"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -15,11 +17,23 @@ func Pop(args []string, desiredArg string) (string, []string) {
 	return arg, append(args[:i], args[i+length:]...)
 }
 
-// Helper method for finding arguments by prefix within a list of arguments
+// Helper method for finding arguments by prefix within a list of arguments.
+//
+// Options that take a value may be passed as "--name=value" or "--name value".
+// Boolean options may be passed as "--name" or "--noname", in which case the
+// value is "true" or "false" respectively.
 func Find(args []string, desiredArg string) (value string, index int, length int) {
 	exact := fmt.Sprintf("--%s", desiredArg)
+	negated := fmt.Sprintf("--no%s", desiredArg)
 	prefix := fmt.Sprintf("--%s=", desiredArg)
 	for i, arg := range args {
+		if arg == exact || arg == negated {
+			o, isNegated := LookupOption(arg)
+			if o != nil && !o.RequiresValue {
+				// Handle "--name" and "--noname" forms of boolean options
+				return strconv.FormatBool(!isNegated), i, 1
+			}
+		}
 		// Handle "--name", "value" form
 		if arg == exact && i+1 < len(args) {
 			return args[i+1], i, 2
@@ -48,19 +62,24 @@ func FindLast(args []string, desiredArg string) (value string, index int, length
 	return lastValue, lastIndex, lastLength
 }
 
-// Returns the first non-option found in the list of args (doesn't begin with "-")
+// Returns the first non-option found in the list of args (doesn't begin with "-"),
+// skipping the values of startup options like "--output_base /tmp/ob".
 func GetCommand(args []string) string {
 	command, _ := GetCommandAndIndex(args)
 	return command
 }
 
-// Returns the first non-option found in the list of args (doesn't begin with "-") and the index at which it was found
+// Returns the first non-option found in the list of args (doesn't begin with "-") and the index at which it was found.
+// The values of startup options like "--output_base /tmp/ob" are skipped.
 func GetCommandAndIndex(args []string) (string, int) {
-	for i, arg := range args {
-		if strings.HasPrefix(arg, "-") {
-			continue
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return arg, i
+		}
+		if takesSeparateValue(arg) {
+			i++
 		}
-		return arg, i
 	}
 	return "", -1
 }
@@ -124,20 +143,127 @@ func ContainsExact(args []string, value string) bool {
 }
 
 // Returns a list of bazel targets specified in the given set of arguments, if any.
-// This function assumes that the arguments are canonicalized.
+// The values of options like "--config ci" are not considered targets, but
+// negative target patterns like "-//foo:bar" are.
 func GetTargets(args []string) []string {
-	command := GetCommand(args)
-	nonOptionArgs := []string{}
-	for _, arg := range args {
-		if arg == "--" && command == "run" {
+	command, idx := GetCommandAndIndex(args)
+	targets := []string{}
+	if idx == -1 {
+		return targets
+	}
+	afterSeparator := false
+	for i := idx + 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			if command == "run" {
+				break
+			}
+			// Everything after "--" is a target pattern.
+			afterSeparator = true
+			continue
+		}
+		if !afterSeparator && isOption(arg) {
+			if takesSeparateValue(arg) {
+				i++
+			}
+			continue
+		}
+		targets = append(targets, arg)
+	}
+	return targets
+}
+
+// RemoveTargets returns args without the target patterns that GetTargets
+// returns, keeping the startup options, the command and its options. Any
+// executable args are dropped along with the "--" separator.
+func RemoveTargets(args []string) []string {
+	_, idx := GetCommandAndIndex(args)
+	if idx == -1 {
+		return append([]string{}, args...)
+	}
+	out := append([]string{}, args[:idx+1]...)
+	for i := idx + 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
 			break
 		}
-		if !strings.HasPrefix(arg, "-") {
-			nonOptionArgs = append(nonOptionArgs, arg)
+		if !isOption(arg) {
+			continue
+		}
+		out = append(out, arg)
+		if takesSeparateValue(arg) && i+1 < len(args) {
+			i++
+			out = append(out, args[i])
+		}
+	}
+	return out
+}
+
+var safeShellWord = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// QuoteShell quotes s for a POSIX shell, if it needs quoting.
+func QuoteShell(s string) string {
+	if safeShellWord.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// JoinShell joins words into a command line that SplitShell splits back into
+// the same words.
+func JoinShell(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = QuoteShell(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// SplitShell splits a command line into words the way a POSIX shell would,
+// honoring single quotes, double quotes and backslash escapes. It does not
+// perform any variable or glob expansion.
+func SplitShell(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
 		}
 	}
-	if len(nonOptionArgs) == 0 {
-		return nonOptionArgs
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
 	}
-	return nonOptionArgs[1:]
+	return words, nil
 }
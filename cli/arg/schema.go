@@ -0,0 +1,256 @@
+package arg
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"ok.build/cli/wire"
+)
+
+// Option describes a single command line option, as declared by
+// `bazel help flags-as-proto`.
+type Option struct {
+	Name         string
+	Abbreviation string
+	Help         string
+
+	// Commands lists the commands that accept the option. Startup options
+	// list "startup".
+	Commands []string
+
+	// RequiresValue is set for options that take a value, which may be passed
+	// either as "--name=value" or as "--name value".
+	RequiresValue bool
+
+	// HasNegative is set for boolean options, which accept "--noname".
+	HasNegative bool
+
+	AllowsMultiple bool
+}
+
+// Schema describes every option that bazel accepts.
+type Schema struct {
+	Options map[string]*Option
+
+	abbreviations map[string]*Option
+}
+
+// NewSchema returns a Schema containing the given options.
+func NewSchema(options []*Option) *Schema {
+	s := &Schema{
+		Options:       make(map[string]*Option, len(options)),
+		abbreviations: map[string]*Option{},
+	}
+	for _, o := range options {
+		s.Options[o.Name] = o
+		if o.Abbreviation != "" {
+			s.abbreviations[o.Abbreviation] = o
+		}
+	}
+	return s
+}
+
+// Lookup returns the option named by the given arg, which may be in any of
+// the forms "--name", "--name=value", "--noname" or "-n".
+func (s *Schema) Lookup(arg string) (option *Option, negated bool) {
+	if !strings.HasPrefix(arg, "--") {
+		if short, ok := strings.CutPrefix(arg, "-"); ok {
+			return s.abbreviations[short], false
+		}
+		return nil, false
+	}
+	name, _, _ := strings.Cut(arg[2:], "=")
+	if o, ok := s.Options[name]; ok {
+		return o, false
+	}
+	if positive, ok := strings.CutPrefix(name, "no"); ok {
+		if o, ok := s.Options[positive]; ok && o.HasNegative {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+// IsStartupOption returns whether the option is a startup option.
+func (o *Option) IsStartupOption() bool {
+	for _, c := range o.Commands {
+		if c == "startup" {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// fallbackSchema is consulted before bazel's schema, and covers the most
+	// common options that take a value so that bazel's schema rarely needs to
+	// be fetched. The ok cli's own options are registered here too.
+	fallbackSchema = NewSchema(fallbackOptions())
+
+	schemaMu sync.Mutex
+	// bazelSchema is fetched lazily, only when an option isn't known by the
+	// fallback schema.
+	bazelSchema     *Schema
+	bazelSchemaDone bool
+
+	// LoadBazelSchema fetches bazel's option schema. It is set by the schema
+	// loader in cli/bazelflags; while it is nil, only the fallback schema is
+	// used.
+	LoadBazelSchema func() (*Schema, error)
+)
+
+func fallbackOptions() []*Option {
+	var options []*Option
+	startup := []string{
+		"bazelrc", "command_port", "connect_timeout_secs", "digest_function",
+		"failure_detail_out", "host_jvm_args", "host_jvm_profile",
+		"install_base", "io_nice_level", "local_startup_timeout_secs",
+		"macos_qos_class", "max_idle_secs", "output_base", "output_user_root",
+		"server_javabase", "server_jvm_out", "unix_digest_hash_attribute_name",
+	}
+	for _, name := range startup {
+		options = append(options, &Option{Name: name, Commands: []string{"startup"}, RequiresValue: true})
+	}
+	valued := []string{
+		"action_env", "build_event_binary_file", "build_event_json_file",
+		"build_tag_filters", "cache_test_results", "color", "config", "copt",
+		"curses", "define", "execution_log_compact_file",
+		"execution_log_json_file", "flaky_test_attempts", "host_copt", "output",
+		"output_groups", "platforms", "profile", "remote_cache",
+		"remote_executor", "repo_env", "run_under", "runs_per_test",
+		"target_pattern_file", "test_arg", "test_env", "test_filter",
+		"test_output", "test_size_filters", "test_tag_filters", "test_timeout",
+	}
+	for _, name := range valued {
+		options = append(options, &Option{Name: name, RequiresValue: true})
+	}
+	options = append(options,
+		&Option{Name: "compilation_mode", Abbreviation: "c", RequiresValue: true},
+		&Option{Name: "jobs", Abbreviation: "j", RequiresValue: true},
+		&Option{Name: "keep_going", Abbreviation: "k", HasNegative: true},
+		&Option{Name: "subcommands", Abbreviation: "s", HasNegative: true},
+	)
+	return options
+}
+
+// RegisterOption adds an option to the schema used by this package. The cli
+// uses this to declare its own options.
+func RegisterOption(o *Option) {
+	fallbackSchema.Options[o.Name] = o
+	if o.Abbreviation != "" {
+		fallbackSchema.abbreviations[o.Abbreviation] = o
+	}
+}
+
+// LookupOption returns the option named by the given arg, consulting bazel's
+// schema if the option isn't otherwise known.
+func LookupOption(arg string) (option *Option, negated bool) {
+	if o, negated := fallbackSchema.Lookup(arg); o != nil {
+		return o, negated
+	}
+	if s := getBazelSchema(); s != nil {
+		return s.Lookup(arg)
+	}
+	return nil, false
+}
+
+// BazelSchema returns bazel's option schema, fetching it if necessary, or the
+// schema of the most common options if it can't be fetched.
+func BazelSchema() *Schema {
+	if s := getBazelSchema(); s != nil {
+		return s
+	}
+	return fallbackSchema
+}
+
+func getBazelSchema() *Schema {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	if !bazelSchemaDone && LoadBazelSchema != nil {
+		bazelSchemaDone = true
+		// The schema only refines arg parsing, so if it can't be loaded,
+		// carry on with the fallback schema.
+		bazelSchema, _ = LoadBazelSchema()
+	}
+	return bazelSchema
+}
+
+// takesSeparateValue returns whether the given arg is an option whose value
+// is passed as the next arg ("--name value" or "-c opt").
+func takesSeparateValue(arg string) bool {
+	if !strings.HasPrefix(arg, "-") || arg == "--" || strings.Contains(arg, "=") {
+		return false
+	}
+	o, negated := LookupOption(arg)
+	return o != nil && o.RequiresValue && !negated
+}
+
+// isOption returns whether arg is an option rather than a target pattern.
+// Options start with "--", or are abbreviations like "-k"; other args that
+// start with "-" are negative target patterns like "-//foo:bar".
+func isOption(arg string) bool {
+	if strings.HasPrefix(arg, "--") {
+		return true
+	}
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	o, _ := LookupOption(arg)
+	return o != nil
+}
+
+// ParseFlagsProto parses the output of `bazel help flags-as-proto`, which is
+// a base64-encoded FlagCollection proto message.
+func ParseFlagsProto(out []byte) (*Schema, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode flags proto: %s", err)
+	}
+	var options []*Option
+	// Older bazel versions don't set requires_value; for those, assume that
+	// every option except boolean options takes a value.
+	sawRequiresValue := false
+	err = wire.Walk(b, func(field int, value []byte, _ uint64) error {
+		// FlagCollection.flag_infos
+		if field != 1 {
+			return nil
+		}
+		o := &Option{}
+		err := wire.Walk(value, func(field int, value []byte, n uint64) error {
+			switch field {
+			case 1:
+				o.Name = string(value)
+			case 2:
+				o.HasNegative = n != 0
+			case 3:
+				o.Help = string(value)
+			case 4:
+				o.Commands = append(o.Commands, string(value))
+			case 5:
+				o.Abbreviation = string(value)
+			case 6:
+				o.AllowsMultiple = n != 0
+			case 10:
+				o.RequiresValue = n != 0
+				sawRequiresValue = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		options = append(options, o)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !sawRequiresValue {
+		for _, o := range options {
+			o.RequiresValue = !o.HasNegative
+		}
+	}
+	return NewSchema(options), nil
+}
@@ -0,0 +1,81 @@
+package bazelflags
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/bazelisk"
+	"ok.build/cli/config"
+	"ok.build/cli/log"
+)
+
+const (
+	// unpinnedCacheTTL is how long the schema of a bazel version label like
+	// "latest" is cached, since the version it refers to changes over time.
+	unpinnedCacheTTL = 24 * time.Hour
+)
+
+var (
+	// Matches concrete bazel versions like "7.4.1" or "8.0.0rc2", but not
+	// labels like "latest" or "7.x".
+	pinnedVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+[\w.-]*$`)
+
+	unsafeFileCharsPattern = regexp.MustCompile(`[^\w.-]`)
+)
+
+// Load returns bazel's option schema, from `bazel help flags-as-proto`. The
+// output is cached under ~/.ok/cache/flags per bazel version, so bazel only
+// needs to be run once for each version.
+func Load() (*arg.Schema, error) {
+	version, err := bazelisk.GetBazelVersion()
+	if err != nil {
+		return nil, err
+	}
+	okDir, err := config.OkDir()
+	if err != nil {
+		return nil, err
+	}
+	cachePath := filepath.Join(okDir, "cache", "flags", unsafeFileCharsPattern.ReplaceAllString(version, "_")+".txt")
+
+	if b, err := os.ReadFile(cachePath); err == nil && isFresh(cachePath, version) {
+		if s, err := arg.ParseFlagsProto(b); err == nil {
+			return s, nil
+		}
+		log.Debugf("Ignoring invalid cached flags schema %s", cachePath)
+	}
+
+	log.Debugf("Fetching flags schema for bazel %s", version)
+	buf := &bytes.Buffer{}
+	exitCode, err := bazelisk.Run([]string{"help", "flags-as-proto"}, &bazelisk.RunOpts{Stdout: buf, Stderr: io.Discard})
+	if err != nil {
+		return nil, err
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("`bazel help flags-as-proto` exited with code %d", exitCode)
+	}
+	s, err := arg.ParseFlagsProto(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(cachePath, buf.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func isFresh(cachePath, version string) bool {
+	if pinnedVersionPattern.MatchString(version) {
+		return true
+	}
+	fi, err := os.Stat(cachePath)
+	return err == nil && time.Since(fi.ModTime()) < unpinnedCacheTTL
+}
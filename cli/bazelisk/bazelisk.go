@@ -6,6 +6,7 @@ import (
 	goLog "log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/bazelbuild/bazelisk/config"
 	"github.com/bazelbuild/bazelisk/core"
@@ -23,6 +24,10 @@ const (
 	// bazelisk environment variable name that skips tools/bazel if set to a
 	// non-empty string.
 	skipWrapperEnvVar = "BAZELISK_SKIP_WRAPPER"
+
+	// ptyDrainTimeout is how long to wait for the output that is left in the
+	// pty after bazel exits.
+	ptyDrainTimeout = 5 * time.Second
 )
 
 type RunOpts struct {
@@ -150,7 +155,10 @@ func makePipeWriter(w io.Writer) (pw *os.File, closeFunc func(), err error) {
 	return
 }
 
-func RunWithLogFile(args []string, logFileName string) (exitCode int, err error) {
+// RunWithLogFile runs bazel, writing its output to the terminal as well as to
+// logFileName. The output is also written to each of the given outputs as it
+// streams, e.g. for plugins to read.
+func RunWithLogFile(args []string, logFileName string, outputs ...io.Writer) (exitCode int, err error) {
 	// Create the output file where the original bazel output will be written,
 	// for post-bazel plugins to read.
 	outputFile, err := os.Create(logFileName)
@@ -160,7 +168,7 @@ func RunWithLogFile(args []string, logFileName string) (exitCode int, err error)
 	defer outputFile.Close()
 
 	isWritingToTerminal := IsTTY(os.Stdout) && IsTTY(os.Stderr)
-	w := io.MultiWriter(outputFile, os.Stderr)
+	w := io.MultiWriter(append([]io.Writer{outputFile, os.Stderr}, outputs...)...)
 	opts := &RunOpts{
 		Stdout: os.Stdout,
 		Stderr: w,
@@ -190,7 +198,23 @@ func RunWithLogFile(args []string, logFileName string) (exitCode int, err error)
 		// make a difference either way.
 		opts.Stdout = tty
 		opts.Stderr = tty
-		go io.Copy(w, ptmx)
+		copied := make(chan struct{})
+		go func() {
+			io.Copy(w, ptmx)
+			close(copied)
+		}()
+
+		exitCode, err := Run(args, opts)
+		// Reading the pty ends once every process has closed the tty, so close
+		// ours and let the copy drain bazel's last output into the writers
+		// before the caller closes them. Processes that bazel left running
+		// may keep the tty open, so don't wait for them for long.
+		_ = tty.Close()
+		select {
+		case <-copied:
+		case <-time.After(ptyDrainTimeout):
+		}
+		return exitCode, err
 	}
 
 	return Run(args, opts)
@@ -200,3 +224,10 @@ func RunWithLogFile(args []string, logFileName string) (exitCode int, err error)
 func IsTTY(f *os.File) bool {
 	return isatty.IsTerminal(f.Fd())
 }
+
+// GetBazelVersion returns the bazel version that bazelisk runs in the current
+// workspace, as configured by .bazelversion, USE_BAZEL_VERSION, etc. It may be
+// a version label like "latest" rather than a concrete version.
+func GetBazelVersion() (string, error) {
+	return core.GetBazelVersion(core.MakeDefaultConfig())
+}
@@ -0,0 +1,538 @@
+package bazelrc
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// CommandLine is the Source of flags that were passed on the command line.
+	CommandLine = "command line"
+
+	workspacePlaceholder = "%workspace%"
+)
+
+var (
+	Flags = flag.NewFlagSet("flags", flag.ContinueOnError)
+
+	explain = Flags.Bool("explain", false, "Show the rc file, line and --config that each flag came from.")
+)
+
+const Description = `
+Prints the command line that bazel would effectively run, after reading the
+rc files (/etc/bazel.bazelrc, %workspace%/.bazelrc, ~/.bazelrc and any
+--bazelrc), applying common/build/test inheritance and expanding every
+--config option.
+
+For example, to find out where each flag set by --config=ci comes from:
+
+  ok flags --explain build --config=ci
+
+Startup options go before the command, as they do for bazel:
+
+  ok flags --bazelrc=ci.bazelrc build
+`
+
+var (
+	// parentCommands maps each bazel command to the command whose rc options
+	// it inherits, e.g. `test` inherits every `build` option in a bazelrc.
+	// Every command also inherits `common` and `always` options.
+	parentCommands = map[string]string{
+		"aquery":             "build",
+		"canonicalize-flags": "build",
+		"clean":              "build",
+		"coverage":           "test",
+		"cquery":             "build",
+		"info":               "build",
+		"mobile-install":     "build",
+		"print_action":       "build",
+		"run":                "build",
+		"test":               "build",
+	}
+)
+
+// Flag is a single arg of the effective command line, along with where it
+// came from.
+type Flag struct {
+	Value string
+
+	// Source is the path of the rc file that the flag came from, or
+	// CommandLine.
+	Source string
+	Line   int
+
+	// RcCommand is the command that the rc line applies to, like "common",
+	// "build" or "test", or "" for flags from the command line.
+	RcCommand string
+
+	// Config is set to the name of the config if the flag was added by
+	// expanding a --config option.
+	Config string
+
+	// ExpandedFrom is the --config flag that this flag was expanded from, if
+	// any.
+	ExpandedFrom *Flag
+}
+
+// Location returns "file:line" for flags from rc files, or "command line".
+func (f *Flag) Location() string {
+	if f.Source == CommandLine {
+		return f.Source
+	}
+	return fmt.Sprintf("%s:%d", f.Source, f.Line)
+}
+
+// Explain describes where the flag came from, following --config expansions
+// back to the flag that triggered them.
+func (f *Flag) Explain() string {
+	s := f.Location()
+	if f.RcCommand != "" {
+		s += " (" + f.RcCommand
+		if f.Config != "" {
+			s += ":" + f.Config
+		}
+		s += ")"
+	}
+	if f.ExpandedFrom != nil {
+		s += ", via " + f.ExpandedFrom.Value + " from " + f.ExpandedFrom.Explain()
+	}
+	return s
+}
+
+// Invocation is a command line with every rc file and --config applied.
+type Invocation struct {
+	StartupOptions []*Flag
+	Command        string
+
+	// Args holds the command's options, in the order that bazel applies them,
+	// followed by the target patterns and other residual args. --config
+	// options are replaced by their expansion.
+	Args []*Flag
+
+	// ExecutableArgs holds args after "--" for `bazel run`.
+	ExecutableArgs []string
+
+	// RcFiles lists every rc file that was read, in order.
+	RcFiles []string
+}
+
+// Strings returns the complete expanded command line as a list of args,
+// suitable for passing to the helpers in cli/arg.
+func (inv *Invocation) Strings() []string {
+	var out []string
+	for _, f := range inv.StartupOptions {
+		out = append(out, f.Value)
+	}
+	if inv.Command != "" {
+		out = append(out, inv.Command)
+	}
+	for _, f := range inv.Args {
+		out = append(out, f.Value)
+	}
+	return arg.JoinExecutableArgs(out, inv.ExecutableArgs)
+}
+
+// rcLine is a single non-import line from an rc file.
+type rcLine struct {
+	command string
+	config  string
+	args    []string
+	source  string
+	line    int
+}
+
+// Expand resolves args the way bazel does: it reads the rc files, applies
+// the options for the command and the commands it inherits from, and expands
+// every --config option recursively.
+func Expand(args []string) (*Invocation, error) {
+	args, execArgs := arg.SplitExecutableArgs(args)
+	command, idx := arg.GetCommandAndIndex(args)
+	startupArgs, commandArgs := args, []string{}
+	if idx >= 0 {
+		startupArgs, commandArgs = args[:idx], args[idx+1:]
+	}
+
+	inv := &Invocation{Command: command, ExecutableArgs: execArgs}
+	lines, err := readRcFiles(startupArgs, inv)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range lines {
+		if l.command == "startup" && l.config == "" {
+			inv.StartupOptions = append(inv.StartupOptions, flagsFromLine(l)...)
+		}
+	}
+	for _, a := range startupArgs {
+		inv.StartupOptions = append(inv.StartupOptions, &Flag{Value: a, Source: CommandLine})
+	}
+	if command == "" {
+		return inv, nil
+	}
+
+	e := &expander{command: command, lines: lines}
+	for _, rcCommand := range e.commandChain() {
+		for _, l := range lines {
+			if l.command != rcCommand || l.config != "" {
+				continue
+			}
+			flags, err := e.expand(flagsFromLine(l), nil)
+			if err != nil {
+				return nil, err
+			}
+			inv.Args = append(inv.Args, flags...)
+		}
+	}
+	var fromCommandLine []*Flag
+	for _, a := range commandArgs {
+		fromCommandLine = append(fromCommandLine, &Flag{Value: a, Source: CommandLine})
+	}
+	flags, err := e.expand(fromCommandLine, nil)
+	if err != nil {
+		return nil, err
+	}
+	inv.Args = append(inv.Args, flags...)
+	return inv, nil
+}
+
+// Configs returns the names of every config defined in the rc files that
+// bazel would read given the startup options, in sorted order.
+func Configs(startupArgs []string) ([]string, error) {
+	lines, err := readRcFiles(startupArgs, &Invocation{})
+	if err != nil {
+		return nil, err
+	}
+	var configs []string
+	for _, l := range lines {
+		if l.config != "" && !slices.Contains(configs, l.config) {
+			configs = append(configs, l.config)
+		}
+	}
+	slices.Sort(configs)
+	return configs, nil
+}
+
+type expander struct {
+	command string
+	lines   []*rcLine
+}
+
+// commandChain returns the rc commands whose options apply to the command,
+// from least to most specific, like ["always", "common", "build", "test"].
+func (e *expander) commandChain() []string {
+	var chain []string
+	for c := e.command; c != ""; c = parentCommands[c] {
+		chain = append([]string{c}, chain...)
+	}
+	return append([]string{"always", "common"}, chain...)
+}
+
+// expand replaces every --config option in flags with the options that it
+// expands to. stack holds the configs currently being expanded.
+func (e *expander) expand(flags []*Flag, stack []string) ([]*Flag, error) {
+	var out []*Flag
+	for i := 0; i < len(flags); i++ {
+		f := flags[i]
+		var name string
+		if v, ok := strings.CutPrefix(f.Value, "--config="); ok {
+			name = v
+		} else if f.Value == "--config" && i+1 < len(flags) {
+			i++
+			name = flags[i].Value
+			f = &Flag{Value: "--config=" + name, Source: f.Source, Line: f.Line, RcCommand: f.RcCommand, Config: f.Config, ExpandedFrom: f.ExpandedFrom}
+		} else {
+			if !e.applies(f) {
+				continue
+			}
+			out = append(out, f)
+			continue
+		}
+		if slices.Contains(stack, name) {
+			return nil, fmt.Errorf("config expansion cycle: --config=%s", strings.Join(append(stack, name), " -> --config="))
+		}
+		expanded, err := e.expandConfig(name, f, append(stack, name))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
+
+func (e *expander) expandConfig(name string, from *Flag, stack []string) ([]*Flag, error) {
+	defined := false
+	var out []*Flag
+	for _, rcCommand := range e.commandChain() {
+		for _, l := range e.lines {
+			if l.config != name {
+				continue
+			}
+			defined = true
+			if l.command != rcCommand {
+				continue
+			}
+			flags := flagsFromLine(l)
+			for _, f := range flags {
+				f.ExpandedFrom = from
+			}
+			flags, err := e.expand(flags, stack)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, flags...)
+		}
+	}
+	if !defined {
+		return nil, fmt.Errorf("config value '%s' is not defined in any .rc file (%s)", name, from.Explain())
+	}
+	return out, nil
+}
+
+// applies returns whether a flag applies to the command. Bazel silently
+// ignores options from `common` lines that the command doesn't support.
+func (e *expander) applies(f *Flag) bool {
+	if f.RcCommand != "common" || !strings.HasPrefix(f.Value, "-") {
+		return true
+	}
+	o, _ := arg.LookupOption(f.Value)
+	if o == nil || len(o.Commands) == 0 {
+		return true
+	}
+	return slices.Contains(o.Commands, e.command)
+}
+
+func flagsFromLine(l *rcLine) []*Flag {
+	flags := make([]*Flag, 0, len(l.args))
+	for _, a := range l.args {
+		flags = append(flags, &Flag{Value: a, Source: l.source, Line: l.line, RcCommand: l.command, Config: l.config})
+	}
+	return flags
+}
+
+// readRcFiles reads the rc files that bazel would read given the startup
+// options, in the same order as bazel.
+func readRcFiles(startupArgs []string, inv *Invocation) ([]*rcLine, error) {
+	// --ignore_all_rc_files ignores the --bazelrc files too.
+	if hasStartupFlag(startupArgs, "ignore_all_rc_files") {
+		return nil, nil
+	}
+	ws, _ := workspace.Path()
+	var paths []string
+	if !hasNegatedStartupFlag(startupArgs, "system_rc") {
+		paths = append(paths, systemRcPath())
+	}
+	if ws != "" && !hasNegatedStartupFlag(startupArgs, "workspace_rc") {
+		paths = append(paths, filepath.Join(ws, ".bazelrc"))
+	}
+	if home, err := os.UserHomeDir(); err == nil && !hasNegatedStartupFlag(startupArgs, "home_rc") {
+		paths = append(paths, filepath.Join(home, ".bazelrc"))
+	}
+	defaults := len(paths)
+	for i := 0; i < len(startupArgs); i++ {
+		a := startupArgs[i]
+		p, ok := strings.CutPrefix(a, "--bazelrc=")
+		if a == "--bazelrc" && i+1 < len(startupArgs) {
+			i++
+			p, ok = startupArgs[i], true
+		}
+		if !ok {
+			continue
+		}
+		// --bazelrc=/dev/null stops any further --bazelrc from being read.
+		if p == "/dev/null" {
+			break
+		}
+		paths = append(paths, p)
+	}
+
+	r := &rcReader{workspace: ws, inv: inv}
+	for i, p := range paths {
+		// The default rc files are optional, but --bazelrc files aren't.
+		optional := i < defaults
+		if err := r.read(p, optional, nil); err != nil {
+			return nil, err
+		}
+	}
+	return r.lines, nil
+}
+
+func systemRcPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "bazel.bazelrc")
+	}
+	return "/etc/bazel.bazelrc"
+}
+
+func hasStartupFlag(args []string, name string) bool {
+	return slices.Contains(args, "--"+name) || slices.Contains(args, "--"+name+"=true")
+}
+
+func hasNegatedStartupFlag(args []string, name string) bool {
+	return slices.Contains(args, "--no"+name) || slices.Contains(args, "--"+name+"=false")
+}
+
+type rcReader struct {
+	workspace string
+	inv       *Invocation
+	lines     []*rcLine
+}
+
+// read appends the lines of the rc file at path, following imports. stack
+// holds the files currently being read, to detect import cycles.
+func (r *rcReader) read(path string, optional bool, stack []string) error {
+	if slices.Contains(stack, path) {
+		return fmt.Errorf("import cycle in rc files: %s", strings.Join(append(stack, path), " -> "))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read rc file: %s", err)
+	}
+	defer f.Close()
+	r.inv.RcFiles = append(r.inv.RcFiles, path)
+	stack = append(stack, path)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	var line strings.Builder
+	start := 0
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		text := scanner.Text()
+		if line.Len() == 0 {
+			start = lineNumber
+		}
+		// Lines ending in a backslash continue on the next line.
+		if strings.HasSuffix(text, "\\") {
+			line.WriteString(strings.TrimSuffix(text, "\\"))
+			line.WriteString(" ")
+			continue
+		}
+		line.WriteString(text)
+		err := r.parseLine(path, start, line.String(), stack)
+		line.Reset()
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if line.Len() > 0 {
+		return r.parseLine(path, start, line.String(), stack)
+	}
+	return nil
+}
+
+func (r *rcReader) parseLine(path string, lineNumber int, text string, stack []string) error {
+	if i := commentIndex(text); i >= 0 {
+		text = text[:i]
+	}
+	words, err := arg.SplitShell(text)
+	if err != nil {
+		return fmt.Errorf("%s:%d: %s", path, lineNumber, err)
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	switch words[0] {
+	case "import", "try-import":
+		if len(words) != 2 {
+			return fmt.Errorf("%s:%d: expected `%s <path>`", path, lineNumber, words[0])
+		}
+		importPath := words[1]
+		if strings.Contains(importPath, workspacePlaceholder) {
+			if r.workspace == "" {
+				// Bazel skips %workspace% imports outside of a workspace.
+				return nil
+			}
+			importPath = strings.ReplaceAll(importPath, workspacePlaceholder, r.workspace)
+		} else if !filepath.IsAbs(importPath) && r.workspace != "" {
+			importPath = filepath.Join(r.workspace, importPath)
+		}
+		return r.read(importPath, words[0] == "try-import", stack)
+	}
+	command, config, _ := strings.Cut(words[0], ":")
+	r.lines = append(r.lines, &rcLine{
+		command: command,
+		config:  config,
+		args:    words[1:],
+		source:  path,
+		line:    lineNumber,
+	})
+	return nil
+}
+
+// commentIndex returns the index of the "#" that starts a comment in an rc
+// line, or -1 if there is none. Like bazel, only a "#" at the start of a word
+// and outside of quotes starts a comment.
+func commentIndex(line string) int {
+	var quote rune
+	wordStart := true
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '#' && wordStart:
+			return i
+		}
+		wordStart = quote == 0 && (r == ' ' || r == '\t')
+	}
+	return -1
+}
+
+// HandleFlags handles the `ok flags` command.
+func HandleFlags(args []string) (exitCode int, err error) {
+	inv, err := Expand(args)
+	if err != nil {
+		return 1, err
+	}
+	if *explain {
+		fmt.Println("rc files:")
+		for _, f := range inv.RcFiles {
+			fmt.Printf("  %s\n", f)
+		}
+		fmt.Println()
+	}
+	printFlags("startup options:", inv.StartupOptions)
+	if inv.Command != "" {
+		printFlags("command: "+inv.Command, inv.Args)
+	}
+	if len(inv.ExecutableArgs) > 0 {
+		fmt.Println("executable args:")
+		for _, a := range inv.ExecutableArgs {
+			fmt.Printf("  %s\n", a)
+		}
+	}
+	return 0, nil
+}
+
+func printFlags(heading string, flags []*Flag) {
+	fmt.Println(heading)
+	width := 0
+	for _, f := range flags {
+		width = max(width, len(f.Value))
+	}
+	for _, f := range flags {
+		if *explain {
+			fmt.Printf("  %-*s  # %s\n", width, f.Value, f.Explain())
+		} else {
+			fmt.Printf("  %s\n", f.Value)
+		}
+	}
+	fmt.Println()
+}
@@ -0,0 +1,398 @@
+// Package bep reads the build events that bazel writes with
+// --build_event_json_file into a model of the invocation.
+//
+// See https://bazel.build/remote/bep for the events themselves.
+package bep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxOutputSize caps how much of a failed action's stdout or stderr is
+	// read into the model.
+	maxOutputSize = 64 * 1024
+)
+
+// Outcome is the result of building a target.
+type Outcome string
+
+const (
+	// OutcomeConfigured targets were analyzed, but bazel didn't report
+	// whether they were built, e.g. because the build was interrupted.
+	OutcomeConfigured Outcome = "configured"
+	OutcomeBuilt      Outcome = "built"
+	OutcomeFailed     Outcome = "failed"
+	// OutcomeAborted targets weren't built because of an error elsewhere, or
+	// because they failed to load or analyze.
+	OutcomeAborted Outcome = "aborted"
+)
+
+// Invocation is a single bazel invocation.
+type Invocation struct {
+	ID      string
+	Command string
+
+	// Patterns are the target patterns that were requested.
+	Patterns []string
+
+	StartTime  time.Time
+	FinishTime time.Time
+
+	// Finished is set once the build finished event was read. If it's unset,
+	// bazel crashed or was interrupted, and the rest of the model may be
+	// incomplete.
+	Finished     bool
+	ExitCode     int
+	ExitCodeName string
+
+	// Targets lists every target in the order bazel first reported it.
+	Targets []*Target
+
+	// FailedActions lists the actions that failed, in the order they failed.
+	FailedActions []*Action
+
+	// Aborted lists the reasons that parts of the build were skipped, such as
+	// patterns that failed to load.
+	Aborted []*Abort
+
+	Metrics *Metrics
+
+	targetsByLabel map[string]*Target
+}
+
+// Target is a configured target.
+type Target struct {
+	Label string
+	Kind  string
+	Tags  []string
+
+	Outcome Outcome
+
+	// FailureMessage explains why the target failed or was aborted, if bazel
+	// says.
+	FailureMessage string
+
+	// TestSize is set for test targets.
+	TestSize string
+
+	// TestResults lists each attempt of each run and shard of a test target.
+	TestResults []*TestResult
+
+	// TestSummary is set for test targets once all their runs are done.
+	TestSummary *TestSummary
+}
+
+// TestResult is a single attempt of a single run and shard of a test.
+type TestResult struct {
+	Run, Shard, Attempt int
+
+	// Status is one of bazel's test statuses, such as "PASSED", "FAILED",
+	// "FLAKY" or "TIMEOUT".
+	Status   string
+	Duration time.Duration
+	Cached   bool
+
+	// LogPath and XMLPath are the local paths of the test's log and its JUnit
+	// XML results, if available.
+	LogPath string
+	XMLPath string
+}
+
+// TestSummary summarizes all the results of a test target.
+type TestSummary struct {
+	Status        string
+	TotalRunCount int
+	RunCount      int
+	ShardCount    int
+	AttemptCount  int
+	CachedCount   int
+	Duration      time.Duration
+}
+
+// Action is an action that bazel reported, which it does for failed actions.
+type Action struct {
+	Label    string
+	Mnemonic string
+
+	ExitCode       int
+	FailureMessage string
+	CommandLine    []string
+
+	// Stdout and Stderr hold the action's output, truncated to a reasonable
+	// size, if bazel wrote it to a local file.
+	Stdout string
+	Stderr string
+
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// Abort describes a part of the build that was skipped.
+type Abort struct {
+	// Label is set if the abort is about a single target.
+	Label string
+	// Pattern is set if the abort is about a target pattern.
+	Pattern string
+
+	// Reason is one of bazel's abort reasons, such as "LOADING_FAILURE",
+	// "ANALYSIS_FAILURE" or "USER_INTERRUPTED".
+	Reason      string
+	Description string
+}
+
+// Metrics are the build's overall metrics.
+type Metrics struct {
+	WallTime           time.Duration
+	CPUTime            time.Duration
+	AnalysisPhaseTime  time.Duration
+	ExecutionPhaseTime time.Duration
+	ActionsCreated     int64
+	ActionsExecuted    int64
+}
+
+// ReadFile reads the build event JSON file at path.
+func ReadFile(path string) (*Invocation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads build events in JSON form from r. If the events are cut short,
+// for example because bazel crashed, the invocation read so far is returned
+// along with the error.
+func Read(r io.Reader) (*Invocation, error) {
+	inv := &Invocation{targetsByLabel: map[string]*Target{}}
+	dec := json.NewDecoder(r)
+	for {
+		var e event
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return inv, nil
+		}
+		if err != nil {
+			return inv, fmt.Errorf("failed to read build events: %s", err)
+		}
+		inv.add(&e)
+	}
+}
+
+// Target returns the target with the given label, or nil if bazel didn't
+// report it.
+func (inv *Invocation) Target(label string) *Target {
+	return inv.targetsByLabel[label]
+}
+
+// FailedTargets returns the targets that failed to build, or whose tests
+// didn't pass.
+func (inv *Invocation) FailedTargets() []*Target {
+	var out []*Target
+	for _, t := range inv.Targets {
+		if t.Outcome == OutcomeFailed || (t.TestSummary != nil && t.TestSummary.Status != "PASSED" && t.TestSummary.Status != "FLAKY") {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Duration returns how long the invocation took, or 0 if it didn't finish.
+func (inv *Invocation) Duration() time.Duration {
+	if !inv.Finished || inv.StartTime.IsZero() {
+		return 0
+	}
+	return inv.FinishTime.Sub(inv.StartTime)
+}
+
+func (inv *Invocation) target(label string) *Target {
+	if t, ok := inv.targetsByLabel[label]; ok {
+		return t
+	}
+	t := &Target{Label: label, Outcome: OutcomeConfigured}
+	inv.targetsByLabel[label] = t
+	inv.Targets = append(inv.Targets, t)
+	return t
+}
+
+func (inv *Invocation) add(e *event) {
+	switch {
+	case e.Started != nil:
+		inv.ID = e.Started.UUID
+		inv.Command = e.Started.Command
+		inv.StartTime = timestamp(e.Started.StartTime, e.Started.StartTimeMillis)
+	case e.Expanded != nil && e.ID.Pattern != nil:
+		inv.Patterns = append(inv.Patterns, e.ID.Pattern.Pattern...)
+	case e.Configured != nil && e.ID.TargetConfigured != nil:
+		t := inv.target(e.ID.TargetConfigured.Label)
+		t.Kind = strings.TrimSuffix(e.Configured.TargetKind, " rule")
+		t.Tags = e.Configured.Tag
+		if e.Configured.TestSize != "" && e.Configured.TestSize != "UNKNOWN" {
+			t.TestSize = strings.ToLower(e.Configured.TestSize)
+		}
+	case e.Completed != nil && e.ID.TargetCompleted != nil:
+		t := inv.target(e.ID.TargetCompleted.Label)
+		if e.Completed.Success {
+			t.Outcome = OutcomeBuilt
+		} else {
+			t.Outcome = OutcomeFailed
+			t.FailureMessage = e.Completed.FailureDetail.Message
+		}
+	case e.TestResult != nil && e.ID.TestResult != nil:
+		id, r := e.ID.TestResult, e.TestResult
+		result := &TestResult{
+			Run:      id.Run,
+			Shard:    id.Shard,
+			Attempt:  id.Attempt,
+			Status:   r.Status,
+			Duration: duration(r.TestAttemptDuration, r.TestAttemptDurationMillis),
+			Cached:   r.CachedLocally || r.ExecutionInfo.CachedRemotely,
+		}
+		for _, f := range r.TestActionOutput {
+			switch f.Name {
+			case "test.log":
+				result.LogPath = localPath(f.URI)
+			case "test.xml":
+				result.XMLPath = localPath(f.URI)
+			}
+		}
+		t := inv.target(id.Label)
+		t.TestResults = append(t.TestResults, result)
+	case e.TestSummary != nil && e.ID.TestSummary != nil:
+		s := e.TestSummary
+		inv.target(e.ID.TestSummary.Label).TestSummary = &TestSummary{
+			Status:        s.OverallStatus,
+			TotalRunCount: s.TotalRunCount,
+			RunCount:      s.RunCount,
+			ShardCount:    s.ShardCount,
+			AttemptCount:  s.AttemptCount,
+			CachedCount:   s.TotalNumCached,
+			Duration:      duration(s.TotalRunDuration, s.TotalRunDurationMillis),
+		}
+	case e.Action != nil:
+		a := e.Action
+		if a.Success {
+			return
+		}
+		label := a.Label
+		if label == "" && e.ID.ActionCompleted != nil {
+			label = e.ID.ActionCompleted.Label
+		}
+		inv.FailedActions = append(inv.FailedActions, &Action{
+			Label:          label,
+			Mnemonic:       a.Type,
+			ExitCode:       a.ExitCode,
+			FailureMessage: a.FailureDetail.Message,
+			CommandLine:    a.CommandLine,
+			Stdout:         readOutput(a.Stdout.URI),
+			Stderr:         readOutput(a.Stderr.URI),
+			StartTime:      timestamp(a.StartTime, 0),
+			EndTime:        timestamp(a.EndTime, 0),
+		})
+	case e.Aborted != nil:
+		abort := &Abort{Reason: e.Aborted.Reason, Description: e.Aborted.Description}
+		switch {
+		case e.ID.TargetCompleted != nil:
+			abort.Label = e.ID.TargetCompleted.Label
+		case e.ID.TargetConfigured != nil:
+			abort.Label = e.ID.TargetConfigured.Label
+		case e.ID.Pattern != nil:
+			abort.Pattern = strings.Join(e.ID.Pattern.Pattern, " ")
+		}
+		if abort.Label != "" {
+			t := inv.target(abort.Label)
+			t.Outcome = OutcomeAborted
+			t.FailureMessage = abort.Description
+		}
+		inv.Aborted = append(inv.Aborted, abort)
+	case e.Finished != nil:
+		inv.Finished = true
+		inv.ExitCode = e.Finished.ExitCode.Code
+		inv.ExitCodeName = e.Finished.ExitCode.Name
+		inv.FinishTime = timestamp(e.Finished.FinishTime, e.Finished.FinishTimeMillis)
+	case e.BuildMetrics != nil:
+		m := e.BuildMetrics
+		inv.Metrics = &Metrics{
+			WallTime:           time.Duration(m.TimingMetrics.WallTimeInMs) * time.Millisecond,
+			CPUTime:            time.Duration(m.TimingMetrics.CPUTimeInMs) * time.Millisecond,
+			AnalysisPhaseTime:  time.Duration(m.TimingMetrics.AnalysisPhaseTimeInMs) * time.Millisecond,
+			ExecutionPhaseTime: time.Duration(m.TimingMetrics.ExecutionPhaseTimeInMs) * time.Millisecond,
+			ActionsCreated:     int64(m.ActionSummary.ActionsCreated),
+			ActionsExecuted:    int64(m.ActionSummary.ActionsExecuted),
+		}
+	}
+}
+
+// localPath returns the path of a file:// URI, or "" for other URIs, such as
+// bytestream:// URIs of remotely stored files.
+func localPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return u.Path
+}
+
+func readOutput(uri string) string {
+	path := localPath(uri)
+	if path == "" {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	b, _ := io.ReadAll(io.LimitReader(f, maxOutputSize+1))
+	if len(b) > maxOutputSize {
+		return string(b[:maxOutputSize]) + "\n[truncated]"
+	}
+	return string(b)
+}
+
+// timestamp parses an RFC 3339 timestamp, falling back to the milliseconds
+// since the epoch that older bazel versions report.
+func timestamp(s string, millis int64String) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	if millis != 0 {
+		return time.UnixMilli(int64(millis))
+	}
+	return time.Time{}
+}
+
+// duration parses a duration like "1.500s", falling back to the milliseconds
+// that older bazel versions report.
+func duration(s string, millis int64String) time.Duration {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d
+	}
+	return time.Duration(millis) * time.Millisecond
+}
+
+// int64String is an int64 in proto3 JSON form, which is a string, although
+// numbers are accepted too.
+type int64String int64
+
+func (n *int64String) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = int64String(v)
+	return nil
+}
@@ -0,0 +1,133 @@
+package bep
+
+// These types mirror the parts of build_event_stream.proto that the model is
+// built from, in their proto3 JSON form. Fields that are int64 in the proto
+// are strings in JSON.
+
+type event struct {
+	ID eventID `json:"id"`
+
+	Started      *started      `json:"started"`
+	Expanded     *struct{}     `json:"expanded"`
+	Configured   *configured   `json:"configured"`
+	Completed    *completed    `json:"completed"`
+	TestResult   *testResult   `json:"testResult"`
+	TestSummary  *testSummary  `json:"testSummary"`
+	Action       *action       `json:"action"`
+	Aborted      *aborted      `json:"aborted"`
+	Finished     *finished     `json:"finished"`
+	BuildMetrics *buildMetrics `json:"buildMetrics"`
+}
+
+type eventID struct {
+	Pattern *struct {
+		Pattern []string `json:"pattern"`
+	} `json:"pattern"`
+	TargetConfigured *struct {
+		Label string `json:"label"`
+	} `json:"targetConfigured"`
+	TargetCompleted *struct {
+		Label string `json:"label"`
+	} `json:"targetCompleted"`
+	ActionCompleted *struct {
+		Label string `json:"label"`
+	} `json:"actionCompleted"`
+	TestResult *struct {
+		Label   string `json:"label"`
+		Run     int    `json:"run"`
+		Shard   int    `json:"shard"`
+		Attempt int    `json:"attempt"`
+	} `json:"testResult"`
+	TestSummary *struct {
+		Label string `json:"label"`
+	} `json:"testSummary"`
+}
+
+type started struct {
+	UUID            string      `json:"uuid"`
+	Command         string      `json:"command"`
+	StartTime       string      `json:"startTime"`
+	StartTimeMillis int64String `json:"startTimeMillis"`
+}
+
+type configured struct {
+	TargetKind string   `json:"targetKind"`
+	TestSize   string   `json:"testSize"`
+	Tag        []string `json:"tag"`
+}
+
+type failureDetail struct {
+	Message string `json:"message"`
+}
+
+type completed struct {
+	Success       bool          `json:"success"`
+	FailureDetail failureDetail `json:"failureDetail"`
+}
+
+type file struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+type testResult struct {
+	Status                    string      `json:"status"`
+	CachedLocally             bool        `json:"cachedLocally"`
+	TestAttemptDuration       string      `json:"testAttemptDuration"`
+	TestAttemptDurationMillis int64String `json:"testAttemptDurationMillis"`
+	TestActionOutput          []file      `json:"testActionOutput"`
+	ExecutionInfo             struct {
+		CachedRemotely bool `json:"cachedRemotely"`
+	} `json:"executionInfo"`
+}
+
+type testSummary struct {
+	OverallStatus          string      `json:"overallStatus"`
+	TotalRunCount          int         `json:"totalRunCount"`
+	RunCount               int         `json:"runCount"`
+	ShardCount             int         `json:"shardCount"`
+	AttemptCount           int         `json:"attemptCount"`
+	TotalNumCached         int         `json:"totalNumCached"`
+	TotalRunDuration       string      `json:"totalRunDuration"`
+	TotalRunDurationMillis int64String `json:"totalRunDurationMillis"`
+}
+
+type action struct {
+	Success       bool          `json:"success"`
+	Label         string        `json:"label"`
+	Type          string        `json:"type"`
+	ExitCode      int           `json:"exitCode"`
+	Stdout        file          `json:"stdout"`
+	Stderr        file          `json:"stderr"`
+	CommandLine   []string      `json:"commandLine"`
+	FailureDetail failureDetail `json:"failureDetail"`
+	StartTime     string        `json:"startTime"`
+	EndTime       string        `json:"endTime"`
+}
+
+type aborted struct {
+	Reason      string `json:"reason"`
+	Description string `json:"description"`
+}
+
+type finished struct {
+	ExitCode struct {
+		Name string `json:"name"`
+		Code int    `json:"code"`
+	} `json:"exitCode"`
+	FinishTime       string      `json:"finishTime"`
+	FinishTimeMillis int64String `json:"finishTimeMillis"`
+}
+
+type buildMetrics struct {
+	TimingMetrics struct {
+		WallTimeInMs           int64String `json:"wallTimeInMs"`
+		CPUTimeInMs            int64String `json:"cpuTimeInMs"`
+		AnalysisPhaseTimeInMs  int64String `json:"analysisPhaseTimeInMs"`
+		ExecutionPhaseTimeInMs int64String `json:"executionPhaseTimeInMs"`
+	} `json:"timingMetrics"`
+	ActionSummary struct {
+		ActionsCreated  int64String `json:"actionsCreated"`
+		ActionsExecuted int64String `json:"actionsExecuted"`
+	} `json:"actionSummary"`
+}
@@ -0,0 +1,571 @@
+// Package buildfile makes small edits to BUILD and MODULE.bazel files, such
+// as adding a label to a rule's deps. Edits are made to the text directly, so
+// that formatting and comments are preserved.
+package buildfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// File is a Starlark file being edited.
+type File struct {
+	Path    string
+	content []byte
+}
+
+// Call is a top-level function call, such as a rule declaration. Calls are
+// only valid until the file is next edited.
+type Call struct {
+	// Kind is the called function, such as "go_library" or "load".
+	Kind string
+	Args []*Arg
+
+	// start and end are the offsets of the call's first byte and of the byte
+	// after its closing parenthesis.
+	start, end int
+	multiline  bool
+}
+
+// Arg is an argument of a call. Name is empty for positional arguments.
+type Arg struct {
+	Name string
+
+	// start and end delimit the whole argument, and valueStart its value.
+	start, valueStart, end int
+}
+
+// Read reads the file at path.
+func Read(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Path: path, content: b}, nil
+}
+
+// Find returns the BUILD file of the package in dir, preferring BUILD.bazel,
+// or "" if there is none.
+func Find(dir string) string {
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// Save writes the file back to disk.
+func (f *File) Save() error {
+	return os.WriteFile(f.Path, f.content, 0644)
+}
+
+func (f *File) String() string {
+	return string(f.content)
+}
+
+// Calls returns the file's top-level calls.
+func (f *File) Calls() []*Call {
+	var calls []*Call
+	b := f.content
+	depth := 0
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == '#':
+			i = skipComment(b, i)
+			continue
+		case c == '"' || c == '\'':
+			i = skipString(b, i)
+			continue
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0 && isIdentStart(c) && (i == 0 || !isIdent(b[i-1])):
+			j := i
+			for j < len(b) && (isIdent(b[j]) || b[j] == '.') {
+				j++
+			}
+			k := j
+			for k < len(b) && (b[k] == ' ' || b[k] == '\t') {
+				k++
+			}
+			if k < len(b) && b[k] == '(' {
+				end := matching(b, k)
+				call := &Call{Kind: string(b[i:j]), start: i, end: end}
+				call.Args = parseItems(b, k+1, end-1)
+				call.multiline = bytes.ContainsRune(b[k:end], '\n')
+				calls = append(calls, call)
+				i = end
+				continue
+			}
+			i = j
+			continue
+		}
+		i++
+	}
+	return calls
+}
+
+// Rule returns the call whose name attribute is name, or nil.
+func (f *File) Rule(name string) *Call {
+	for _, c := range f.Calls() {
+		if f.StringAttr(c, "name") == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Call returns the first call of the given kind, such as "package", or nil.
+func (f *File) Call(kind string) *Call {
+	for _, c := range f.Calls() {
+		if c.Kind == kind {
+			return c
+		}
+	}
+	return nil
+}
+
+// Attr returns the named argument of the call, or nil.
+func (c *Call) Attr(name string) *Arg {
+	for _, a := range c.Args {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// StringAttr returns the value of a string attribute, or "" if it isn't set
+// to a string literal.
+func (f *File) StringAttr(c *Call, name string) string {
+	a := c.Attr(name)
+	if a == nil {
+		return ""
+	}
+	s, _ := unquote(f.content[a.valueStart:a.end])
+	return s
+}
+
+// ListAttr returns the strings in a list attribute. ok is false if the
+// attribute isn't set to a plain list, e.g. because it's a select().
+func (f *File) ListAttr(c *Call, name string) (values []string, ok bool) {
+	a := c.Attr(name)
+	if a == nil || !f.isList(a) {
+		return nil, false
+	}
+	for _, item := range parseItems(f.content, a.valueStart+1, a.end-1) {
+		if s, ok := unquote(f.content[item.start:item.end]); ok {
+			values = append(values, s)
+		}
+	}
+	return values, true
+}
+
+func (f *File) isList(a *Arg) bool {
+	return f.content[a.valueStart] == '[' && matching(f.content, a.valueStart) == a.end
+}
+
+// AddToList adds values to a list attribute of the call, creating the
+// attribute if it isn't set. Values that are already in the list are
+// skipped. Values are inserted in sorted order if the list is sorted.
+func (f *File) AddToList(c *Call, attr string, values ...string) error {
+	a := c.Attr(attr)
+	if a == nil {
+		f.addAttr(c, attr, values)
+		return nil
+	}
+	if !f.isList(a) {
+		return fmt.Errorf("%s of %s isn't a plain list", attr, f.describe(c))
+	}
+	for _, v := range values {
+		existing, _ := f.ListAttr(c, attr)
+		if slices.Contains(existing, v) {
+			continue
+		}
+		f.insertItem(a.valueStart, a.end, strconv.Quote(v), listKey)
+		// Offsets have moved; find the attribute again.
+		c = f.callAt(c.start)
+		a = c.Attr(attr)
+	}
+	return nil
+}
+
+// SetAttr sets an attribute of the call to value, a Starlark expression like
+// "True" or `"large"`, replacing the attribute's current value if it's set.
+func (f *File) SetAttr(c *Call, attr, value string) {
+	if a := c.Attr(attr); a != nil {
+		f.replace(a.valueStart, a.end, value)
+		return
+	}
+	f.appendAttr(c, attr+" = "+value)
+}
+
+// RemoveFromList removes value from a list attribute of the call, if it's
+// there.
+func (f *File) RemoveFromList(c *Call, attr, value string) {
+	a := c.Attr(attr)
+	if a == nil || !f.isList(a) {
+		return
+	}
+	items := parseItems(f.content, a.valueStart+1, a.end-1)
+	for i, item := range items {
+		if s, ok := unquote(f.content[item.start:item.end]); !ok || s != value {
+			continue
+		}
+		// Remove the item along with the separator that follows it, or
+		// precedes it if it's the last one.
+		lineStart, lineEnd, ownsLine := ownLine(f.content, item.start, item.end)
+		switch {
+		case len(items) == 1:
+			f.replace(a.valueStart+1, a.end-1, "")
+		case ownsLine:
+			// Remove the item's line, along with its comment.
+			f.replace(lineStart, lineEnd+1, "")
+		case i+1 < len(items):
+			f.replace(item.start, items[i+1].start, "")
+		default:
+			f.replace(items[i-1].end, item.end, "")
+		}
+		return
+	}
+}
+
+// AddLoad makes symbol available by loading it from module, adding it to an
+// existing load of that module if there is one.
+func (f *File) AddLoad(module, symbol string) {
+	calls := f.Calls()
+	var lastLoad *Call
+	for _, c := range calls {
+		if c.Kind != "load" {
+			continue
+		}
+		lastLoad = c
+		if len(c.Args) == 0 {
+			continue
+		}
+		if m, _ := unquote(f.content[c.Args[0].start:c.Args[0].end]); m != module {
+			continue
+		}
+		for _, a := range c.Args[1:] {
+			if s, _ := unquote(f.content[a.valueStart:a.end]); s == symbol {
+				return
+			}
+		}
+		last := c.Args[len(c.Args)-1]
+		f.appendItem(last, c.multiline, indentAt(f.content, last.start), strconv.Quote(symbol))
+		return
+	}
+	stmt := fmt.Sprintf("load(%s, %s)\n", strconv.Quote(module), strconv.Quote(symbol))
+	if lastLoad != nil {
+		f.replace(lastLoad.end, lastLoad.end, "\n"+strings.TrimSuffix(stmt, "\n"))
+		return
+	}
+	pos := headerEnd(f.content)
+	if pos < len(f.content) {
+		stmt += "\n"
+	}
+	f.replace(pos, pos, stmt)
+}
+
+func (f *File) describe(c *Call) string {
+	if name := f.StringAttr(c, "name"); name != "" {
+		return fmt.Sprintf("%s %q", c.Kind, name)
+	}
+	return c.Kind
+}
+
+func (f *File) callAt(start int) *Call {
+	for _, c := range f.Calls() {
+		if c.start == start {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *File) addAttr(c *Call, attr string, values []string) {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	f.appendAttr(c, fmt.Sprintf("%s = [%s]", attr, strings.Join(quoted, ", ")))
+}
+
+// appendAttr adds text, an attribute like `name = "foo"`, after the call's
+// last argument.
+func (f *File) appendAttr(c *Call, text string) {
+	if len(c.Args) == 0 {
+		f.replace(c.end-1, c.end-1, text)
+		return
+	}
+	last := c.Args[len(c.Args)-1]
+	f.appendItem(last, c.multiline, indentAt(f.content, last.start), text)
+}
+
+// insertItem inserts text into the list spanning [open, end), at its sorted
+// position if the list is sorted by key, or else at the end.
+func (f *File) insertItem(open, end int, text string, key func(string) string) {
+	items := parseItems(f.content, open+1, end-1)
+	if len(items) == 0 {
+		f.replace(open+1, end-1, text)
+		return
+	}
+	multiline := bytes.ContainsRune(f.content[open:end], '\n')
+	indent := indentAt(f.content, items[0].start)
+	sorted := true
+	for i := 1; i < len(items); i++ {
+		if key(string(f.content[items[i-1].start:items[i-1].end])) > key(string(f.content[items[i].start:items[i].end])) {
+			sorted = false
+		}
+	}
+	if sorted {
+		for _, item := range items {
+			if key(text) < key(string(f.content[item.start:item.end])) {
+				sep := ", "
+				if multiline {
+					sep = ",\n" + indent
+				}
+				f.replace(item.start, item.start, text+sep)
+				return
+			}
+		}
+	}
+	f.appendItem(items[len(items)-1], multiline, indent, text)
+}
+
+// appendItem inserts text as a new item after item. In multi-line lists, it
+// goes on a line of its own after the item's comment, if any.
+func (f *File) appendItem(item *Arg, multiline bool, indent, text string) {
+	if !multiline {
+		f.replace(item.end, item.end, ", "+text)
+		return
+	}
+	_, lineEnd, ok := ownLine(f.content, item.start, item.end)
+	if !ok {
+		f.replace(item.end, item.end, ",\n"+indent+text)
+		return
+	}
+	if i := skipSpaces(f.content, item.end); i == len(f.content) || f.content[i] != ',' {
+		f.replace(item.end, item.end, ",")
+		lineEnd++
+	}
+	f.replace(lineEnd, lineEnd, "\n"+indent+text+",")
+}
+
+func (f *File) replace(start, end int, text string) {
+	f.content = append(f.content[:start:start], append([]byte(text), f.content[end:]...)...)
+}
+
+// listKey orders labels the way buildifier sorts deps: local labels first,
+// then labels in the main repository, then external labels.
+func listKey(quoted string) string {
+	s, _ := unquote([]byte(quoted))
+	switch {
+	case strings.HasPrefix(s, ":"):
+		return "0" + s
+	case strings.HasPrefix(s, "//"):
+		return "1" + s
+	case strings.HasPrefix(s, "@"):
+		return "2" + s
+	}
+	return "3" + s
+}
+
+var namedArgPattern = regexp.MustCompile(`^([A-Za-z_]\w*)\s*=\s*`)
+
+// parseItems splits the comma-separated items in b[start:end], such as the
+// arguments of a call or the elements of a list.
+func parseItems(b []byte, start, end int) []*Arg {
+	var items []*Arg
+	itemStart, lastEnd, depth := -1, -1, 0
+	finish := func() {
+		if itemStart < 0 {
+			return
+		}
+		item := &Arg{start: itemStart, valueStart: itemStart, end: lastEnd}
+		if m := namedArgPattern.FindSubmatch(b[itemStart:lastEnd]); m != nil && !bytes.HasPrefix(b[itemStart+len(m[0]):lastEnd], []byte("=")) {
+			item.Name = string(m[1])
+			item.valueStart = itemStart + len(m[0])
+		}
+		items = append(items, item)
+		itemStart = -1
+	}
+	for i := start; i < end; {
+		c := b[i]
+		switch {
+		case c == '#':
+			i = skipComment(b, i)
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == ',' && depth == 0:
+			finish()
+			i++
+			continue
+		}
+		if itemStart < 0 {
+			itemStart = i
+		}
+		switch c {
+		case '"', '\'':
+			i = skipString(b, i)
+		case '(', '[', '{':
+			depth++
+			i++
+		case ')', ']', '}':
+			depth--
+			i++
+		default:
+			i++
+		}
+		lastEnd = i
+	}
+	finish()
+	return items
+}
+
+// matching returns the offset after the bracket that closes the one at open.
+func matching(b []byte, open int) int {
+	depth := 0
+	for i := open; i < len(b); {
+		switch b[i] {
+		case '#':
+			i = skipComment(b, i)
+			continue
+		case '"', '\'':
+			i = skipString(b, i)
+			continue
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+		i++
+	}
+	return len(b)
+}
+
+// skipString returns the offset after the string literal starting at i.
+func skipString(b []byte, i int) int {
+	q := b[i]
+	triple := i+2 < len(b) && b[i+1] == q && b[i+2] == q
+	if triple {
+		i += 3
+	} else {
+		i++
+	}
+	for i < len(b) {
+		switch {
+		case b[i] == '\\':
+			i += 2
+		case triple && i+2 < len(b) && b[i] == q && b[i+1] == q && b[i+2] == q:
+			return i + 3
+		case !triple && b[i] == q:
+			return i + 1
+		case !triple && b[i] == '\n':
+			return i
+		default:
+			i++
+		}
+	}
+	return len(b)
+}
+
+func skipSpaces(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// ownLine returns the bounds of the line that the item in b[start:end] is on,
+// without its newline, if nothing else is on it but a comma and a comment.
+func ownLine(b []byte, start, end int) (lineStart, lineEnd int, ok bool) {
+	lineStart = bytes.LastIndexByte(b[:start], '\n') + 1
+	if skipSpaces(b, lineStart) != start {
+		return 0, 0, false
+	}
+	i := skipSpaces(b, end)
+	if i < len(b) && b[i] == ',' {
+		i = skipSpaces(b, i+1)
+	}
+	if i < len(b) && b[i] == '#' {
+		i = skipComment(b, i)
+	}
+	if i == len(b) || b[i] != '\n' {
+		return 0, 0, false
+	}
+	return lineStart, i, true
+}
+
+func skipComment(b []byte, i int) int {
+	for i < len(b) && b[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+// headerEnd returns the offset after the comments and docstring at the top
+// of the file.
+func headerEnd(b []byte) int {
+	i := 0
+	for i < len(b) {
+		switch {
+		case b[i] == '#':
+			i = skipComment(b, i) + 1
+		case b[i] == '"' || b[i] == '\'':
+			i = skipString(b, i)
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+			i++
+		case b[i] == '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return len(b)
+}
+
+// indentAt returns the whitespace that the line containing offset i starts
+// with.
+func indentAt(b []byte, i int) string {
+	start := bytes.LastIndexByte(b[:i], '\n') + 1
+	end := start
+	for end < len(b) && (b[end] == ' ' || b[end] == '\t') {
+		end++
+	}
+	return string(b[start:end])
+}
+
+func unquote(b []byte) (string, bool) {
+	s := string(bytes.TrimSpace(b))
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		s = `"` + strings.ReplaceAll(s[1:len(s)-1], `"`, `\"`) + `"`
+	}
+	v, err := strconv.Unquote(s)
+	return v, err == nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
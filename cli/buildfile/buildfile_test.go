@@ -0,0 +1,323 @@
+package buildfile
+
+import (
+	"testing"
+)
+
+// rule returns the file with the given content and its rule named "a".
+func rule(t *testing.T, content string) (*File, *Call) {
+	t.Helper()
+	f := &File{Path: "BUILD", content: []byte(content)}
+	c := f.Rule("a")
+	if c == nil {
+		t.Fatalf("no rule named a in\n%s", content)
+	}
+	return f, c
+}
+
+func TestAddToList(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		in      string
+		values  []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "sorted",
+			in:     `go_library(name = "a", deps = [":b", "//d", "@e//:f"])`,
+			values: []string{"//c", ":a"},
+			want:   `go_library(name = "a", deps = [":a", ":b", "//c", "//d", "@e//:f"])`,
+		},
+		{
+			name:   "unsorted",
+			in:     `go_library(name = "a", deps = ["//z", "//b"])`,
+			values: []string{"//c"},
+			want:   `go_library(name = "a", deps = ["//z", "//b", "//c"])`,
+		},
+		{
+			name:   "already there",
+			in:     `go_library(name = "a", deps = ["//b"])`,
+			values: []string{"//b"},
+			want:   `go_library(name = "a", deps = ["//b"])`,
+		},
+		{
+			name:   "empty",
+			in:     `go_library(name = "a", deps = [])`,
+			values: []string{"//b"},
+			want:   `go_library(name = "a", deps = ["//b"])`,
+		},
+		{
+			name:   "missing",
+			in:     `go_library(name = "a")`,
+			values: []string{"//b", "//c"},
+			want:   `go_library(name = "a", deps = ["//b", "//c"])`,
+		},
+		{
+			name: "multi-line",
+			in: `go_library(
+    name = "a",
+    deps = [
+        ":b",
+        "//d",
+    ],
+)
+`,
+			values: []string{"//c", "//e"},
+			want: `go_library(
+    name = "a",
+    deps = [
+        ":b",
+        "//c",
+        "//d",
+        "//e",
+    ],
+)
+`,
+		},
+		{
+			name: "missing in a multi-line call",
+			in: `go_library(
+    name = "a",
+    srcs = ["a.go"],
+)
+`,
+			values: []string{"//b"},
+			want: `go_library(
+    name = "a",
+    srcs = ["a.go"],
+    deps = ["//b"],
+)
+`,
+		},
+		{
+			name: "trailing comments",
+			in: `go_library(
+    name = "a",  # the library
+    deps = [
+        ":b",  # keep
+        "//d"  # last
+    ],
+)
+`,
+			values: []string{"//c", "//e"},
+			want: `go_library(
+    name = "a",  # the library
+    deps = [
+        ":b",  # keep
+        "//c",
+        "//d",  # last
+        "//e",
+    ],
+)
+`,
+		},
+		{
+			name:    "select",
+			in:      `go_library(name = "a", deps = [":b"] + select({"//conditions:default": []}))`,
+			values:  []string{"//c"},
+			want:    `go_library(name = "a", deps = [":b"] + select({"//conditions:default": []}))`,
+			wantErr: true,
+		},
+		{
+			name:   "other rules",
+			in:     "go_library(name = \"z\", deps = [\"//z\"])\n\ngo_library(name = \"a\", deps = [\"//b\"])\n",
+			values: []string{"//c"},
+			want:   "go_library(name = \"z\", deps = [\"//z\"])\n\ngo_library(name = \"a\", deps = [\"//b\", \"//c\"])\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, c := rule(t, tc.in)
+			err := f.AddToList(c, "deps", tc.values...)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want an error: %t", err, tc.wantErr)
+			}
+			if got := f.String(); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRemoveFromList(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		in    string
+		value string
+		want  string
+	}{
+		{
+			name:  "first",
+			in:    `go_library(name = "a", deps = ["//b", "//c"])`,
+			value: "//b",
+			want:  `go_library(name = "a", deps = ["//c"])`,
+		},
+		{
+			name:  "last",
+			in:    `go_library(name = "a", deps = ["//b", "//c"])`,
+			value: "//c",
+			want:  `go_library(name = "a", deps = ["//b"])`,
+		},
+		{
+			name:  "only",
+			in:    `go_library(name = "a", deps = ["//b"])`,
+			value: "//b",
+			want:  `go_library(name = "a", deps = [])`,
+		},
+		{
+			name:  "missing",
+			in:    `go_library(name = "a", deps = ["//b"])`,
+			value: "//c",
+			want:  `go_library(name = "a", deps = ["//b"])`,
+		},
+		{
+			name: "multi-line with comments",
+			in: `go_library(
+    name = "a",
+    deps = [
+        ":b",  # keep
+        "//c",  # remove
+        "//d",
+    ],
+)
+`,
+			value: "//c",
+			want: `go_library(
+    name = "a",
+    deps = [
+        ":b",  # keep
+        "//d",
+    ],
+)
+`,
+		},
+		{
+			name: "last of a multi-line list",
+			in: `go_library(
+    name = "a",
+    deps = [
+        ":b",  # keep
+        "//c",
+    ],
+)
+`,
+			value: "//c",
+			want: `go_library(
+    name = "a",
+    deps = [
+        ":b",  # keep
+    ],
+)
+`,
+		},
+		{
+			name:  "select",
+			in:    `go_library(name = "a", deps = [":b"] + select({"//conditions:default": [":b"]}))`,
+			value: ":b",
+			want:  `go_library(name = "a", deps = [":b"] + select({"//conditions:default": [":b"]}))`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, c := rule(t, tc.in)
+			f.RemoveFromList(c, "deps", tc.value)
+			if got := f.String(); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetAttr(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "replace",
+			in:   `go_test(name = "a", size = "small", srcs = ["a_test.go"])`,
+			want: `go_test(name = "a", size = "large", srcs = ["a_test.go"])`,
+		},
+		{
+			name: "add",
+			in:   `go_test(name = "a")`,
+			want: `go_test(name = "a", size = "large")`,
+		},
+		{
+			name: "add to a multi-line call",
+			in:   "go_test(\n    name = \"a\",  # the test\n)\n",
+			want: "go_test(\n    name = \"a\",  # the test\n    size = \"large\",\n)\n",
+		},
+		{
+			name: "replace a select",
+			in:   "go_test(\n    name = \"a\",\n    size = select({\"//x\": \"small\"}),  # varies\n)\n",
+			want: "go_test(\n    name = \"a\",\n    size = \"large\",  # varies\n)\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, c := rule(t, tc.in)
+			f.SetAttr(c, "size", `"large"`)
+			if got := f.String(); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAddLoad(t *testing.T) {
+	const module = "@rules_go//go:def.bzl"
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "no loads",
+			in:   "go_test(name = \"a\")\n",
+			want: "load(\"@rules_go//go:def.bzl\", \"go_test\")\n\ngo_test(name = \"a\")\n",
+		},
+		{
+			name: "empty file",
+			in:   "",
+			want: "load(\"@rules_go//go:def.bzl\", \"go_test\")\n",
+		},
+		{
+			name: "existing load of the module",
+			in:   "load(\"@rules_go//go:def.bzl\", \"go_library\")\n\ngo_test(name = \"a\")\n",
+			want: "load(\"@rules_go//go:def.bzl\", \"go_library\", \"go_test\")\n\ngo_test(name = \"a\")\n",
+		},
+		{
+			name: "already loaded",
+			in:   "load(\"@rules_go//go:def.bzl\", \"go_test\")\n",
+			want: "load(\"@rules_go//go:def.bzl\", \"go_test\")\n",
+		},
+		{
+			name: "multi-line load",
+			in:   "load(\n    \"@rules_go//go:def.bzl\",\n    \"go_library\",\n)\n",
+			want: "load(\n    \"@rules_go//go:def.bzl\",\n    \"go_library\",\n    \"go_test\",\n)\n",
+		},
+		{
+			name: "loads of other modules",
+			in:   "load(\"@rules_cc//cc:defs.bzl\", \"cc_library\")\nload(\"@rules_proto//proto:defs.bzl\", \"proto_library\")\n\ncc_library(name = \"a\")\n",
+			want: "load(\"@rules_cc//cc:defs.bzl\", \"cc_library\")\nload(\"@rules_proto//proto:defs.bzl\", \"proto_library\")\nload(\"@rules_go//go:def.bzl\", \"go_test\")\n\ncc_library(name = \"a\")\n",
+		},
+		{
+			name: "comment header",
+			in:   "# Copyright 2025\n# The authors\n\ngo_test(name = \"a\")\n",
+			want: "# Copyright 2025\n# The authors\n\nload(\"@rules_go//go:def.bzl\", \"go_test\")\n\ngo_test(name = \"a\")\n",
+		},
+		{
+			name: "docstring header",
+			in:   "\"\"\"The package's tests.\n\nThey're slow.\n\"\"\"\n\ngo_test(name = \"a\")\n",
+			want: "\"\"\"The package's tests.\n\nThey're slow.\n\"\"\"\n\nload(\"@rules_go//go:def.bzl\", \"go_test\")\n\ngo_test(name = \"a\")\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &File{Path: "BUILD", content: []byte(tc.in)}
+			f.AddLoad(module, "go_test")
+			if got := f.String(); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
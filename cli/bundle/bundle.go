@@ -0,0 +1,464 @@
+// Package bundle gathers the context that an agent needs to fix a failed
+// bazel command, so that it doesn't have to rediscover it.
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/bazelisk"
+	"ok.build/cli/bazelrc"
+	"ok.build/cli/bep"
+	"ok.build/cli/config"
+	"ok.build/cli/diagnostic"
+	"ok.build/cli/log"
+	"ok.build/cli/testlog"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// defaultTokenBudget is used when the agent.context_tokens config key
+	// isn't set.
+	defaultTokenBudget = 16000
+
+	// bytesPerToken estimates the size of a token.
+	bytesPerToken = 4
+
+	// maxTargets is how many failing targets get their BUILD file and query
+	// output included.
+	maxTargets = 3
+	// maxSources is how many source locations get their lines included.
+	maxSources = 10
+	// sourceContext is how many lines are shown around each error.
+	sourceContext = 5
+	// buildFileContext is how many lines are shown around a target's
+	// declaration in BUILD files that are too long to include in full.
+	buildFileContext = 40
+	// outputTailLines is how many of the last lines of output are included.
+	outputTailLines = 80
+)
+
+// Failure describes a failed bazel command.
+type Failure struct {
+	Args []string
+
+	// Invocation is read from the build events, if bazel wrote them.
+	Invocation *bep.Invocation
+
+	Diagnostics []*diagnostic.Diagnostic
+
+	// TestCases are the failing test cases of failed tests.
+	TestCases []*testlog.Case
+
+	// Output is bazel's output, without escape codes.
+	Output string
+}
+
+// Section is a part of the bundle. Sections are listed in order of
+// importance, so that the least important ones are trimmed first.
+type Section struct {
+	Title string
+	Body  string
+}
+
+// Bundle is the context for a failure.
+type Bundle struct {
+	Sections []*Section
+
+	// TokenBudget is the approximate number of tokens that String may
+	// return.
+	TokenBudget int
+}
+
+// Build gathers the context for the given failure. Context that can't be
+// gathered is left out.
+func Build(f *Failure) *Bundle {
+	b := &Bundle{TokenBudget: config.GetInt("agent.context_tokens", defaultTokenBudget)}
+	ws, err := workspace.Path()
+	if err != nil {
+		log.Debugf("Building agent context without a workspace: %s", err)
+	}
+	labels := failingLabels(f)
+
+	b.add("Errors", errorList(f))
+	b.add("Failed targets", failedTargets(f.Invocation))
+	b.add("Failing test cases", testCases(f.TestCases))
+	b.add("Source lines near the errors", sources(ws, f.Diagnostics))
+	b.add("BUILD files of the failing targets", buildFiles(ws, labels, f.Diagnostics))
+	b.add("Failing targets as seen by `bazel query --output=build`", queryTargets(f.Args, labels))
+	b.add("Flags from .bazelrc files", rcFlags(f.Args))
+	b.add("MODULE.bazel", moduleSnippets(ws, f.Diagnostics))
+	b.add("Uncommitted changes (git diff HEAD)", gitDiff(ws))
+	b.add("Last lines of bazel's output", tail(f.Output, outputTailLines))
+	return b
+}
+
+func (b *Bundle) add(title, body string) {
+	if body = strings.TrimRight(body, "\n"); body != "" {
+		b.Sections = append(b.Sections, &Section{Title: title, Body: body})
+	}
+}
+
+// String renders the bundle, trimming the least important sections to fit
+// the token budget.
+func (b *Bundle) String() string {
+	remaining := b.TokenBudget * bytesPerToken
+	var out strings.Builder
+	for _, s := range b.Sections {
+		header := fmt.Sprintf("## %s\n\n", s.Title)
+		if remaining < len(header)+len(truncated) {
+			fmt.Fprintf(&out, "(%s omitted to save space)\n", s.Title)
+			continue
+		}
+		remaining -= len(header)
+		body := s.Body
+		if len(body) > remaining {
+			body = strings.ToValidUTF8(body[:remaining-len(truncated)], "") + truncated
+		}
+		remaining -= len(body)
+		out.WriteString(header + body + "\n\n")
+	}
+	return out.String()
+}
+
+const truncated = "\n[truncated]"
+
+// WriteFile writes the bundle to path.
+func (b *Bundle) WriteFile(path string) error {
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func errorList(f *Failure) string {
+	var b strings.Builder
+	for _, d := range f.Diagnostics {
+		fmt.Fprintf(&b, "- %s error", d.Kind)
+		if d.Label != "" {
+			fmt.Fprintf(&b, " in %s", d.Label)
+		}
+		if d.File != "" {
+			fmt.Fprintf(&b, " at %s", d.File)
+			if d.Line > 0 {
+				fmt.Fprintf(&b, ":%d", d.Line)
+			}
+		}
+		fmt.Fprintf(&b, ": %s\n", d.Message)
+		for _, c := range d.Compiler {
+			fmt.Fprintf(&b, "  %s\n", c.String())
+			for _, l := range c.Details {
+				fmt.Fprintf(&b, "    %s\n", l)
+			}
+		}
+		if len(d.Compiler) == 0 && d.Output != "" {
+			fmt.Fprintf(&b, "  Output:\n%s\n", indent(tail(d.Output, 30), "    "))
+		}
+	}
+	return b.String()
+}
+
+func failedTargets(inv *bep.Invocation) string {
+	if inv == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, t := range inv.FailedTargets() {
+		status := string(t.Outcome)
+		if t.TestSummary != nil {
+			status = strings.ToLower(t.TestSummary.Status)
+		}
+		fmt.Fprintf(&b, "- %s (%s %s)", t.Label, t.Kind, status)
+		if t.FailureMessage != "" {
+			fmt.Fprintf(&b, ": %s", t.FailureMessage)
+		}
+		b.WriteString("\n")
+	}
+	for _, a := range inv.FailedActions {
+		fmt.Fprintf(&b, "- %s action for %s failed with exit code %d\n", a.Mnemonic, a.Label, a.ExitCode)
+	}
+	for _, a := range inv.Aborted {
+		if a.Description != "" && a.Label == "" {
+			fmt.Fprintf(&b, "- bazel stopped early (%s): %s\n", strings.ToLower(a.Reason), a.Description)
+		}
+	}
+	return b.String()
+}
+
+func testCases(cases []*testlog.Case) string {
+	var b strings.Builder
+	for _, c := range cases {
+		fmt.Fprintf(&b, "- %s\n", c.Title())
+		for _, f := range c.Frames[:min(len(c.Frames), 3)] {
+			fmt.Fprintf(&b, "    at %s:%d\n", f.File, f.Line)
+		}
+	}
+	return b.String()
+}
+
+// failingLabels returns the main repository labels of the failing targets,
+// most relevant first.
+func failingLabels(f *Failure) []string {
+	var labels []string
+	add := func(l string) {
+		l = strings.TrimPrefix(strings.TrimPrefix(l, "@@"), "@")
+		if strings.HasPrefix(l, "//") && !slices.Contains(labels, l) {
+			labels = append(labels, l)
+		}
+	}
+	for _, d := range f.Diagnostics {
+		add(d.Label)
+	}
+	if f.Invocation != nil {
+		for _, t := range f.Invocation.FailedTargets() {
+			add(t.Label)
+		}
+	}
+	if len(labels) > maxTargets {
+		labels = labels[:maxTargets]
+	}
+	return labels
+}
+
+func sources(ws string, diagnostics []*diagnostic.Diagnostic) string {
+	if ws == "" {
+		return ""
+	}
+	var b strings.Builder
+	seen := map[string]bool{}
+	n := 0
+	for _, d := range diagnostics {
+		for _, c := range d.Compiler {
+			key := fmt.Sprintf("%s:%d", c.File, c.Line)
+			if n == maxSources || c.Line == 0 || seen[key] {
+				continue
+			}
+			seen[key] = true
+			lines, err := readLines(resolve(ws, c.File), c.Line-sourceContext, c.Line+sourceContext, c.Line)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&b, "%s:\n%s\n", key, lines)
+			n++
+		}
+	}
+	return b.String()
+}
+
+// resolve returns the path of a file named in bazel's output, which is
+// either absolute or relative to the execution root, whose source files
+// mirror the workspace's.
+func resolve(ws, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(ws, file)
+}
+
+// readLines returns lines first through last (1-based, inclusive) of the
+// file, numbered, with the marked line highlighted.
+func readLines(path string, first, last, marked int) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(string(b), "\n")
+	first = max(first, 1)
+	last = min(last, len(lines))
+	var out strings.Builder
+	for i := first; i <= last; i++ {
+		prefix := "  "
+		if i == marked {
+			prefix = "> "
+		}
+		fmt.Fprintf(&out, "%s%4d | %s\n", prefix, i, lines[i-1])
+	}
+	return out.String(), nil
+}
+
+func buildFiles(ws string, labels []string, diagnostics []*diagnostic.Diagnostic) string {
+	if ws == "" {
+		return ""
+	}
+	var b strings.Builder
+	seen := map[string]bool{}
+	for _, label := range labels {
+		pkg, _, _ := strings.Cut(strings.TrimPrefix(label, "//"), ":")
+		file := findBuildFile(filepath.Join(ws, pkg))
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		rel, _ := filepath.Rel(ws, file)
+		lines := strings.Count(string(content), "\n")
+		if lines <= 2*buildFileContext {
+			fmt.Fprintf(&b, "%s:\n%s\n", rel, content)
+			continue
+		}
+		// Show the part of a long BUILD file around the target.
+		line := declarationLine(file, label, diagnostics, string(content))
+		excerpt, err := readLines(file, line-buildFileContext/2, line+buildFileContext, line)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s (excerpt):\n%s\n", rel, excerpt)
+	}
+	return b.String()
+}
+
+func findBuildFile(dir string) string {
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// declarationLine returns the line of the target's declaration in its BUILD
+// file, as reported by bazel or else found by its name.
+func declarationLine(file, label string, diagnostics []*diagnostic.Diagnostic, content string) int {
+	for _, d := range diagnostics {
+		if d.Line > 0 && d.File == file && strings.TrimLeft(d.Label, "@") == label {
+			return d.Line
+		}
+	}
+	_, name, ok := strings.Cut(label, ":")
+	if !ok {
+		name = path.Base(label)
+	}
+	pattern := regexp.MustCompile(`name\s*=\s*"` + regexp.QuoteMeta(name) + `"`)
+	if loc := pattern.FindStringIndex(content); loc != nil {
+		return strings.Count(content[:loc[0]], "\n") + 1
+	}
+	return 1
+}
+
+func queryTargets(args []string, labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	// Reuse the failed command's startup options, so that bazel doesn't
+	// restart its server.
+	_, idx := arg.GetCommandAndIndex(args)
+	var queryArgs []string
+	if idx > 0 {
+		queryArgs = append(queryArgs, args[:idx]...)
+	}
+	queryArgs = append(queryArgs, "query", "--output=build", "--keep_going", strings.Join(labels, " + "))
+	out := &bytes.Buffer{}
+	if _, err := bazelisk.Run(queryArgs, &bazelisk.RunOpts{Stdout: out, Stderr: io.Discard}); err != nil {
+		log.Debugf("Failed to query failing targets: %s", err)
+		return ""
+	}
+	return out.String()
+}
+
+func rcFlags(args []string) string {
+	inv, err := bazelrc.Expand(args)
+	if err != nil {
+		log.Debugf("Failed to expand bazelrc flags: %s", err)
+		return ""
+	}
+	var b strings.Builder
+	for _, f := range slices.Concat(inv.StartupOptions, inv.Args) {
+		if f.Source == bazelrc.CommandLine && f.ExpandedFrom == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s  # %s\n", f.Value, f.Explain())
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("The effective command line was: bazel %s\n\n%s", strings.Join(inv.Strings(), " "), b.String())
+}
+
+// repoNamePattern matches repository names in bazel's messages, like
+// "@rules_go//", "@@rules_go+//" or "repository 'rules_go'".
+var repoNamePattern = regexp.MustCompile(`@@?([\w.-]+?)[+~]*//|repository '@{0,2}([\w.-]+?)[+~]*'`)
+
+// moduleSnippets returns the lines of MODULE.bazel that mention the
+// repositories named in the errors, or all of it if a fetch failed and no
+// repository could be matched.
+func moduleSnippets(ws string, diagnostics []*diagnostic.Diagnostic) string {
+	if ws == "" {
+		return ""
+	}
+	b, err := os.ReadFile(filepath.Join(ws, "MODULE.bazel"))
+	if err != nil {
+		return ""
+	}
+	content := string(b)
+	var repos []string
+	fetchFailed := false
+	for _, d := range diagnostics {
+		fetchFailed = fetchFailed || d.Kind == diagnostic.KindFetch
+		for _, m := range repoNamePattern.FindAllStringSubmatch(d.Message+"\n"+d.Output, -1) {
+			if name := m[1] + m[2]; name != "" && !slices.Contains(repos, name) {
+				repos = append(repos, name)
+			}
+		}
+	}
+	lines := strings.Split(content, "\n")
+	var excerpt strings.Builder
+	last := -1
+	for i, line := range lines {
+		mentioned := false
+		for _, r := range repos {
+			mentioned = mentioned || strings.Contains(line, `"`+r+`"`)
+		}
+		if !mentioned {
+			continue
+		}
+		for j := max(i-2, last+1); j <= min(i+2, len(lines)-1); j++ {
+			if j > last+1 && last >= 0 {
+				excerpt.WriteString("...\n")
+			}
+			fmt.Fprintf(&excerpt, "%4d | %s\n", j+1, lines[j])
+			last = j
+		}
+	}
+	if excerpt.Len() > 0 {
+		return excerpt.String()
+	}
+	if fetchFailed {
+		return content
+	}
+	return ""
+}
+
+func gitDiff(ws string) string {
+	if ws == "" {
+		return ""
+	}
+	cmd := exec.Command("git", "diff", "HEAD", "--stat", "--patch")
+	cmd.Dir = ws
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func tail(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,138 @@
+package bundle
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"ok.build/cli/config"
+	"ok.build/cli/log"
+	"ok.build/cli/testlog"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// maxFrames is how many stack frames get their lines included.
+	maxFrames = 5
+	// testSourceContext is how many lines are shown around a failing line
+	// of a test, which is usually the assertion, to include its setup.
+	testSourceContext = 15
+	// maxSubjectLines is how many lines of a file under test are included
+	// when no stack frame points into it.
+	maxSubjectLines = 200
+	// detailLines is how many lines of a failure's details are included.
+	detailLines = 40
+)
+
+// BuildTestCase gathers the context for one failing test case of a failed
+// bazel test command: the failure, the test's source, its log and the code
+// under test.
+func BuildTestCase(args []string, c *testlog.Case) *Bundle {
+	b := &Bundle{TokenBudget: config.GetInt("agent.context_tokens", defaultTokenBudget)}
+	ws, err := workspace.Path()
+	if err != nil {
+		log.Debugf("Building agent context without a workspace: %s", err)
+	}
+	testFrames, subjectFrames := splitFrames(ws, c.Frames)
+
+	b.add("Failing test case", testCaseDetails(c))
+	b.add("Test source", frameSources(ws, testFrames, testSourceContext))
+	b.add("Code under test", codeUnderTest(ws, testFrames, subjectFrames))
+	b.add("Test log excerpt", c.LogExcerpt())
+	b.add("BUILD file of the test", buildFiles(ws, []string{c.Target}, nil))
+	b.add("The test as seen by `bazel query --output=build`", queryTargets(args, []string{c.Target}))
+	b.add("Uncommitted changes (git diff HEAD)", gitDiff(ws))
+	return b
+}
+
+func testCaseDetails(c *testlog.Case) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Target: %s\n", c.Target)
+	if c.Name != "" {
+		fmt.Fprintf(&b, "Test case: %s\n", c.FullName())
+	}
+	fmt.Fprintf(&b, "Status: %s\n", c.Status)
+	if c.Message != "" {
+		fmt.Fprintf(&b, "Message: %s\n", c.Message)
+	}
+	if c.LogPath != "" {
+		fmt.Fprintf(&b, "Log: %s\n", c.LogPath)
+	}
+	if c.Details != "" && c.Details != c.Message {
+		fmt.Fprintf(&b, "Details:\n%s\n", indent(tail(c.Details, detailLines), "  "))
+	}
+	return b.String()
+}
+
+// resolvedFrame is a stack frame whose file was found in the workspace.
+type resolvedFrame struct {
+	path string
+	line int
+}
+
+// splitFrames resolves the frames that are in the workspace, splitting them
+// into frames in test files and frames in the code under test.
+func splitFrames(ws string, frames []*testlog.Frame) (tests, subjects []*resolvedFrame) {
+	if ws == "" {
+		return nil, nil
+	}
+	for _, f := range frames {
+		path := testlog.Resolve(ws, f)
+		if path == "" || f.Line == 0 {
+			continue
+		}
+		r := &resolvedFrame{path: path, line: f.Line}
+		if testlog.IsTestFile(path) {
+			tests = append(tests, r)
+		} else {
+			subjects = append(subjects, r)
+		}
+	}
+	return tests, subjects
+}
+
+func frameSources(ws string, frames []*resolvedFrame, context int) string {
+	var b strings.Builder
+	seen := map[string]bool{}
+	n := 0
+	for _, f := range frames {
+		key := fmt.Sprintf("%s:%d", f.path, f.line)
+		if n == maxFrames || seen[key] {
+			continue
+		}
+		seen[key] = true
+		lines, err := readLines(resolve(ws, f.path), f.line-context, f.line+context, f.line)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n%s\n", key, lines)
+		n++
+	}
+	return b.String()
+}
+
+// codeUnderTest shows the lines of the stack frames outside of tests, and
+// the beginning of the files that the failing tests test.
+func codeUnderTest(ws string, testFrames, subjectFrames []*resolvedFrame) string {
+	if ws == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(frameSources(ws, subjectFrames, sourceContext))
+	var subjects []string
+	for _, f := range testFrames {
+		for _, s := range testlog.SubjectFiles(ws, f.path) {
+			if !slices.Contains(subjects, s) && !slices.ContainsFunc(subjectFrames, func(f *resolvedFrame) bool { return f.path == s }) {
+				subjects = append(subjects, s)
+			}
+		}
+	}
+	for _, s := range subjects {
+		lines, err := readLines(resolve(ws, s), 1, maxSubjectLines, 0)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n%s\n", s, lines)
+	}
+	return b.String()
+}
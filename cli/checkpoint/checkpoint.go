@@ -0,0 +1,304 @@
+// Package checkpoint snapshots the working tree of a git repository before
+// an agent works in it, so that its changes can be undone.
+//
+// A checkpoint is a commit of every file in the working tree, including
+// untracked ones but not ignored ones, made with a temporary index so that
+// the user's index is left alone. Commits are kept under
+// refs/ok/checkpoints, which git log and git branch don't show.
+package checkpoint
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ok.build/cli/config"
+	"ok.build/cli/log"
+)
+
+const (
+	refPrefix = "refs/ok/checkpoints/"
+	// defaultKeep is how many checkpoints are kept unless the checkpoint.keep
+	// config key is set.
+	defaultKeep = 20
+)
+
+// Checkpoint is a snapshot of the working tree.
+type Checkpoint struct {
+	// Number orders the checkpoints of a repository, from the oldest.
+	Number      int
+	Commit      string
+	Time        time.Time
+	Description string
+
+	// dir is the root of the repository's working tree.
+	dir string
+}
+
+func (c *Checkpoint) String() string {
+	return fmt.Sprintf("%s  %s", c.Time.Format(time.DateTime), c.Description)
+}
+
+// Create snapshots the working tree of the git repository that the current
+// directory is in. It returns nil if the directory isn't in a git repository,
+// or if the checkpoint.keep config key is 0.
+func Create(description string) (*Checkpoint, error) {
+	keep := config.GetInt("checkpoint.keep", defaultKeep)
+	if keep <= 0 {
+		return nil, nil
+	}
+	dir, err := git("", nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		log.Debugf("Not checkpointing outside of a git repository: %s", err)
+		return nil, nil
+	}
+	tree, err := snapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Checkpoints are never pushed, so they aren't signed, which could ask
+	// for a passphrase.
+	args := []string{"commit-tree", "--no-gpg-sign", tree, "-m", description}
+	if head, err := git(dir, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		args = append(args, "-p", head)
+	}
+	// Checkpoints are ok's commits, which works without a git identity.
+	commit, err := git(dir, []string{
+		"GIT_AUTHOR_NAME=ok", "GIT_AUTHOR_EMAIL=ok@localhost",
+		"GIT_COMMITTER_NAME=ok", "GIT_COMMITTER_EMAIL=ok@localhost",
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := listIn(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Checkpoint{Number: 1, Commit: commit, Time: time.Now(), Description: description, dir: dir}
+	if len(checkpoints) > 0 {
+		c.Number = checkpoints[0].Number + 1
+	}
+	if _, err := git(dir, nil, "update-ref", c.ref(), commit); err != nil {
+		return nil, err
+	}
+	// Drop the oldest checkpoints, keeping the new one.
+	if len(checkpoints) >= keep {
+		for _, old := range checkpoints[keep-1:] {
+			if err := old.Drop(); err != nil {
+				log.Debugf("Failed to drop checkpoint %d: %s", old.Number, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// List returns the checkpoints of the git repository that the current
+// directory is in, newest first.
+func List() ([]*Checkpoint, error) {
+	dir, err := git("", nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("not in a git repository")
+	}
+	return listIn(dir)
+}
+
+// listIn returns the checkpoints of the repository at dir, newest first.
+func listIn(dir string) ([]*Checkpoint, error) {
+	out, err := git(dir, nil, "for-each-ref", "--format=%(refname) %(objectname) %(committerdate:unix) %(contents:subject)", refPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []*Checkpoint
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) < 3 {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(fields[0], refPrefix))
+		if err != nil {
+			continue
+		}
+		t, _ := strconv.ParseInt(fields[2], 10, 64)
+		c := &Checkpoint{Number: n, Commit: fields[1], Time: time.Unix(t, 0), dir: dir}
+		if len(fields) == 4 {
+			c.Description = fields[3]
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].Number > checkpoints[j].Number })
+	return checkpoints, nil
+}
+
+// Find returns the checkpoint that ref refers to: its position in the list,
+// where the latest checkpoint is 1.
+func Find(checkpoints []*Checkpoint, ref string) (int, error) {
+	i, err := strconv.Atoi(ref)
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("invalid checkpoint %q, expected a number from ok undo --list", ref)
+	}
+	if i > len(checkpoints) {
+		return 0, fmt.Errorf("there are only %d checkpoints", len(checkpoints))
+	}
+	return i - 1, nil
+}
+
+// Changes returns the diff stat of the changes made to the working tree
+// since the checkpoint, which is empty if there are none.
+func (c *Checkpoint) Changes() (string, error) {
+	tree, err := snapshot(c.dir)
+	if err != nil {
+		return "", err
+	}
+	return git(c.dir, nil, "diff", "--stat", "--no-renames", c.Commit, tree)
+}
+
+// Restore puts the working tree back the way it was at the checkpoint: files
+// created since are removed, and changed or removed files get their old
+// content back. Ignored files are left alone.
+func (c *Checkpoint) Restore() error {
+	tree, err := snapshot(c.dir)
+	if err != nil {
+		return err
+	}
+	added, err := git(c.dir, nil, "diff", "--name-only", "-z", "--no-renames", "--diff-filter=A", c.Commit, tree)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths(added) {
+		path = filepath.Join(c.dir, path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// Remove the directories that were created for the file.
+		for dir := filepath.Dir(path); dir != c.dir; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	changed, err := git(c.dir, nil, "diff", "--name-only", "-z", "--no-renames", "--diff-filter=DMT", c.Commit, tree)
+	if err != nil {
+		return err
+	}
+	if changed == "" {
+		return nil
+	}
+	index, err := tempIndex()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(index)
+	env := []string{"GIT_INDEX_FILE=" + index}
+	if _, err := git(c.dir, env, "read-tree", c.Commit); err != nil {
+		return err
+	}
+	cmd := exec.Command("git", "checkout-index", "--force", "-z", "--stdin")
+	cmd.Dir = c.dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = strings.NewReader(changed)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git checkout-index failed: %s", bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// Drop deletes the checkpoint.
+func (c *Checkpoint) Drop() error {
+	_, err := git(c.dir, nil, "update-ref", "-d", c.ref())
+	return err
+}
+
+func (c *Checkpoint) ref() string {
+	return refPrefix + strconv.Itoa(c.Number)
+}
+
+// snapshot writes every file of the working tree that isn't ignored to the
+// object database, and returns the tree that holds them.
+func snapshot(dir string) (string, error) {
+	index, err := tempIndex()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(index)
+	// Start from the user's index, so that git only hashes the files that
+	// changed since it was written.
+	if path, err := git(dir, nil, "rev-parse", "--git-path", "index"); err == nil {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if err := copyFile(path, index); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	env := []string{"GIT_INDEX_FILE=" + index}
+	if _, err := git(dir, env, "add", "--all"); err != nil {
+		return "", err
+	}
+	return git(dir, env, "write-tree")
+}
+
+// tempIndex returns a path for a temporary index file, which doesn't exist
+// yet.
+func tempIndex() (string, error) {
+	f, err := os.CreateTemp("", "ok-index-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), os.Remove(f.Name())
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// paths splits the NUL separated output of git.
+func paths(out string) []string {
+	var paths []string
+	for _, p := range strings.Split(out, "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// git runs git in dir, with env added to its environment, and returns its
+// output without the trailing newline.
+func git(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s failed: %s", args[0], bytes.TrimSpace(exitErr.Stderr))
+		}
+		return "", fmt.Errorf("git %s failed: %s", args[0], err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
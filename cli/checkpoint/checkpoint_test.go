@@ -0,0 +1,171 @@
+package checkpoint
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setUp creates a git repository with a committed file, an untracked file and
+// an ignored file, and runs the test in it. commit.gpgSign is set with a
+// signing program that fails, so that checkpoints fail with the versions of
+// git that sign the commits of commit-tree unless told not to.
+func setUp(t *testing.T) string {
+	t.Helper()
+	home, dir := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	write(t, "BUILD", "go_library()\n")
+	write(t, ".gitignore", "*.log\n")
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"config", "user.name", "Test"},
+		{"config", "user.email", "test@localhost"},
+		{"add", "BUILD", ".gitignore"},
+		{"commit", "--quiet", "--no-gpg-sign", "-m", "Initial commit"},
+		{"config", "commit.gpgSign", "true"},
+		{"config", "gpg.program", "false"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %s", args[0], out)
+		}
+	}
+	write(t, "notes.txt", "untracked\n")
+	write(t, "build.log", "ignored\n")
+	return dir
+}
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// files returns the files of the working tree and their content, other than
+// git's.
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			if path != dir {
+				files = append(files, d.Name()+"/")
+			}
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, rel+": "+strings.TrimSpace(string(b)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// edit makes the changes of an agent session: an edit and a new file.
+func edit(t *testing.T) {
+	write(t, "BUILD", "go_library(deps = [\"//lib\"])\n")
+	write(t, "lib/BUILD", "go_library()\n")
+}
+
+func TestCreate(t *testing.T) {
+	setUp(t)
+	c, err := Create("ok fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.Number != 1 {
+		t.Fatalf("Create() = %v, want checkpoint 1", c)
+	}
+	if changes, err := c.Changes(); err != nil || changes != "" {
+		t.Errorf("got changes %q, %v right after the checkpoint, want none", changes, err)
+	}
+
+	edit(t)
+	changes, err := c.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"BUILD", "lib/BUILD"} {
+		if !strings.Contains(changes, path) {
+			t.Errorf("got changes\n%s\nwant them to list %s", changes, path)
+		}
+	}
+	for _, path := range []string{"notes.txt", "build.log"} {
+		if strings.Contains(changes, path) {
+			t.Errorf("got changes\n%s\nwant them to leave out %s", changes, path)
+		}
+	}
+	// The user's index is left alone.
+	if out, err := exec.Command("git", "diff", "--cached", "--name-only").Output(); err != nil || len(out) > 0 {
+		t.Errorf("got staged files %q, %v, want none", out, err)
+	}
+
+	checkpoints, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0].Commit != c.Commit || checkpoints[0].Description != "ok fix" {
+		t.Errorf("List() = %v, want the checkpoint", checkpoints)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	dir := setUp(t)
+	before := files(t, dir)
+	if _, err := Create("ok fix"); err != nil {
+		t.Fatal(err)
+	}
+	edit(t)
+	after := files(t, dir)
+
+	if _, err := HandleUndo(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, dir); !slices.Equal(got, before) {
+		t.Errorf("ok undo left %q, want %q", got, before)
+	}
+	checkpoints, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0].Description != undoDescription {
+		t.Errorf("got checkpoints %v after ok undo, want the one made by ok undo", checkpoints)
+	}
+	if _, err := HandleUndo(nil); err == nil {
+		t.Error("ok undo undid the last undo, want an error since the session was undone")
+	}
+
+	if _, err := HandleUndo([]string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, dir); !slices.Equal(got, after) {
+		t.Errorf("ok undo 1 left %q, want %q", got, after)
+	}
+}
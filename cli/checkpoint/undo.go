@@ -0,0 +1,99 @@
+package checkpoint
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+var (
+	Flags = flag.NewFlagSet("undo", flag.ContinueOnError)
+
+	list = Flags.Bool("list", false, "List the checkpoints rather than restoring one.")
+)
+
+// undoDescription describes the checkpoints that ok undo makes before it
+// restores one.
+const undoDescription = "ok undo"
+
+const Description = `
+Undoes the changes that agents made to the working tree. Before each agent
+session, ok checkpoints every file in the git repository that isn't ignored,
+including untracked files. ok undo restores the files from before the latest
+session, removing the files that were created since; run it again to undo
+the session before. The git index, branches and stash are left alone.
+
+Pass a number from ok undo --list to go back to an older checkpoint at once,
+which drops the checkpoints after it.
+
+ok undo checkpoints the working tree itself before restoring one, so that an
+undo can be undone with ok undo 1. Running ok undo again without a number
+skips those checkpoints.
+
+Checkpoints are kept as refs/ok/checkpoints/* in the repository. The
+checkpoint.keep config key sets how many are kept (default 20); set it to 0
+to stop making them.
+`
+
+// HandleUndo handles the `ok undo` command.
+func HandleUndo(args []string) (exitCode int, err error) {
+	checkpoints, err := List()
+	if err != nil {
+		return 1, err
+	}
+	if *list {
+		if len(checkpoints) == 0 {
+			fmt.Println("No checkpoints have been made in this repository yet.")
+		}
+		for i, c := range checkpoints {
+			fmt.Printf("%3d  %s\n", i+1, c)
+		}
+		return 0, nil
+	}
+	if len(checkpoints) == 0 {
+		return 1, fmt.Errorf("there are no checkpoints to undo to in this repository")
+	}
+	// Undo the latest session, rather than the latest undo.
+	i := slices.IndexFunc(checkpoints, func(c *Checkpoint) bool { return c.Description != undoDescription })
+	if len(args) > 0 {
+		if i, err = Find(checkpoints, args[0]); err != nil {
+			return 1, err
+		}
+	}
+	if i < 0 {
+		return 1, fmt.Errorf("every agent session has been undone; run ok undo 1 to undo the last undo")
+	}
+	c := checkpoints[i]
+	changes, err := c.Changes()
+	if err != nil {
+		return 1, err
+	}
+	var before *Checkpoint
+	if changes != "" {
+		if before, err = Create(undoDescription); err != nil {
+			return 1, fmt.Errorf("failed to checkpoint the working tree before restoring: %s", err)
+		}
+	}
+	if err := c.Restore(); err != nil {
+		return 1, fmt.Errorf("failed to restore checkpoint: %s", err)
+	}
+	for _, dropped := range checkpoints[:i+1] {
+		if dropped != c && dropped.Description == undoDescription {
+			// Keep the way to undo earlier undos.
+			continue
+		}
+		if err := dropped.Drop(); err != nil {
+			return 1, err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Restored the working tree from before `%s` (%s)\n", c.Description, c.Time.Format(time.DateTime))
+	if changes != "" {
+		fmt.Fprintf(os.Stderr, "Undone:\n%s\n", changes)
+	}
+	if before != nil {
+		fmt.Fprintln(os.Stderr, "Run `ok undo 1` to undo this.")
+	}
+	return 0, nil
+}
@@ -1,102 +1,191 @@
+// Package claude implements agent.Agent with the claude CLI, which must be
+// on PATH.
 package claude
 
 import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
-	"time"
+	"sync"
 
-	"ok.build/cli/picker"
-	// "ok.build/cli/spinner" // Uncomment when spinner code is enabled
-	"golang.org/x/term"
-	"ok.build/cli/textarea"
+	"ok.build/cli/agent"
+	"ok.build/cli/agent/permission"
+	"ok.build/cli/config"
 )
 
-var usedTokens int = 0
-var startTime time.Time = time.Now()
+// maxLineSize is the size of the longest line of stream-json output that can
+// be read, which holds a whole message.
+const maxLineSize = 16 * 1024 * 1024
 
-func Run(stdin *os.File, extraArgs []string, interactive bool) (int, error) {
-	claudeArgs := []string{}
-	startTime = time.Now()
+// CLI is an agent that runs the claude CLI.
+type CLI struct{}
 
-	renderThinking(0)
-	// todo add gemini and openai and amp support
+// New returns an agent that runs the claude CLI.
+func New() (agent.Agent, error) {
+	return &CLI{}, nil
+}
 
-	systemPrompt := "You are a Bazel expert and you are helping the user fix a Bazel error. " +
-		"If no workspace is found, you will help the user migrate the project to Bazel using bzlmod. " +
-		"If the fix is not straightforward, think of 3 possible fixes and present them to the user using the <select><option>...</option></select> syntax. " +
-		"If asking the user a yes/no question, use the <select><option>...</option></select> syntax. "
+func (c *CLI) Name() string {
+	return "claude"
+}
 
-	claudeArgs = append(claudeArgs,
-		"--verbose",
-		"--output-format=stream-json",
-		"--print",
-		"--dangerously-skip-permissions",
-		"--append-system-prompt",
-		systemPrompt)
+func (c *CLI) Start(req *agent.Request) (agent.Session, error) {
+	policy, err := permission.Load()
+	if err != nil {
+		return nil, err
+	}
+	s := &session{systemPrompt: req.SystemPrompt, policy: policy}
+	if err := s.run(req.Context, req.Prompt); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-	if !interactive {
+func (c *CLI) Resume(sessionID string, message string) (agent.Session, error) {
+	policy, err := permission.Load()
+	if err != nil {
+		return nil, err
+	}
+	s := &session{id: sessionID, systemPrompt: agent.SystemPrompt(), policy: policy}
+	if err := s.run(nil, "--resume", sessionID, message); err != nil {
+		return nil, err
 	}
+	return s, nil
+}
 
-	claudeArgs = append(claudeArgs, extraArgs...)
+// session runs claude once per turn, resuming the session to answer it, or
+// to retry the tool uses that it denied and the user allowed.
+type session struct {
+	systemPrompt string
+	policy       *permission.Policy
+	events       chan *agent.Event
+
+	mu sync.Mutex
+	id string
+	// err is the error that ended the last turn.
+	err error
+	// granted are the tool uses that claude is allowed for the rest of the
+	// session, as rules of the claude CLI.
+	granted []string
+}
 
-	cmd := exec.Command("claude", claudeArgs...)
+func (s *session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return 1, err
+func (s *session) Events() <-chan *agent.Event {
+	return s.events
+}
+
+func (s *session) Answer(text string) error {
+	return s.run(nil, s.resumeArgs(text)...)
+}
+
+// resumeArgs returns the args that continue the session with a message.
+func (s *session) resumeArgs(message string) []string {
+	if id := s.ID(); id != "" {
+		return []string{"--resume", id, message}
 	}
+	return []string{"--continue", message}
+}
+
+func (s *session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
 
-	stderr, err := cmd.StderrPipe()
+// run starts claude with the given args for the next turn, streaming its
+// events until it exits.
+func (s *session) run(stdin io.Reader, extraArgs ...string) error {
+	cmd, stdout, outputFile, err := s.start(stdin, extraArgs)
 	if err != nil {
-		return 1, err
+		return err
+	}
+	s.events = make(chan *agent.Event)
+	go s.turn(cmd, stdout, outputFile)
+	return nil
+}
+
+// start starts claude with the given args.
+func (s *session) start(stdin io.Reader, extraArgs []string) (*exec.Cmd, io.Reader, *os.File, error) {
+	claudeArgs := []string{
+		"--verbose",
+		"--output-format=stream-json",
+		"--print",
 	}
+	claudeArgs = append(claudeArgs, s.permissionArgs()...)
+	claudeArgs = append(claudeArgs, "--append-system-prompt", s.systemPrompt)
+	claudeArgs = append(claudeArgs, extraArgs...)
 
-	if stdin != nil {
-		cmd.Stdin = stdin
+	cmd := exec.Command("claude", claudeArgs...)
+	cmd.Stdin = stdin
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	// Create ~/.ok directory if it doesn't exist
-	homeDir, err := os.UserHomeDir()
+	// Keep claude's raw output in ~/.ok for debugging.
+	okDir, err := config.OkDir()
 	if err != nil {
-		return 1, fmt.Errorf("failed to get home directory: %v", err)
+		return nil, nil, nil, err
 	}
-	okDir := filepath.Join(homeDir, ".ok")
 	if err := os.MkdirAll(okDir, 0755); err != nil {
-		return 1, fmt.Errorf("failed to create .ok directory: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to create .ok directory: %v", err)
 	}
-
-	// Create output file for stream-json
 	outputFile, err := os.Create(filepath.Join(okDir, "output.json"))
 	if err != nil {
-		return 1, fmt.Errorf("failed to create output file: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to create output file: %v", err)
 	}
-	defer outputFile.Close()
 
 	if err := cmd.Start(); err != nil {
-		return 1, err
+		outputFile.Close()
+		return nil, nil, nil, err
 	}
+	return cmd, stdout, outputFile, nil
+}
 
-	// Handle stderr in a goroutine
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			fmt.Fprintln(os.Stderr, scanner.Text())
+// turn reads the events of claude's turn, and closes the events channel when
+// the turn ends. When claude denied tool uses that the user then allowed, it
+// is resumed to retry them within the turn.
+func (s *session) turn(cmd *exec.Cmd, stdout io.Reader, outputFile *os.File) {
+	defer close(s.events)
+	for {
+		retry := s.read(cmd, stdout, outputFile)
+		if len(retry) == 0 {
+			return
+		}
+		message := fmt.Sprintf("The user allowed %s. Try again.", strings.Join(retry, ", "))
+		var err error
+		cmd, stdout, outputFile, err = s.start(nil, s.resumeArgs(message))
+		if err != nil {
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			return
 		}
-	}()
+	}
+}
 
-	// Handle stdout
-	scanner := bufio.NewScanner(stdout)
-	toolUseLines := make(map[string]int) // Map tool use IDs to line numbers
-	currentNumLines := 0
+// read turns claude's stream-json output into events until claude exits. It
+// returns the tool uses that claude denied and the user allowed.
+func (s *session) read(cmd *exec.Cmd, stdout io.Reader, outputFile *os.File) (retry []string) {
+	defer outputFile.Close()
+
+	uses := make(map[string]*agent.Event)
 
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
 	for scanner.Scan() {
 		line := scanner.Text()
 		var response LogLine
@@ -104,321 +193,72 @@ func Run(stdin *os.File, extraArgs []string, interactive bool) (int, error) {
 		// Write raw output to file
 		fmt.Fprintln(outputFile, line)
 
-		// fmt.Printf("line: %s\n", line)
-
 		if err := json.Unmarshal([]byte(line), &response); err != nil {
 			log.Printf("Failed to parse JSON line: %v", err)
 			continue
 		}
 
-		// json, err := json.Marshal(response)
-		// if err != nil {
-		// 	log.Printf("Failed to marshal content: %v", err)
-		// 	continue
-		// }
-		// fmt.Printf("⏺ %s\n", string(json))
-
-		if response.Message != nil {
-			for _, content := range response.Message.Content {
-				renderDone()
-				if content.Name != "" {
-					bullet, numLines := renderBullet(renderToolUse(content), "  ", "\033[1m⏺\033[0m ", true)
-					fmt.Printf("%s", bullet)
-					toolUseLines[content.ID] = currentNumLines // Store line count for this tool use
-					currentNumLines += numLines
-				}
-				if content.Text != "" {
-					text := regexp.MustCompile(`<select>((?s).*?)</select>`).ReplaceAllString(content.Text, "")
-					bullet, numLines := renderBullet(text, "  ", "\033[1m⏺\033[0m ", true)
-					fmt.Printf("%s", bullet)
-					currentNumLines += numLines
-
-					// Check for select/option tags in the text
-					if matches := regexp.MustCompile(`<select>((?s).*?)</select>`).FindAllStringSubmatch(content.Text, -1); matches != nil {
-						for _, match := range matches {
-							selectContent := match[1]
-							options := []picker.Option{}
-
-							// Extract options with attributes - match anything between <option and > for attributes
-							optionMatches := regexp.MustCompile(`<option([^>]*)>([^<]+)</option>`).FindAllStringSubmatch(selectContent, -1)
-							for _, opt := range optionMatches {
-								label := opt[2]
-
-								options = append(options, picker.Option{
-									Label: label,
-									Value: label,
-								})
-							}
-
-							options = append(options, picker.Option{
-								Label: "Something else",
-								Value: "Something else",
-							})
-
-							if len(options) > 0 {
-								// Show picker and get selection
-								selected, err := picker.ShowPicker("Which would you like to do?", options)
-								if err != nil {
-									continue
-								}
-
-								if selected == "Something else" {
-									// Get custom input from user
-									userInput, err := textarea.ShowTextarea("What would you like to do instead?", "Type here... For example: "+options[0].Label)
-									if err != nil {
-										continue
-									}
-									if userInput == "" {
-										continue
-									}
-									selected = userInput
-								}
-
-								Run(stdin, []string{"--continue", selected}, interactive)
-							}
-						}
-					}
-					// todo tab rendering of long text
-				}
-				if content.Content != "" {
-					if toolLine, ok := toolUseLines[content.ToolUseID]; ok {
-						if content.IsError {
-							fmt.Printf("%s", renderColoredBullet(currentNumLines-toolLine-1, "red", "⏺", ""))
-						} else {
-							fmt.Printf("%s", renderColoredBullet(currentNumLines-toolLine-1, "green", "⏺", ""))
-						}
+		if response.Type == "system" && response.SessionID != "" {
+			s.mu.Lock()
+			s.id = response.SessionID
+			s.mu.Unlock()
+		}
+
+		if response.Message == nil {
+			continue
+		}
+		for _, content := range response.Message.Content {
+			switch {
+			case content.Name != "":
+				use := &agent.Event{Type: agent.ToolUse, ToolUseID: content.ID, ToolName: content.Name, ToolInput: content.Input}
+				uses[content.ID] = use
+				s.events <- use
+			case content.Text != "":
+				s.events <- &agent.Event{Type: agent.Text, Text: content.Text}
+			case content.Content != "":
+				s.events <- &agent.Event{Type: agent.ToolResult, ToolUseID: content.ToolUseID, Text: content.Content, IsError: content.IsError}
+				if use, ok := uses[content.ToolUseID]; ok && content.IsError && strings.Contains(content.Content, deniedMessage) {
+					if rule := s.allow(use); rule != "" {
+						retry = append(retry, rule)
 					}
 				}
 			}
 		}
-
-		if response.Message != nil && response.Message.Usage != nil {
-			usedTokens += response.Message.Usage.InputTokens + response.Message.Usage.OutputTokens
+		if usage := response.Message.Usage; usage != nil {
+			s.events <- &agent.Event{Type: agent.Usage, Tokens: usage.InputTokens + usage.OutputTokens}
 		}
-
-		renderThinking(usedTokens)
 	}
 
-	renderDone()
-
 	if err := scanner.Err(); err != nil {
 		log.Printf("Error reading stdout: %v", err)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		log.Printf("Failed to run claude: %v", err)
-	}
-
-	return 0, nil
-}
-
-func renderPath(path string) string {
-	cwd, err := os.Getwd()
+	err := cmd.Wait()
 	if err != nil {
-		return path
+		err = fmt.Errorf("failed to run claude: %v", err)
+		retry = nil
 	}
-	rel, err := filepath.Rel(cwd, path)
-	if err != nil {
-		return path
-	}
-	return rel
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	return retry
 }
 
-func renderToolUse(content Part) string {
-	inputJSON, err := content.Input.MarshalJSON()
-	if err != nil {
-		log.Printf("Failed to marshal input: %v", err)
+// allow asks the permission policy about a tool use that claude denied. If
+// it is allowed, it returns the rule that allows it from now on, unless
+// claude was already given it.
+func (s *session) allow(use *agent.Event) string {
+	if !s.policy.Allowed(s.events, use.ToolName, subject(use.ToolName, use.ToolInput)) {
 		return ""
 	}
-
-	var jsonMap map[string]interface{}
-	if err := json.Unmarshal(inputJSON, &jsonMap); err != nil {
-		log.Printf("Failed to unmarshal input: %v", err)
+	rule := grant(use.ToolName, subject(use.ToolName, use.ToolInput))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.Contains(s.granted, rule) {
 		return ""
 	}
-
-	inputs := ""
-
-	if content.Name == "LS" {
-		content.Name = "List"
-		inputs = fmt.Sprintf("(%s)", renderPath(jsonMap["path"].(string)))
-	} else if content.Name == "Grep" {
-		content.Name = "Find"
-	} else if content.Name == "TodoWrite" {
-		content.Name = "Update Todos"
-		var todoItems []string
-		// jsonMap["todos"] contains the array of todo items
-		if todosArray, ok := jsonMap["todos"].([]interface{}); ok {
-			for _, todo := range todosArray {
-				todoMap, ok := todo.(map[string]interface{})
-				if !ok {
-					log.Printf("Failed to unmarshal todo: %v", todo)
-					continue
-				}
-				content := todoMap["content"].(string)
-				status := todoMap["status"].(string)
-
-				item := content
-				if status == "completed" {
-					item = fmt.Sprintf("☒ \033[9m%s\033[0m", item)
-				} else {
-					item = fmt.Sprintf("☐ %s", item)
-				}
-				todoItems = append(todoItems, item)
-			}
-		} else {
-			log.Printf("Failed to get todos array from jsonMap: %v", jsonMap)
-		}
-		inputs = fmt.Sprintf("\n%s", strings.Join(todoItems, "\n"))
-	} else if content.Name == "Read" {
-		inputs = fmt.Sprintf("(%s)", renderPath(jsonMap["file_path"].(string)))
-	} else if content.Name == "Bash" {
-		inputs = fmt.Sprintf("(%s)", jsonMap["command"].(string))
-	}
-
-	if inputs == "" {
-		var values []string
-		for _, v := range jsonMap {
-			values = append(values, fmt.Sprintf("%v", v))
-		}
-		inputs = fmt.Sprintf("(%s)", strings.Join(values, ", "))
-	}
-
-	return fmt.Sprintf("\033[1m%s\033[0m%s", content.Name, inputs)
-}
-
-func renderBullet(text string, indent string, bulletPrefix string, newLine bool) (string, int) {
-	width := 80 // Default width
-	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
-		width = w
-	}
-
-	// Split text into words
-	words := strings.Fields(text)
-	if len(words) == 0 {
-		return "", 0
-	}
-
-	// First line gets the bullet with 2 space indent
-	// bulletPrefix := "⏺ "
-	// indent := "  "
-	lineWidth := width - len(indent)
-
-	var result strings.Builder
-	var currentLine strings.Builder
-	currentLine.WriteString(bulletPrefix)
-	lineLen := len(bulletPrefix)
-
-	// Count number of lines
-	numLines := 1
-	if newLine {
-		result.WriteString("\n")
-		numLines++
-	}
-
-	// Build lines word by word
-	for _, word := range words {
-		if lineLen+len(word)+1 > lineWidth && currentLine.Len() > len(bulletPrefix) {
-			// Line would be too long, start a new one
-			result.WriteString(currentLine.String())
-			result.WriteString("\n")
-			currentLine.Reset()
-			currentLine.WriteString(indent)
-			lineLen = len(indent)
-			numLines++
-		}
-		currentLine.WriteString(" ")
-		lineLen++
-		currentLine.WriteString(word)
-		lineLen += len(word)
-	}
-
-	// Add final line
-	if currentLine.Len() > 0 {
-		result.WriteString(currentLine.String())
-	}
-	result.WriteString("\n")
-
-	return result.String(), numLines
-}
-
-func renderColoredBullet(height int, color string, bullet string, suffix string) string {
-	// ANSI escape codes
-	greenColor := "\033[32m"
-	redColor := "\033[31m"
-	blueColor := "\033[96m"
-	resetColor := "\033[0m"
-
-	if color == "green" {
-		color = greenColor
-	} else if color == "red" {
-		color = redColor
-	} else if color == "blue" {
-		color = blueColor
-	}
-
-	// Move up N lines, back to start, replace bullet, then move back down N lines
-	return fmt.Sprintf("\033[s\033[%dA\r%s%s%s%s\033[u", height, color, bullet, resetColor, suffix)
-}
-
-var isThinking = false
-var stopThinking = make(chan bool)
-var renderedTokenCount int = 0
-var tickCount int = 0
-
-func renderThinkingString(thinkingString string, dots string, spaces string, renderedTokenCount int) string {
-	tokensString := fmt.Sprintf(" (%s)", time.Since(startTime).Round(time.Second))
-	if renderedTokenCount > 0 {
-		tokensString = fmt.Sprintf(" (%s, %d tokens)", time.Since(startTime).Round(time.Second), renderedTokenCount)
-	}
-
-	return fmt.Sprintf("  %s%s%s%s\033[K", thinkingString, dots, spaces, tokensString)
-}
-
-var thinkingIndex int = 0
-
-func renderThinking(tokens int) {
-	if isThinking || !term.IsTerminal(int(os.Stdout.Fd())) {
-		return
-	}
-
-	thinkingStringOptions := []string{
-		"Thinking", "Reticulating", "Building", "Analyzing", "Querying", "Optimizing", "Refactoring", "Debugging", "Checking", "Fixing", "Enhancing", "Testing", "Validating", "Improving",
-	}
-
-	isThinking = true
-	fmt.Printf("\n\r\033[36m⣿\033[0m%s\n\n", renderThinkingString(thinkingStringOptions[thinkingIndex], "...", "", renderedTokenCount))
-
-	go func() {
-		spinChars := []rune{'⣾', '⣽', '⣻', '⢿', '⡿', '⣟', '⣯', '⣷'}
-		for {
-			select {
-			case <-stopThinking:
-				return
-			case <-time.After(66 * time.Millisecond):
-				if renderedTokenCount < usedTokens {
-					renderedTokenCount += int(math.Max(1, float64((tokens-renderedTokenCount)/50)))
-				}
-				numDots := (tickCount / 10) % 4
-				thinkingIndex = (tickCount / 80) % len(thinkingStringOptions)
-
-				dots := strings.Repeat(".", numDots)
-				spaces := strings.Repeat(" ", 3-numDots)
-				fmt.Printf("%s", renderColoredBullet(2, "blue", fmt.Sprintf("%c", spinChars[tickCount%len(spinChars)]), renderThinkingString(thinkingStringOptions[thinkingIndex], dots, spaces, renderedTokenCount)))
-				tickCount = (tickCount + 1)
-			}
-		}
-	}()
-}
-
-func renderDone() {
-	if !term.IsTerminal(int(os.Stdout.Fd())) {
-		return
-	}
-
-	stopThinking <- true
-	fmt.Print("\033[1A\033[K\033[1A\033[K\033[1A\033[K")
-	isThinking = false
+	s.granted = append(s.granted, rule)
+	return rule
 }
 
 type LogLine struct {
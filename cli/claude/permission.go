@@ -0,0 +1,163 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"ok.build/cli/agent/permission"
+)
+
+// deniedMessage is in the results of the tool uses that the claude CLI
+// denies because they aren't allowed.
+const deniedMessage = "requested permissions to use"
+
+// fileTools are the tools whose rules the claude CLI matches against paths.
+var fileTools = map[string]bool{"Read": true, "Edit": true}
+
+// subject returns what a tool of the claude CLI is used on, as the
+// permission policy sees it.
+func subject(tool string, input json.RawMessage) string {
+	var in struct {
+		Command      string `json:"command"`
+		FilePath     string `json:"file_path"`
+		NotebookPath string `json:"notebook_path"`
+		Path         string `json:"path"`
+		URL          string `json:"url"`
+	}
+	json.Unmarshal(input, &in)
+	switch {
+	case tool == "Bash":
+		return in.Command
+	case in.FilePath != "":
+		return permission.Path(in.FilePath)
+	case in.NotebookPath != "":
+		return permission.Path(in.NotebookPath)
+	case tool == "Glob" || tool == "Grep" || tool == "LS":
+		return permission.Path(in.Path)
+	}
+	return in.URL
+}
+
+// grant returns the rule of the claude CLI that allows exactly the given use
+// of a tool. For Bash, that is the whole command line, which the policy has
+// allowed command by command.
+func grant(tool, subject string) string {
+	if subject == "" {
+		return tool
+	}
+	if tool != "Bash" {
+		if root, err := permission.Root(); err == nil && !filepath.IsAbs(subject) {
+			subject = filepath.Join(root, subject)
+		}
+		// The claude CLI writes absolute paths with two slashes.
+		subject = "/" + subject
+	}
+	return fmt.Sprintf("%s(%s)", tool, subject)
+}
+
+// permissionArgs returns the flags that pass the rules of the policy that
+// the claude CLI can check itself, and the tool uses that the user allowed in
+// the session. Claude denies every other tool use, and those that the policy
+// allows, or the user does when asked, are retried.
+//
+// Since claude doesn't ask, allowing more than the policy would let it skip
+// asking, so allow rules are only passed where no ask rule could override
+// them, and only if they don't allow more in claude's syntax. Deny rules are
+// passed when they don't deny less.
+func (s *session) permissionArgs() []string {
+	root, err := permission.Root()
+	if err != nil {
+		return nil
+	}
+	var allowed, denied []string
+	for _, r := range s.policy.Rules {
+		switch r.Decision {
+		case permission.Allow:
+			if !s.asks(r.Tool) {
+				if rule, ok := claudeRule(r, root, false); ok {
+					allowed = append(allowed, rule)
+				}
+			}
+		case permission.Deny:
+			if rule, ok := claudeRule(r, root, true); ok {
+				denied = append(denied, rule)
+			}
+		}
+	}
+	s.mu.Lock()
+	allowed = append(allowed, s.granted...)
+	s.mu.Unlock()
+
+	var args []string
+	if len(allowed) > 0 {
+		args = append(append(args, "--allowedTools"), allowed...)
+	}
+	if len(denied) > 0 {
+		args = append(append(args, "--disallowedTools"), denied...)
+	}
+	return args
+}
+
+// asks tells whether an ask rule of the policy could apply to the uses of
+// the tools that a rule for tool applies to.
+func (s *session) asks(tool string) bool {
+	for _, r := range s.policy.Rules {
+		if r.Decision == permission.Ask && (r.AppliesTo(tool) || (&permission.Rule{Tool: tool}).AppliesTo(r.Tool)) {
+			return true
+		}
+	}
+	return false
+}
+
+// claudeRule translates a rule of the policy to the syntax of the claude CLI,
+// in which Bash patterns are a command or a prefix, and paths are matched
+// like in .gitignore files. Rules that can't be translated exactly are made
+// broader if broaden is set, or otherwise not translated.
+func claudeRule(r *permission.Rule, root string, broaden bool) (string, bool) {
+	if r.Pattern == "" {
+		if fileTools[r.Tool] && !broaden {
+			// Paths outside of the workspace are matched by absolute
+			// patterns only.
+			return fmt.Sprintf("%s(/%s/**)", r.Tool, root), true
+		}
+		return r.Tool, true
+	}
+	pattern := strings.TrimSuffix(r.Pattern, ":*")
+	if pattern != r.Pattern {
+		pattern += "*"
+	}
+	n := strings.Count(pattern, "*")
+	switch {
+	case r.Tool == "Bash":
+		if n == 0 {
+			return r.String(), true
+		}
+		if broaden || (n == 1 && strings.HasSuffix(pattern, "*")) {
+			prefix := strings.TrimSpace(pattern[:strings.Index(pattern, "*")])
+			if prefix == "" {
+				return r.Tool, true
+			}
+			return fmt.Sprintf("Bash(%s:*)", prefix), true
+		}
+		return "", false
+	case fileTools[r.Tool]:
+		if filepath.IsAbs(pattern) {
+			if permission.Match(pattern, root) || permission.Match(pattern, root+"/") {
+				// Absolute patterns are for paths outside of the workspace,
+				// but in claude they would match the paths in it too.
+				return "", false
+			}
+		} else {
+			pattern = filepath.Join(root, pattern)
+		}
+		if n > 0 && !broaden && !(n == 1 && strings.HasSuffix(pattern, "*")) {
+			return "", false
+		}
+		return fmt.Sprintf("%s(/%s)", r.Tool, strings.ReplaceAll(pattern, "*", "**")), true
+	}
+	// Patterns of other tools, like WebFetch(domain:…), are written in
+	// claude's syntax.
+	return r.String(), true
+}
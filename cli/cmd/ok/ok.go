@@ -1,28 +1,83 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"ok.build/cli/agent"
 	"ok.build/cli/arg"
+	"ok.build/cli/bazelflags"
 	"ok.build/cli/bazelisk"
-	"ok.build/cli/claude"
+	"ok.build/cli/bazelrc"
+	"ok.build/cli/bep"
+	"ok.build/cli/bundle"
 	"ok.build/cli/command"
+	"ok.build/cli/config"
+	"ok.build/cli/diagnostic"
+	"ok.build/cli/execlog"
+	"ok.build/cli/fixer"
+	"ok.build/cli/flakes"
 	"ok.build/cli/help"
+	"ok.build/cli/history"
 	"ok.build/cli/log"
 	"ok.build/cli/picker"
+	"ok.build/cli/plugin"
 	"ok.build/cli/shortcuts"
+	"ok.build/cli/testlog"
+	"ok.build/cli/testsummary"
+	"ok.build/cli/textarea"
+	"ok.build/cli/workspace"
 
 	"ok.build/cli/command/register"
 )
 
+const (
+	// maxPickerDiagnostics is how many errors are listed above the error
+	// picker.
+	maxPickerDiagnostics = 5
+
+	// fixPrefix prefixes the picker values of deterministic fixes, followed
+	// by the fix's index. applyAllFixes applies every fix.
+	fixPrefix     = "fix:"
+	applyAllFixes = "fix:all"
+
+	// pluginActionPrefix prefixes the picker values of options added by
+	// plugins, followed by the option's index.
+	pluginActionPrefix = "plugin:"
+
+	// testCasePrefix prefixes the picker values of failing test cases,
+	// followed by the case's index. At most maxPickerTestCases are listed.
+	testCasePrefix     = "case:"
+	maxPickerTestCases = 10
+
+	// markFlakyPrefix prefixes the picker values of the options that mark a
+	// flaky test as flaky, followed by the test's index. openFlakeReport
+	// opens the report of the flake detection.
+	markFlakyPrefix = "flaky:"
+	openFlakeReport = "flaky:report"
+
+	// continuePrompt is sent when the user continues an agent session
+	// without saying what to do next.
+	continuePrompt = "The command still fails. Continue fixing it."
+)
+
 var (
-	// These flags configure the cli at large, and don't apply to any specific
-	// cli command
-	globalCliFlags = map[string]struct{}{
-		// Set to print verbose cli logs
-		"verbose": {},
-	}
+	// buildEventCommands are the bazel commands that ok asks to write a build
+	// event file.
+	buildEventCommands = []string{"build", "coverage", "run", "test"}
+
+	// fixMode is set by the --fix flag, or else the fix.mode config key.
+	fixMode string
+
+	// detectFlakes is how many times failed tests are rerun to detect flaky
+	// tests, set by the --detect-flakes flag or the test.detect_flakes config
+	// key. Zero disables flake detection.
+	detectFlakes int
 )
 
 func main() {
@@ -38,16 +93,57 @@ func run() (exitCode int, err error) {
 	// Record original arguments so we can show them in the UI.
 	originalArgs := append([]string{}, os.Args...)
 
+	// Load the user and workspace config before anything else, since every
+	// subsystem below reads its settings from it. A broken config file
+	// mustn't keep ok from running, least of all `ok config set`, which can
+	// repair it.
+	if err := config.Load(); err != nil {
+		log.Warnf("Ignoring config: %s", err)
+	}
+
+	// Let the arg helpers fetch bazel's option schema when they come across
+	// an option they don't know.
+	arg.LoadBazelSchema = bazelflags.Load
+
+	// Let `ok fix` offer fixes for earlier failures the same way as for
+	// commands that just failed.
+	history.Fix = fixEntry
+
+	// Declare global flags so that "--verbose" isn't mistaken for an option
+	// that takes the next arg as its value, and "--fix auto" is.
+	for _, flag := range command.GlobalFlags {
+		arg.RegisterOption(&arg.Option{Name: flag.Name, HasNegative: flag.Value == "", RequiresValue: flag.Value != ""})
+	}
+
+	// Register all known cli commands so that we can query or iterate them later.
+	register.Register()
+
+	// Hidden commands, like the ones that the completion scripts call, get
+	// their args as they were typed, before the global flags, default
+	// command, aliases and help are handled below.
+	if len(os.Args) > 1 {
+		if c := command.GetCommand(os.Args[1]); c != nil && c.Hidden {
+			return c.Run(os.Args[2:])
+		}
+	}
+
 	args := handleGlobalCliFlags(os.Args[1:])
 
 	log.Debugf("CLI started at %s", start)
 	log.Debugf("args[0]: %s", os.Args[0])
 
-	// Register all known cli commands so that we can query or iterate them later.
-	register.Register()
+	// Run the configured default command if no command was given.
+	args, err = handleDefaultCommand(args)
+	if err != nil {
+		return 1, err
+	}
 
-	// Expand command shortcuts like b=>build, t=>test, etc.
-	args = shortcuts.HandleShortcuts(args)
+	// Expand command shortcuts like b=>build, t=>test, etc. and user-defined
+	// aliases.
+	args, err = shortcuts.HandleShortcuts(args)
+	if err != nil {
+		return 1, err
+	}
 
 	// Handle help command if applicable.
 	exitCode, err = help.HandleHelp(args)
@@ -58,7 +154,7 @@ func run() (exitCode int, err error) {
 	if c := command.GetCommand(args[0]); c != nil {
 		// If the first argument is a cli command, trim it from `args`
 		args = args[1:]
-		return c.Handler(args)
+		return c.Run(args)
 	}
 
 	// If none of the CLI subcommand handlers were triggered, assume we should
@@ -71,20 +167,76 @@ func run() (exitCode int, err error) {
 // Returns args with all global cli flags removed
 func handleGlobalCliFlags(args []string) []string {
 	args, residual := arg.SplitExecutableArgs(args)
-	for flag := range globalCliFlags {
+	for _, flag := range command.GlobalFlags {
 		var flagVal string
-		flagVal, args = arg.Pop(args, flag)
+		flagVal, args = arg.Pop(args, flag.Name)
 
 		// Even if flag is not set and flagVal is "", pass to handlers in case
 		// they need to configure a default value
-		switch flag {
+		switch flag.Name {
 		case "verbose":
+			if flagVal == "" {
+				flagVal = config.Get("cli.verbose")
+			}
 			log.Configure(flagVal)
+		case "fix":
+			if flagVal == "" {
+				flagVal = config.Get("fix.mode")
+			}
+			fixMode = flagVal
+		case "detect-flakes":
+			if flagVal == "" {
+				flagVal = config.Get("test.detect_flakes")
+			}
+			if flagVal == "" {
+				continue
+			}
+			runs, err := strconv.Atoi(flagVal)
+			if err != nil || runs < 1 {
+				log.Warnf("Invalid --detect-flakes value %q, expected a number of runs", flagVal)
+				continue
+			}
+			detectFlakes = runs
 		}
 	}
 	return arg.JoinExecutableArgs(args, residual)
 }
 
+// handleDefaultCommand appends the command line from the `default.command`
+// config key to args if args don't contain a command (e.g. a bare `ok`).
+// The placeholder {package} is replaced with the label of the package in the
+// current directory, so `test {package}/...` tests the current package.
+//
+// Passing -h or --help skips the default command so that help is shown.
+func handleDefaultCommand(args []string) ([]string, error) {
+	defaultCommand := config.Get("default.command")
+	if defaultCommand == "" || arg.GetCommand(args) != "" {
+		return args, nil
+	}
+	if arg.ContainsExact(args, "-h") || arg.ContainsExact(args, "--help") {
+		return args, nil
+	}
+	if strings.Contains(defaultCommand, "{package}") {
+		pkg, err := workspace.CurrentPackage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand {package} in default.command: %s", err)
+		}
+		if pkg == "//" {
+			// The root package is "//", but its subpackages are "//...",
+			// and "//" alone isn't a label, so the package's targets are
+			// "//:all".
+			defaultCommand = strings.NewReplacer("{package}/", "//", "{package}:", "//:", "{package}", "//:all").Replace(defaultCommand)
+		}
+		defaultCommand = strings.ReplaceAll(defaultCommand, "{package}", pkg)
+	}
+	words, err := arg.SplitShell(defaultCommand)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default.command: %s", err)
+	}
+	log.Debugf("Running default command: %s", defaultCommand)
+	return append(args, words...), nil
+}
+
 // handleBazelCommand handles a native bazel command (i.e. commands that are
 // directly forwarded to bazel, as opposed to bb cli-specific commands)
 //
@@ -92,49 +244,396 @@ func handleGlobalCliFlags(args []string) []string {
 // EXPLICIT_COMMAND_LINE metadata to the bazel invocation.
 func handleBazelCommand(start time.Time, args []string, originalArgs []string) (int, error) {
 
-	//Prepare a dir for temporary files created by this CLI run
-	tempDir, err := os.MkdirTemp("", "buildbuddy-cli-*")
+	// Files created by this CLI run, like bazel's output and build events,
+	// are kept in the command's history entry, so that the command can be
+	// rerun or fixed later.
+	entry, err := history.New(start, originalArgs[1:])
+	if err != nil {
+		return 1, err
+	}
+	defer entry.Close()
+	tempDir := entry.Dir
+
+	plugins := plugin.Load()
+	args, err = plugin.RunPreBazel(plugins, args, tempDir)
 	if err != nil {
 		return 1, err
 	}
-	defer func() {
-		os.RemoveAll(tempDir)
-	}()
 
 	logFileName := tempDir + "/bazel.log"
+	// Bazel runs with ok's own flags, which the commands that ok prints for
+	// the user to run, or runs again itself, must leave out: a rerun would
+	// overwrite the files that they name.
+	runArgs, execLogDir := execlog.AddFlag(args)
+	runArgs, buildEventsFileName := addBuildEventsFlag(runArgs, tempDir)
+	entry.BazelArgs, entry.Log, entry.BuildEvents = args, logFileName, buildEventsFileName
 
-	exitCode, err := bazelisk.RunWithLogFile(args, logFileName)
+	pluginOutput, waitForPlugins := plugin.StartOutputHandlers(plugins)
+	exitCode, err := bazelisk.RunWithLogFile(runArgs, logFileName, pluginOutput)
+	waitForPlugins()
+	execlog.Finish(execLogDir)
 
 	if err != nil {
 		return 1, err
 	}
 
+	var invocation *bep.Invocation
+	if buildEventsFileName != "" {
+		invocation, err = bep.ReadFile(buildEventsFileName)
+		if err != nil {
+			log.Debugf("Failed to read build events: %s", err)
+		}
+	}
+
+	entry.Finish(exitCode, invocation)
+
+	if arg.GetCommand(args) == "test" && invocation != nil && config.GetBool("test.summary", true) {
+		testsummary.Write(os.Stderr, invocation, args)
+	}
+
+	actions := plugin.RunPostBazel(plugins, &plugin.Result{
+		ExitCode:        exitCode,
+		LogPath:         logFileName,
+		BuildEventsPath: buildEventsFileName,
+	}, tempDir)
+
 	if exitCode != 0 {
-		response, err := showErrorPicker()
+		return handleFailure(entry, invocation, actions)
+	}
+	return exitCode, nil
+}
+
+// fixEntry offers to fix the failure of a command from the history, for
+// `ok fix`. If an agent already worked on the failure, it offers to continue
+// that session instead.
+func fixEntry(entry *history.Entry) (int, error) {
+	if resumed, err := continueSession(entry); resumed || err != nil {
 		if err != nil {
 			return 1, err
 		}
+		return entry.ExitCode, nil
+	}
+	invocation, err := entry.ReadBuildEvents()
+	if err != nil {
+		log.Debugf("Failed to read build events: %s", err)
+	}
+	return handleFailure(entry, invocation, nil)
+}
 
-		if response == "y" || response == "i" {
-			outputFile, err := os.Open(logFileName)
+// handleFailure offers to fix the failure of a bazel command, whose output
+// and build events are in its history entry. Fixes include those that
+// plugins offer as actions.
+func handleFailure(entry *history.Entry, invocation *bep.Invocation, actions []*plugin.Action) (int, error) {
+	args, exitCode, tempDir := entry.BazelArgs, entry.ExitCode, entry.Dir
+	output, err := os.ReadFile(entry.Log)
+	if err != nil {
+		return 1, err
+	}
+	diagnostics := diagnostic.Parse(string(output))
+	var testCases []*testlog.Case
+	var flaky []*flakes.Result
+	flakeReport := ""
+	if arg.GetCommand(args) == "test" {
+		testInvocation := invocation
+		if labels := flakes.FailedTests(invocation, diagnostics); detectFlakes > 0 && len(labels) > 0 {
+			results, rerun, err := flakes.Detect(args, labels, detectFlakes, tempDir)
 			if err != nil {
 				return 1, err
 			}
-			defer outputFile.Close()
+			fmt.Fprint(os.Stderr, flakes.Summary(results))
+			if flakeReport, err = flakes.WriteReport(args, results); err != nil {
+				log.Warnf("Failed to write the flake report: %s", err)
+			}
+			// Only consistent failures are worth fixing; the latest
+			// test logs are the rerun's.
+			diagnostics, flaky = withoutFlakes(diagnostics, results)
+			testInvocation = rerun
+		}
+		for _, c := range testlog.Failed(testInvocation, diagnostics) {
+			if !slices.ContainsFunc(flaky, func(r *flakes.Result) bool { return r.Label == c.Target }) {
+				testCases = append(testCases, c)
+			}
+		}
+	}
+	var fixes []*fixer.Fix
+	if fixMode != "never" {
+		fixes = fixer.Propose(diagnostics)
+	}
 
-			claude.Run(outputFile, []string{}, response == "i")
+	response, err := showErrorPicker(diagnostics, fixes, testCases, flaky, flakeReport != "", actions)
+	if err != nil {
+		return 1, err
+	}
+
+	if response == applyAllFixes {
+		for _, fix := range fixes {
+			if err := applyFix(fix); err != nil {
+				return 1, err
+			}
+		}
+		return exitCode, nil
+	}
+	if i, ok := strings.CutPrefix(response, fixPrefix); ok {
+		n, _ := strconv.Atoi(i)
+		if err := applyFix(fixes[n]); err != nil {
+			return 1, err
+		}
+		return exitCode, nil
+	}
+
+	if response == openFlakeReport {
+		if err := flakes.OpenReport(flakeReport); err != nil {
+			return 1, err
+		}
+		return exitCode, nil
+	}
+	if i, ok := strings.CutPrefix(response, markFlakyPrefix); ok {
+		n, _ := strconv.Atoi(i)
+		if err := applyFix(fixer.MarkFlaky(flaky[n].Label)); err != nil {
+			return 1, err
+		}
+		return exitCode, nil
+	}
+
+	if i, ok := strings.CutPrefix(response, pluginActionPrefix); ok {
+		n, _ := strconv.Atoi(i)
+		if _, err := actions[n].Run(); err != nil {
+			return 1, err
+		}
+		return exitCode, nil
+	}
+
+	if i, ok := strings.CutPrefix(response, testCasePrefix); ok {
+		n, _ := strconv.Atoi(i)
+		c := testCases[n]
+		how, err := picker.ShowPicker(fmt.Sprintf("How do you want to fix %s?", c.Title()), []picker.Option{
+			{Label: "Fix it for me automatically", Value: "y"},
+			{Label: "Let's fix it together interactively", Value: "i"},
+		})
+		if err != nil {
+			return 1, err
+		}
+		if err := runAgent(entry, bundle.BuildTestCase(args, c), testCasePrompt(args, c), how == "i"); err != nil {
+			return 1, err
+		}
+		return exitCode, nil
+	}
+
+	if response == "y" || response == "i" {
+		// Give the agent the errors and their context rather than the raw
+		// output, so that it doesn't have to go looking for them.
+		b := bundle.Build(&bundle.Failure{
+			Args:        args,
+			Invocation:  invocation,
+			Diagnostics: diagnostics,
+			TestCases:   testCases,
+			Output:      diagnostic.Strip(string(output)),
+		})
+		if err := runAgent(entry, b, failurePrompt(args), response == "i"); err != nil {
+			return 1, err
 		}
 	}
 
 	return exitCode, nil
 }
 
-func showErrorPicker() (string, error) {
-	options := []picker.Option{
-		{Label: "Yes, fix it for me automatically", Value: "y"},
-		{Label: "Yes, let's fix it together interactively", Value: "i"},
-		{Label: "No, I'll fix it myself", Value: "n"},
+// withoutFlakes returns the diagnostics that aren't about flaky or
+// timeout-sensitive tests, and the results of those tests.
+func withoutFlakes(diagnostics []*diagnostic.Diagnostic, results []*flakes.Result) ([]*diagnostic.Diagnostic, []*flakes.Result) {
+	var flaky []*flakes.Result
+	for _, r := range results {
+		if r.Class != flakes.Consistent {
+			flaky = append(flaky, r)
+		}
+	}
+	var out []*diagnostic.Diagnostic
+	for _, d := range diagnostics {
+		if !slices.ContainsFunc(flaky, func(r *flakes.Result) bool { return r.Label == d.Label }) {
+			out = append(out, d)
+		}
+	}
+	return out, flaky
+}
+
+// runAgent asks the agent to fix a failure, passing it the bundle's context,
+// and records the agent's session in the failed command's history entry.
+func runAgent(entry *history.Entry, b *bundle.Bundle, prompt string, interactive bool) error {
+	contextFileName := entry.Dir + "/context.md"
+	if err := b.WriteFile(contextFileName); err != nil {
+		return err
+	}
+	contextFile, err := os.Open(contextFileName)
+	if err != nil {
+		return err
+	}
+	defer contextFile.Close()
+
+	a, err := agent.New()
+	if err != nil {
+		return err
 	}
+	sessionID, err := agent.Run(a, &agent.Request{Prompt: prompt, Context: contextFile, Interactive: interactive})
+	if sessionID != "" {
+		entry.Agent, entry.SessionID = a.Name(), sessionID
+		if err := entry.Save(); err != nil {
+			log.Debugf("Failed to save history entry: %s", err)
+		}
+	}
+	return err
+}
 
-	return picker.ShowPicker("Want help fixing this error?", options)
+// continueSession offers to continue the agent session recorded in the
+// entry, if the current provider started it. It returns whether the session
+// was continued.
+func continueSession(entry *history.Entry) (bool, error) {
+	if entry.SessionID == "" {
+		return false, nil
+	}
+	a, err := agent.New()
+	if err != nil || a.Name() != entry.Agent {
+		return false, nil
+	}
+	how, err := picker.ShowPicker("An agent already worked on this failure.", []picker.Option{
+		{Label: "Continue its session", Value: "continue"},
+		{Label: "Start over", Value: "new"},
+	})
+	if err != nil || how != "continue" {
+		return false, err
+	}
+	message, err := textarea.ShowTextarea("What should the agent do next?", "Type here... For example: "+continuePrompt)
+	if err != nil {
+		return false, err
+	}
+	if message == "" {
+		message = continuePrompt
+	}
+	sessionID, err := agent.Continue(a, entry.SessionID, message)
+	if sessionID != "" && sessionID != entry.SessionID {
+		entry.SessionID = sessionID
+		if err := entry.Save(); err != nil {
+			log.Debugf("Failed to save history entry: %s", err)
+		}
+	}
+	return true, err
+}
+
+// addBuildEventsFlag asks bazel to write build events to a JSON file in
+// tempDir, unless the args or the bazelrc files already name a build event
+// JSON file, which is read instead. It returns the updated args and the path
+// of the file, which is empty if the command doesn't produce build events.
+func addBuildEventsFlag(args []string, tempDir string) ([]string, string) {
+	command, idx := arg.GetCommandAndIndex(args)
+	if !slices.Contains(buildEventCommands, command) {
+		return args, ""
+	}
+	flags := arg.GetBazelArgs(args)
+	if inv, err := bazelrc.Expand(args); err == nil {
+		flags = nil
+		for _, f := range inv.Args {
+			flags = append(flags, f.Value)
+		}
+	} else {
+		log.Debugf("Failed to expand bazelrc flags: %s", err)
+	}
+	if path, i, _ := arg.FindLast(flags, "build_event_json_file"); i >= 0 && path != "" {
+		return args, path
+	}
+	path := filepath.Join(tempDir, "build_events.json")
+	return slices.Insert(args, idx+1, "--build_event_json_file="+path), path
+}
+
+// applyFix applies a deterministic fix and tells the user about it.
+func applyFix(fix *fixer.Fix) error {
+	if err := fix.Apply(); err != nil {
+		return fmt.Errorf("failed to %s: %s", strings.ToLower(fix.Description[:1])+fix.Description[1:], err)
+	}
+	fmt.Fprintf(os.Stderr, "Done: %s\n", fix.Description)
+	return nil
+}
+
+// showErrorPicker asks the user how to proceed after a failed bazel command.
+// The fix mode can be set to "auto", "interactive" or "never" to skip the
+// picker and always make the same choice. In "auto" mode, deterministic
+// fixes are applied if there are any, and the agent is only asked to fix the
+// error otherwise.
+//
+// Deterministic fixes are listed first, followed by the failing test cases,
+// so that one can be fixed on its own. Tests found to be flaky aren't offered
+// to the agent, but can be marked as flaky instead. Plugins may add their own
+// options, which are only shown by the picker.
+func showErrorPicker(diagnostics []*diagnostic.Diagnostic, fixes []*fixer.Fix, testCases []*testlog.Case, flaky []*flakes.Result, hasFlakeReport bool, actions []*plugin.Action) (string, error) {
+	// If every failure was flaky, there's nothing for the agent to fix.
+	offerAgent := len(flaky) == 0 || len(diagnostics) > 0 || len(testCases) > 0
+	switch mode := fixMode; mode {
+	case "auto":
+		if len(fixes) > 0 {
+			return applyAllFixes, nil
+		}
+		if !offerAgent {
+			return "n", nil
+		}
+		return "y", nil
+	case "interactive":
+		if !offerAgent {
+			return "n", nil
+		}
+		return "i", nil
+	case "never":
+		return "n", nil
+	case "", "ask":
+	default:
+		log.Warnf("Unknown fix mode %q, expected one of ask, auto, interactive, never", mode)
+	}
+
+	var options []picker.Option
+	for i, fix := range fixes {
+		options = append(options, picker.Option{Label: fix.Description, Value: fmt.Sprintf("%s%d", fixPrefix, i)})
+	}
+	for i, c := range testCases[:min(len(testCases), maxPickerTestCases)] {
+		options = append(options, picker.Option{Label: "Fix " + c.Title(), Value: fmt.Sprintf("%s%d", testCasePrefix, i)})
+	}
+	for i, r := range flaky {
+		options = append(options, picker.Option{Label: fmt.Sprintf("Mark %s as flaky (%s)", r.Label, strings.TrimPrefix(r.String(), r.Label+" is ")), Value: fmt.Sprintf("%s%d", markFlakyPrefix, i)})
+	}
+	if hasFlakeReport {
+		options = append(options, picker.Option{Label: "Open the flake report", Value: openFlakeReport})
+	}
+	if offerAgent {
+		options = append(options,
+			picker.Option{Label: "Yes, fix it for me automatically", Value: "y"},
+			picker.Option{Label: "Yes, let's fix it together interactively", Value: "i"},
+		)
+	}
+	for i, a := range actions {
+		options = append(options, picker.Option{Label: a.Label, Value: fmt.Sprintf("%s%d", pluginActionPrefix, i)})
+	}
+	options = append(options, picker.Option{Label: "No, I'll fix it myself", Value: "n"})
+
+	prompt := "Want help fixing this error?"
+	switch len(diagnostics) {
+	case 0:
+	case 1:
+		prompt = fmt.Sprintf("Bazel reported an error:\n%s\n%s", diagnostic.Summarize(diagnostics, maxPickerDiagnostics), prompt)
+	default:
+		prompt = fmt.Sprintf("Bazel reported %d errors:\n%s\nWant help fixing them?", len(diagnostics), diagnostic.Summarize(diagnostics, maxPickerDiagnostics))
+	}
+	if !offerAgent {
+		prompt = "The failed tests are flaky. What do you want to do?"
+	}
+	return picker.ShowPicker(prompt, options)
+}
+
+// failurePrompt describes the failed bazel command to the agent. The errors
+// and their context are passed to the agent separately.
+func failurePrompt(args []string) string {
+	return fmt.Sprintf("This bazel command failed: bazel %s\nThe errors it reported are attached, along with the context needed to fix them: source lines, BUILD files, flags from .bazelrc files, uncommitted changes and the end of bazel's output.", strings.Join(args, " "))
+}
+
+// testCasePrompt asks the agent to fix one failing test case.
+func testCasePrompt(args []string, c *testlog.Case) string {
+	name := c.Target
+	if c.Name != "" {
+		name = fmt.Sprintf("the test case %s of %s", c.FullName(), c.Target)
+	}
+	return fmt.Sprintf("This bazel command failed: bazel %s\nFix %s. Its failure is attached, along with the test's source, an excerpt of its log and the code under test. Decide whether the test or the code under test is wrong before changing either, and check the fix with `bazel test %s`.", strings.Join(args, " "), name, c.Target)
 }
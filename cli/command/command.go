@@ -1,12 +1,75 @@
 package command
 
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
 type Command struct {
-	Name    string
-	Help    string
+	Name string
+	Help string
+
+	// Description is the long description shown by `ok help <command>` and
+	// `ok <command> --help`, after the one-line Help.
+	Description string
+
+	// Flags declares the typed flags accepted by the command. If set, flags
+	// are parsed (and may be interspersed with positional args) before Handler
+	// is called, and Handler only receives the positional args.
+	//
+	// If Flags and Args are both nil, Handler receives the args unmodified.
+	Flags *flag.FlagSet
+
+	// Args declares the positional args accepted by the command. If Flags or
+	// Args is set, the number of positional args is validated against it.
+	Args []Arg
+
+	// StopAtFirstArg stops flag parsing at the first positional arg, or the
+	// first flag that the command doesn't define, so that it and every arg
+	// after it are passed to Handler as-is. This is meant for commands that
+	// take a bazel command line, like `ok flags --bazelrc=ci.rc build -c opt`.
+	StopAtFirstArg bool
+
+	// Hidden commands are not listed by `ok help` or shell completion. They
+	// are meant to be called by ok itself, e.g. by the completion scripts.
+	Hidden bool
+
+	// External is the path of the executable that implements the command, for
+	// external commands found on PATH. It is empty for built-in commands.
+	External string
+
 	Handler func(args []string) (exitCode int, err error)
 	Aliases []string
 }
 
+// Arg describes a positional argument of a Command.
+type Arg struct {
+	Name string
+	Help string
+
+	// Optional args may be omitted. Only trailing args may be optional.
+	Optional bool
+
+	// Repeated args accept any number of values. Only the last arg may be
+	// repeated.
+	Repeated bool
+}
+
+// GlobalFlag is a flag that configures the cli at large and doesn't apply to
+// any specific command. Global flags may appear anywhere on the command line.
+type GlobalFlag struct {
+	Name string
+
+	// Value names the flag's value in help output, for flags that take one.
+	// Flags without a Value are booleans.
+	Value string
+
+	Help string
+}
+
 var (
 	// Commands is a slice of all known CLI commands, sorted by their Name fields.
 	//
@@ -23,10 +86,26 @@ var (
 	//
 	// It is nil until Register in command/register is called.
 	Aliases map[string]*Command
+
+	// GlobalFlags lists every global cli flag.
+	GlobalFlags = []*GlobalFlag{
+		{Name: "verbose", Help: "Print verbose cli logs. Can also be set with the cli.verbose config key."},
+		{Name: "fix", Value: "mode", Help: "What to do when a bazel command fails: ask, auto, interactive or never. Can also be set with the fix.mode config key."},
+		{Name: "detect-flakes", Value: "runs", Help: "When tests fail, rerun them this many times to tell flaky tests from consistent failures. Can also be set with the test.detect_flakes config key."},
+	}
 )
 
+// Usage returns the flag as it's shown in help output, like "--fix=<mode>".
+func (f *GlobalFlag) Usage() string {
+	if f.Value == "" {
+		return "--" + f.Name
+	}
+	return fmt.Sprintf("--%s=<%s>", f.Name, f.Value)
+}
+
 // GetCommand returns the Command corresponding to the provided command name or
-// alias, or nil if no such Command exists.
+// alias, or nil if no such Command exists. If there is no built-in command
+// with that name, an external `ok-<name>` command is looked up.
 func GetCommand(commandName string) *Command {
 	if command, ok := CommandsByName[commandName]; ok {
 		return command
@@ -34,5 +113,182 @@ func GetCommand(commandName string) *Command {
 	if command, ok := Aliases[commandName]; ok {
 		return command
 	}
-	return nil
+	return GetExternalCommand(commandName)
+}
+
+// Run parses and validates args against the command's Flags and Args, then
+// calls its Handler. If args contain -h or --help, the command's help is
+// printed instead. External and hidden commands receive their args as-is,
+// including any -h or --help.
+func (c *Command) Run(args []string) (exitCode int, err error) {
+	if c.External != "" || c.Hidden {
+		return c.Handler(args)
+	}
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		if a == "-h" || a == "--help" {
+			c.WriteHelp(os.Stdout)
+			return 0, nil
+		}
+		if c.StopAtFirstArg && !c.isFlag(a) {
+			break
+		}
+	}
+	if c.Flags == nil && c.Args == nil {
+		return c.Handler(args)
+	}
+	positional, err := c.parse(args)
+	if err != nil {
+		return 1, fmt.Errorf("%s\n\n%s\nRun 'ok help %s' for more information", err, c.usageLine(), c.Name)
+	}
+	return c.Handler(positional)
+}
+
+// parse parses flags out of args, allowing them to be interspersed with
+// positional args, and returns the positional args.
+func (c *Command) parse(args []string) ([]string, error) {
+	var positional, commandLine []string
+	if c.StopAtFirstArg {
+		for i := 0; i < len(args); i++ {
+			if !c.isFlag(args[i]) {
+				args, commandLine = args[:i], args[i:]
+				break
+			}
+			// Skip the value of a flag like `--output json`.
+			if f := c.Flags.Lookup(flagName(args[i])); f != nil && !isBoolFlag(f) && !strings.Contains(args[i], "=") {
+				i++
+			}
+		}
+	}
+	if c.Flags != nil {
+		c.Flags.SetOutput(io.Discard)
+		for len(args) > 0 {
+			if err := c.Flags.Parse(args); err != nil {
+				return nil, err
+			}
+			rest := c.Flags.Args()
+			// Parse stops after consuming a "--" terminator; everything after
+			// it is positional.
+			if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+				positional = append(positional, rest...)
+				break
+			}
+			if len(rest) == 0 {
+				break
+			}
+			positional = append(positional, rest[0])
+			args = rest[1:]
+		}
+	} else {
+		positional = args
+	}
+	positional = append(positional, commandLine...)
+
+	min, max := 0, len(c.Args)
+	for _, a := range c.Args {
+		if !a.Optional && !a.Repeated {
+			min++
+		}
+		if a.Repeated {
+			if !a.Optional {
+				min++
+			}
+			max = -1
+		}
+	}
+	if len(positional) < min {
+		return nil, fmt.Errorf("missing argument <%s>", c.Args[len(positional)].Name)
+	}
+	if max >= 0 && len(positional) > max {
+		return nil, fmt.Errorf("unexpected argument %q", positional[max])
+	}
+	return positional, nil
+}
+
+// isFlag tells whether a is one of the command's flags.
+func (c *Command) isFlag(a string) bool {
+	return strings.HasPrefix(a, "-") && a != "--" && c.Flags != nil && c.Flags.Lookup(flagName(a)) != nil
+}
+
+// flagName returns the name of the flag in a, like "explain" in --explain.
+func flagName(a string) string {
+	name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+	return name
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func (c *Command) usageLine() string {
+	usage := "usage: ok " + c.Name
+	if c.Flags != nil {
+		usage += " [options]"
+	}
+	for _, a := range c.Args {
+		name := "<" + a.Name + ">"
+		if a.Repeated {
+			name += "..."
+		}
+		if a.Optional {
+			name = "[" + name + "]"
+		}
+		usage += " " + name
+	}
+	return usage
+}
+
+// WriteHelp writes the command's help page, generated from its declaration.
+func (c *Command) WriteHelp(w io.Writer) {
+	fmt.Fprintf(w, "%s\n\n%s\n", c.usageLine(), c.Help)
+	if c.Description != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(c.Description))
+	}
+	if len(c.Aliases) > 0 {
+		fmt.Fprintf(w, "\naliases: %s\n", strings.Join(c.Aliases, ", "))
+	}
+	if len(c.Args) > 0 {
+		fmt.Fprintf(w, "\narguments:\n")
+		for _, a := range c.Args {
+			fmt.Fprintf(w, "  <%s>\n", a.Name)
+			if a.Help != "" {
+				fmt.Fprintf(w, "    %s\n", a.Help)
+			}
+		}
+	}
+	if c.Flags != nil {
+		fmt.Fprintf(w, "\noptions:\n")
+		c.Flags.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "  %s\n", FormatFlag(f))
+		})
+	}
+	if len(GlobalFlags) > 0 {
+		fmt.Fprintf(w, "\nglobal options:\n")
+		for _, f := range GlobalFlags {
+			fmt.Fprintf(w, "  %s\n    %s\n", f.Usage(), f.Help)
+		}
+	}
+}
+
+// FormatFlag formats a flag and its usage for display in help output, like
+//
+//	--name (a string; default: "value")
+//	  Usage text.
+func FormatFlag(f *flag.Flag) string {
+	typeName, usage := flag.UnquoteUsage(f)
+	if typeName == "" {
+		typeName = "boolean"
+	}
+	s := fmt.Sprintf("--%s (%s", f.Name, typeName)
+	if f.DefValue != "" {
+		s += fmt.Sprintf("; default: %q", f.DefValue)
+	}
+	s += ")"
+	if usage != "" {
+		s += "\n    " + usage
+	}
+	return s
 }
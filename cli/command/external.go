@@ -0,0 +1,147 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"ok.build/cli/config"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// externalPrefix is the prefix of external command executables, e.g.
+	// `ok foo` runs an executable named `ok-foo`.
+	externalPrefix = "ok-"
+
+	// workspaceToolsDir is the workspace directory that is searched for
+	// external commands before PATH.
+	workspaceToolsDir = "tools/ok"
+)
+
+// BazelCommands lists bazel's own commands. External commands can't shadow
+// these.
+var BazelCommands = []string{
+	"analyze-profile", "aquery", "build", "canonicalize-flags", "clean",
+	"config", "coverage", "cquery", "dump", "fetch", "help", "info",
+	"license", "mobile-install", "mod", "print_action", "query", "run",
+	"shutdown", "sync", "test", "vendor", "version",
+}
+
+// GetExternalCommand returns a Command that runs the `ok-<name>` executable
+// from the workspace's tools/ok directory or from PATH, or nil if there is no
+// such executable.
+func GetExternalCommand(name string) *Command {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsRune(name, filepath.Separator) || slices.Contains(BazelCommands, name) {
+		return nil
+	}
+	for _, dir := range externalDirs() {
+		path := filepath.Join(dir, externalPrefix+name)
+		if isExecutable(path) {
+			return externalCommand(name, path)
+		}
+	}
+	return nil
+}
+
+// ExternalCommands returns every external command that can be found, sorted by
+// name. Commands in the workspace shadow commands with the same name on PATH.
+func ExternalCommands() []*Command {
+	var out []*Command
+	seen := map[string]struct{}{}
+	for _, dir := range externalDirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), externalPrefix)
+			if !ok || name == "" || slices.Contains(BazelCommands, name) || CommandsByName[name] != nil {
+				continue
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if !isExecutable(path) {
+				continue
+			}
+			seen[name] = struct{}{}
+			out = append(out, externalCommand(name, path))
+		}
+	}
+	slices.SortFunc(out, func(a, b *Command) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+func externalDirs() []string {
+	var dirs []string
+	if ws, err := workspace.Path(); err == nil {
+		dirs = append(dirs, filepath.Join(ws, workspaceToolsDir))
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	return runtime.GOOS == "windows" || fi.Mode()&0111 != 0
+}
+
+func externalCommand(name, path string) *Command {
+	return &Command{
+		Name:     name,
+		Help:     fmt.Sprintf("External command (%s).", path),
+		External: path,
+		Handler: func(args []string) (int, error) {
+			return runExternal(path, args)
+		},
+	}
+}
+
+// ExternalEnv returns the environment for external commands and plugins: the
+// current environment, plus the workspace root in $OK_WORKSPACE, ok's
+// effective config in $OK_CONFIG (as `key=value` lines), and the path of ok
+// itself in $OK_BINARY.
+func ExternalEnv() []string {
+	env := os.Environ()
+	if ws, err := workspace.Path(); err == nil {
+		env = append(env, "OK_WORKSPACE="+ws)
+	}
+	if self, err := os.Executable(); err == nil {
+		env = append(env, "OK_BINARY="+self)
+	}
+	return append(env, "OK_CONFIG="+config.Serialize())
+}
+
+// runExternal runs an external command with the given args, in the
+// environment returned by ExternalEnv.
+func runExternal(path string, args []string) (int, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = ExternalEnv()
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
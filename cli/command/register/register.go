@@ -3,14 +3,26 @@ package register
 import (
 	"sync"
 
+	"ok.build/cli/agent"
+	"ok.build/cli/agent/anthropic"
+	"ok.build/cli/agent/fake"
+	"ok.build/cli/agent/openai"
+	"ok.build/cli/bazelrc"
+	"ok.build/cli/checkpoint"
+	"ok.build/cli/claude"
 	"ok.build/cli/command"
+	"ok.build/cli/completion"
+	"ok.build/cli/config"
+	"ok.build/cli/execlog"
+	"ok.build/cli/history"
 	"ok.build/cli/please"
+	"ok.build/cli/profile"
 	"ok.build/cli/version"
 )
 
 // Register registers all known cli commands in the structures laid out in
-// cli/command. It is meant to be called immediately on CLI
-// startup.
+// cli/command, and the agent providers in cli/agent. It is meant to be called
+// immediately on CLI startup.
 //
 // This indirection prevents dependency cycles from occurring when, for example,
 // an imported package tries to use the parser, which itself needs to know all
@@ -18,16 +30,172 @@ import (
 var Register = sync.OnceFunc(register)
 
 func register() {
+	agent.Providers = map[string]func() (agent.Agent, error){
+		"anthropic": anthropic.New,
+		"claude":    claude.New,
+		"fake":      fake.FromConfig,
+		"openai":    openai.New,
+	}
+
 	command.Commands = []*command.Command{
 		{
-			Name:    "please",
-			Help:    "Asks ok to perform a task.",
+			Name:    "__complete",
+			Help:    "Prints shell completion candidates.",
+			Hidden:  true,
+			Handler: completion.HandleComplete,
+		},
+		{
+			Name:    completion.RefreshCommandName,
+			Help:    "Refreshes the target label cache used by shell completion.",
+			Hidden:  true,
+			Handler: completion.HandleRefresh,
+		},
+		{
+			Name: "completion",
+			Help: "Prints a shell completion script.",
+			Description: `
+Prints a script that completes ok commands, aliases, bazel commands and
+options, --config values and target labels. To enable it, add one of these to
+your shell's startup file:
+
+  source <(ok completion bash)   # ~/.bashrc
+  source <(ok completion zsh)    # ~/.zshrc
+  ok completion fish | source    # ~/.config/fish/config.fish
+
+Target labels come from a cached ` + "`bazel query //...`" + `, which is refreshed in
+the background when it is older than the completion.target_cache_ttl config
+value (default 1h).
+`,
+			Args: []command.Arg{
+				{Name: "shell", Help: "One of bash, zsh or fish."},
+			},
+			Handler: completion.HandleCompletion,
+			Aliases: []string{},
+		},
+		{
+			Name:        "config",
+			Help:        "Gets and sets ok config values.",
+			Description: config.Description,
+			Flags:       config.Flags,
+			Args: []command.Arg{
+				{Name: "subcommand", Help: "One of list, get or set."},
+				{Name: "key", Help: "The key to get or set, like alias.tc.", Optional: true},
+				{Name: "value", Help: "The value to set.", Optional: true},
+			},
+			Handler: config.HandleConfig,
+			Aliases: []string{},
+		},
+		{
+			Name:        "explain-rebuild",
+			Help:        "Explains why actions ran again, by comparing execution logs.",
+			Description: execlog.Description,
+			Flags:       execlog.Flags,
+			Args: []command.Arg{
+				{Name: "old", Help: "The invocation to compare with. Defaults to 2, the one before the latest.", Optional: true},
+				{Name: "new", Help: "The invocation to explain. Defaults to 1, the latest.", Optional: true},
+			},
+			Handler: execlog.HandleExplainRebuild,
+			Aliases: []string{},
+		},
+		{
+			Name:        "fix",
+			Help:        "Offers to fix the failure of an earlier command.",
+			Description: history.FixDescription,
+			Flags:       history.FixFlags,
+			Args: []command.Arg{
+				{Name: "command", Help: "The number or ID of the command in ok history. Defaults to the latest failed command.", Optional: true},
+			},
+			Handler: history.HandleFix,
+			Aliases: []string{},
+		},
+		{
+			Name:           "flags",
+			Help:           "Shows the effective bazel flags after applying rc files and configs.",
+			Description:    bazelrc.Description,
+			Flags:          bazelrc.Flags,
+			StopAtFirstArg: true,
+			Args: []command.Arg{
+				{Name: "command", Help: "The bazel command to expand the flags of, like build or test."},
+				{Name: "args", Help: "Options and targets, as they would be passed to the command.", Optional: true, Repeated: true},
+			},
+			Handler: bazelrc.HandleFlags,
+			Aliases: []string{},
+		},
+		{
+			Name:        "history",
+			Help:        "Lists the bazel commands that ok ran.",
+			Description: history.Description,
+			Flags:       history.Flags,
+			Args: []command.Arg{
+				{Name: "words", Help: "Only list commands that contain these words.", Optional: true, Repeated: true},
+			},
+			Handler: history.HandleHistory,
+			Aliases: []string{},
+		},
+		{
+			Name:        "last",
+			Help:        "Shows the last bazel command that ok ran, or another one from the history.",
+			Description: history.LastDescription,
+			Flags:       history.LastFlags,
+			Args: []command.Arg{
+				{Name: "command", Help: "The number or ID of the command in ok history. Defaults to the latest command.", Optional: true},
+			},
+			Handler: history.HandleLast,
+			Aliases: []string{},
+		},
+		{
+			Name:        "please",
+			Help:        "Asks ok to perform a task.",
+			Description: please.Description,
+			Flags:       please.Flags,
+			Args: []command.Arg{
+				{Name: "task", Help: "The task to perform.", Repeated: true},
+			},
 			Handler: please.HandleAsk,
 			Aliases: []string{},
 		},
 		{
-			Name:    "version",
-			Help:    "Prints the version of ok.",
+			Name:        "profile",
+			Help:        "Analyzes a bazel JSON trace profile.",
+			Description: profile.Description,
+			Flags:       profile.Flags,
+			Args: []command.Arg{
+				{Name: "file", Help: "The profile to analyze. Defaults to the profile of the last bazel command.", Optional: true},
+			},
+			Handler: profile.HandleProfile,
+			Aliases: []string{},
+		},
+		{
+			Name:        "rerun",
+			Help:        "Runs a command from the history again.",
+			Description: history.RerunDescription,
+			Flags:       history.RerunFlags,
+			Args: []command.Arg{
+				{Name: "command", Help: "The number or ID of the command in ok history. Defaults to the latest command.", Optional: true},
+			},
+			Handler: history.HandleRerun,
+			Aliases: []string{},
+		},
+		{
+			Name:        "undo",
+			Help:        "Undoes the changes that an agent made.",
+			Description: checkpoint.Description,
+			Flags:       checkpoint.Flags,
+			Args: []command.Arg{
+				{Name: "checkpoint", Help: "The number of the checkpoint in ok undo --list to go back to. Defaults to the latest.", Optional: true},
+			},
+			Handler: checkpoint.HandleUndo,
+			Aliases: []string{},
+		},
+		{
+			Name: "version",
+			Help: "Prints the version of ok.",
+			Description: `
+Prints the version of ok, followed by the version of bazel. Any other
+arguments are passed to ` + "`bazel version`" + `.
+
+Pass --cli to only print the version of ok.
+`,
 			Handler: version.HandleVersion,
 			Aliases: []string{},
 		},
@@ -0,0 +1,434 @@
+package completion
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/bazelisk"
+	"ok.build/cli/bazelrc"
+	"ok.build/cli/command"
+	"ok.build/cli/config"
+	"ok.build/cli/log"
+	"ok.build/cli/shortcuts"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// RefreshCommandName is the hidden command that refreshes the target
+	// label cache in the background.
+	RefreshCommandName = "__complete_refresh"
+
+	defaultTargetCacheTTL = time.Hour
+	// refreshTimeout bounds how long a background refresh is assumed to be
+	// running, so that a crashed refresh doesn't block future ones.
+	refreshTimeout = 10 * time.Minute
+)
+
+var (
+	// targetCommands are the bazel commands whose positional args are target
+	// labels.
+	targetCommands = []string{"aquery", "build", "coverage", "cquery", "mobile-install", "print_action", "query", "run", "test"}
+)
+
+// Candidate is a single completion, with an optional description.
+type Candidate struct {
+	Value       string
+	Description string
+}
+
+// HandleComplete handles the hidden `ok __complete <words...>` command, which
+// is called by the completion scripts. The last word is the one being
+// completed, and may be empty. Candidates are printed one per line, with
+// their description after a tab.
+func HandleComplete(args []string) (exitCode int, err error) {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	for _, c := range Complete(args[:len(args)-1], args[len(args)-1]) {
+		if c.Description != "" {
+			fmt.Printf("%s\t%s\n", c.Value, c.Description)
+		} else {
+			fmt.Println(c.Value)
+		}
+	}
+	return 0, nil
+}
+
+// Complete returns the candidates for the word cur, given the preceding words
+// of the command line (not including "ok").
+func Complete(words []string, cur string) []Candidate {
+	cmd, idx := arg.GetCommandAndIndex(words)
+	if idx == -1 {
+		if strings.HasPrefix(cur, "-") {
+			return startupOptions(cur)
+		}
+		return commands(cur)
+	}
+
+	if c := command.GetCommand(cmd); c != nil {
+		return okCommandArgs(c, words[idx+1:], cur)
+	}
+
+	// Resolve aliases and shortcuts to the bazel command that they run.
+	if expanded, err := shortcuts.HandleShortcuts(slices.Clone(words[:idx+1])); err == nil {
+		cmd = arg.GetCommand(expanded)
+	}
+	if prev := lastWord(words); prev == "--config" {
+		return configs(words[:idx], "", cur)
+	}
+	if v, ok := strings.CutPrefix(cur, "--config="); ok {
+		return configs(words[:idx], "--config=", v)
+	}
+	if strings.HasPrefix(cur, "-") {
+		return commandOptions(cmd, cur)
+	}
+	if slices.Contains(targetCommands, cmd) && !slices.Contains(words[idx:], "--") {
+		if prev := lastWord(words); prev != "" && strings.HasPrefix(prev, "-") && !strings.Contains(prev, "=") {
+			if o, negated := arg.LookupOption(prev); o != nil && o.RequiresValue && !negated {
+				// The word is the value of an option, not a target.
+				return nil
+			}
+		}
+		return labels(cur)
+	}
+	return nil
+}
+
+func lastWord(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	return words[len(words)-1]
+}
+
+func commands(cur string) []Candidate {
+	var out []Candidate
+	for _, c := range command.Commands {
+		if !c.Hidden {
+			out = append(out, Candidate{c.Name, c.Help})
+		}
+	}
+	for name, expansion := range shortcuts.Aliases() {
+		out = append(out, Candidate{name, "alias for " + expansion})
+	}
+	for name, expansion := range shortcuts.Shortcuts() {
+		out = append(out, Candidate{name, "shortcut for " + expansion})
+	}
+	for _, c := range command.ExternalCommands() {
+		out = append(out, Candidate{c.Name, c.Help})
+	}
+	for _, c := range command.BazelCommands {
+		if command.CommandsByName[c] == nil {
+			out = append(out, Candidate{c, "bazel command"})
+		}
+	}
+	return filter(out, cur)
+}
+
+func startupOptions(cur string) []Candidate {
+	var out []Candidate
+	out = append(out, globalFlags()...)
+	for _, o := range arg.BazelSchema().Options {
+		if o.IsStartupOption() {
+			out = append(out, optionCandidate(o))
+		}
+	}
+	return filter(out, cur)
+}
+
+func commandOptions(cmd, cur string) []Candidate {
+	var out []Candidate
+	out = append(out, globalFlags()...)
+	for _, o := range arg.BazelSchema().Options {
+		// Options from the fallback schema don't list their commands.
+		if len(o.Commands) == 0 || slices.Contains(o.Commands, cmd) {
+			out = append(out, optionCandidate(o))
+		}
+	}
+	return filter(out, cur)
+}
+
+func globalFlags() []Candidate {
+	var out []Candidate
+	for _, f := range command.GlobalFlags {
+		c := Candidate{"--" + f.Name, f.Help}
+		if f.Value != "" {
+			c.Value += "="
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func optionCandidate(o *arg.Option) Candidate {
+	c := Candidate{Value: "--" + o.Name, Description: o.Help}
+	if o.RequiresValue {
+		c.Value += "="
+	}
+	// Only show the first sentence of bazel's documentation.
+	if i := strings.IndexAny(c.Description, ".\n"); i >= 0 {
+		c.Description = c.Description[:i]
+	}
+	return c
+}
+
+func okCommandArgs(c *command.Command, args []string, cur string) []Candidate {
+	switch c.Name {
+	case "completion":
+		return filter([]Candidate{{"bash", ""}, {"zsh", ""}, {"fish", ""}}, cur)
+	}
+	if strings.HasPrefix(cur, "-") && c.Flags != nil {
+		var out []Candidate
+		c.Flags.VisitAll(func(f *flag.Flag) {
+			out = append(out, Candidate{"--" + f.Name, f.Usage})
+		})
+		return filter(out, cur)
+	}
+	return nil
+}
+
+func configs(startupArgs []string, prefix, cur string) []Candidate {
+	names, err := bazelrc.Configs(startupArgs)
+	if err != nil {
+		return nil
+	}
+	var out []Candidate
+	for _, name := range names {
+		out = append(out, Candidate{Value: prefix + name})
+	}
+	return filter(out, prefix+cur)
+}
+
+func filter(candidates []Candidate, prefix string) []Candidate {
+	var out []Candidate
+	for _, c := range candidates {
+		if strings.HasPrefix(c.Value, prefix) {
+			out = append(out, c)
+		}
+	}
+	slices.SortFunc(out, func(a, b Candidate) int { return strings.Compare(a.Value, b.Value) })
+	return slices.CompactFunc(out, func(a, b Candidate) bool { return a.Value == b.Value })
+}
+
+// labels completes target labels and packages. Packages complete one
+// directory at a time, like "//foo/" or "//foo/bar:", so that the list stays
+// short in large repos. Labels come from a cached `bazel query`; if there is
+// no cache yet, packages are found by walking the workspace instead.
+func labels(cur string) []Candidate {
+	ws, err := workspace.Path()
+	if err != nil {
+		return nil
+	}
+	if !strings.HasPrefix(cur, "//") && !strings.HasPrefix(cur, ":") {
+		if cur != "" && !strings.HasPrefix("//", cur) {
+			return nil
+		}
+		cur = "//"
+	}
+	if strings.HasPrefix(cur, ":") {
+		pkg, err := workspace.CurrentPackage()
+		if err != nil {
+			return nil
+		}
+		var out []Candidate
+		for _, c := range labels(pkg + cur) {
+			// Keep the candidates relative to the current package.
+			_, target, _ := strings.Cut(c.Value, ":")
+			out = append(out, Candidate{Value: ":" + target})
+		}
+		return out
+	}
+
+	targets := cachedTargets(ws)
+	var packages []string
+	if targets == nil {
+		packages = walkPackages(ws, cur)
+	} else {
+		for _, t := range targets {
+			pkg, _, _ := strings.Cut(t, ":")
+			packages = append(packages, pkg)
+		}
+	}
+
+	var out []Candidate
+	if pkg, _, ok := strings.Cut(cur, ":"); ok {
+		out = append(out, Candidate{Value: pkg + ":all"})
+		for _, t := range targets {
+			if strings.HasPrefix(t, pkg+":") {
+				out = append(out, Candidate{Value: t})
+			}
+		}
+		return filter(out, cur)
+	}
+	if strings.HasSuffix(cur, "/") {
+		out = append(out, Candidate{Value: cur + "..."})
+	}
+	for _, pkg := range packages {
+		rest, ok := strings.CutPrefix(pkg, cur)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			out = append(out, Candidate{Value: cur + rest[:i+1]})
+		} else {
+			out = append(out, Candidate{Value: pkg + ":"})
+		}
+	}
+	return filter(out, cur)
+}
+
+// walkPackages returns the packages in the directory named by the label
+// prefix, and the packages in its immediate subdirectories.
+func walkPackages(ws, prefix string) []string {
+	dir := strings.TrimPrefix(prefix, "//")
+	if i := strings.LastIndex(dir, "/"); i >= 0 {
+		dir = dir[:i]
+	} else {
+		dir = ""
+	}
+	entries, err := os.ReadDir(filepath.Join(ws, dir))
+	if err != nil {
+		return nil
+	}
+	var packages []string
+	if isPackage(filepath.Join(ws, dir)) {
+		packages = append(packages, "//"+dir)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") || strings.HasPrefix(e.Name(), "bazel-") {
+			continue
+		}
+		rel := strings.TrimPrefix(dir+"/"+e.Name(), "/")
+		if isPackage(filepath.Join(ws, rel)) {
+			packages = append(packages, "//"+rel)
+		}
+		// The directory may contain packages further down.
+		packages = append(packages, "//"+rel+"/")
+	}
+	return packages
+}
+
+func isPackage(dir string) bool {
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && !fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func targetCachePath(ws string) (string, error) {
+	okDir, err := config.OkDir()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(ws))
+	return filepath.Join(okDir, "cache", "targets", hex.EncodeToString(h[:8])+".txt"), nil
+}
+
+// cachedTargets returns every target label in the workspace from the cache,
+// or nil if there is no cache yet. If the cache is missing or older than the
+// `completion.target_cache_ttl` config value, it is refreshed in the
+// background.
+func cachedTargets(ws string) []string {
+	path, err := targetCachePath(ws)
+	if err != nil {
+		return nil
+	}
+	ttl := defaultTargetCacheTTL
+	if v := config.Get("completion.target_cache_ttl"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			ttl = d
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil || time.Since(fi.ModTime()) > ttl {
+		startRefresh(path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(b))
+}
+
+// startRefresh runs `ok __complete_refresh` in the background, unless a
+// refresh is already running.
+func startRefresh(cachePath string) {
+	marker := cachePath + ".refreshing"
+	if fi, err := os.Stat(marker); err == nil && time.Since(fi.ModTime()) < refreshTimeout {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		return
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+	cmd := exec.Command(self, RefreshCommandName)
+	if err := cmd.Start(); err != nil {
+		os.Remove(marker)
+		return
+	}
+	// Don't wait for the refresh; it outlives this process.
+	cmd.Process.Release()
+}
+
+// HandleRefresh handles the hidden `ok __complete_refresh` command, which
+// queries every target in the workspace and writes them to the cache.
+func HandleRefresh(args []string) (exitCode int, err error) {
+	ws, err := workspace.Path()
+	if err != nil {
+		return 1, err
+	}
+	path, err := targetCachePath(ws)
+	if err != nil {
+		return 1, err
+	}
+	defer os.Remove(path + ".refreshing")
+
+	buf := &bytes.Buffer{}
+	exitCode, err = bazelisk.Run(
+		[]string{"query", "//...", "--output=label", "--keep_going", "--noshow_progress"},
+		&bazelisk.RunOpts{Stdout: buf, Stderr: io.Discard},
+	)
+	if err != nil {
+		return 1, err
+	}
+	// With --keep_going, exit code 3 means that some packages failed to load,
+	// but the rest of the targets are still useful.
+	if exitCode != 0 && exitCode != 3 {
+		return exitCode, nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return 1, err
+	}
+	log.Debugf("Cached %d target labels in %s", bytes.Count(buf.Bytes(), []byte("\n")), path)
+	return 0, os.Rename(tmp, path)
+}
+
+// HandleCompletion handles the `ok completion <shell>` command.
+func HandleCompletion(args []string) (exitCode int, err error) {
+	script, ok := scripts[args[0]]
+	if !ok {
+		return 1, fmt.Errorf("unsupported shell %q, expected one of bash, zsh, fish", args[0])
+	}
+	fmt.Print(script)
+	return 0, nil
+}
@@ -0,0 +1,52 @@
+package completion
+
+// The completion scripts call `ok __complete` with the words typed so far,
+// and treat candidates ending in "/", ":" or "=" as incomplete, so that no
+// space is added after them.
+var scripts = map[string]string{
+	"bash": `# bash completion for ok. Load it with:
+#   source <(ok completion bash)
+_ok() {
+  local cur words cword
+  if declare -F _get_comp_words_by_ref >/dev/null; then
+    # Don't split labels like //foo:bar at the colon.
+    _get_comp_words_by_ref -n =: cur words cword
+  else
+    words=("${COMP_WORDS[@]}")
+    cword=$COMP_CWORD
+    cur="${COMP_WORDS[COMP_CWORD]}"
+  fi
+  local IFS=$'\n'
+  COMPREPLY=($(ok __complete "${words[@]:1:cword}" 2>/dev/null | cut -f1))
+  # bash completes the part of the word after the last ":" or "=".
+  if [[ "$cur" == *[:=]* ]]; then
+    local prefix="${cur%"${cur##*[:=]}"}"
+    COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+  fi
+  if [[ ${#COMPREPLY[@]} -eq 1 && "${COMPREPLY[0]}" == *[/:=] ]]; then
+    compopt -o nospace
+  fi
+}
+complete -F _ok ok
+`,
+	"zsh": `#compdef ok
+# zsh completion for ok. Load it with:
+#   source <(ok completion zsh)
+_ok() {
+  local -a lines values
+  lines=("${(@f)$(ok __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+  values=("${(@)lines%%$'\t'*}")
+  compadd -Q -S '' -- "${(@M)values:#*[/:=]}"
+  compadd -Q -- "${(@)values:#*[/:=]}"
+}
+compdef _ok ok
+`,
+	"fish": `# fish completion for ok. Load it with:
+#   ok completion fish | source
+function __ok_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    ok __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c ok -f -a '(__ok_complete)'
+`,
+}
@@ -0,0 +1,391 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ok.build/cli/workspace"
+)
+
+const (
+	// UserLayer is the name of the layer read from the user's home directory.
+	UserLayer = "user"
+	// WorkspaceLayer is the name of the layer read from the workspace root.
+	WorkspaceLayer = "workspace"
+
+	// workspaceConfigName is the name of the checked-in config file that lives
+	// next to MODULE.bazel.
+	workspaceConfigName = ".okconfig"
+)
+
+// Layer is a single config file. Layers are ordered from lowest to highest
+// precedence, so values in the workspace layer override the user layer.
+type Layer struct {
+	Name    string
+	Path    string
+	Entries []*Entry
+}
+
+// Entry is a single `key = value` line from a config file.
+type Entry struct {
+	// Key is the fully qualified key, i.e. "section.name".
+	Key   string
+	Value string
+	Layer *Layer
+	Line  int
+}
+
+var (
+	Flags = flag.NewFlagSet("config", flag.ContinueOnError)
+
+	workspaceFlag = Flags.Bool("workspace", false, "Write to the workspace config (.okconfig) instead of the user config (~/.ok/config).")
+)
+
+const Description = `
+Config is read from two layers, in increasing order of precedence:
+
+  user       ~/.ok/config
+  workspace  .okconfig in the workspace root, next to MODULE.bazel
+
+Both files use the same format, for example:
+
+  [alias]
+  tc = test --config=ci --test_output=errors
+
+Subcommands:
+
+  list               Lists every value and the file and line it came from.
+  get <key>          Prints the effective value of a key and where it came from.
+  set <key> <value>  Sets a value in the user config, or the workspace config
+                     with --workspace.
+`
+
+var (
+	// Layers holds every config file that was loaded, from lowest to highest
+	// precedence.
+	//
+	// It is nil until Load is called.
+	Layers []*Layer
+)
+
+// Load reads the user config (~/.ok/config) and the workspace config
+// (.okconfig next to MODULE.bazel). Missing files are not an error. A file
+// that can't be read is skipped, and the other layers are still loaded, so
+// that the config command can be used to repair it.
+func Load() error {
+	Layers = nil
+	var errs []error
+	for _, name := range []string{UserLayer, WorkspaceLayer} {
+		path, err := LayerPath(name)
+		if err != nil {
+			// No home directory or no workspace; skip the layer.
+			continue
+		}
+		l, err := readLayer(name, path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		Layers = append(Layers, l)
+	}
+	return errors.Join(errs...)
+}
+
+// LayerPath returns the location of the config file for the given layer.
+func LayerPath(name string) (string, error) {
+	switch name {
+	case UserLayer:
+		dir, err := OkDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "config"), nil
+	case WorkspaceLayer:
+		root, err := workspace.Path()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(root, workspaceConfigName), nil
+	}
+	return "", fmt.Errorf("unknown config layer %q", name)
+}
+
+// OkDir returns the ~/.ok directory, where ok keeps per-user state.
+func OkDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %v", err)
+	}
+	return filepath.Join(home, ".ok"), nil
+}
+
+// Lookup returns the effective entry for the given key, if any.
+func Lookup(key string) (*Entry, bool) {
+	for i := len(Layers) - 1; i >= 0; i-- {
+		entries := Layers[i].Entries
+		for j := len(entries) - 1; j >= 0; j-- {
+			if entries[j].Key == key {
+				return entries[j], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Get returns the effective value for the given key, or "" if it is unset.
+func Get(key string) string {
+	if e, ok := Lookup(key); ok {
+		return e.Value
+	}
+	return ""
+}
+
+// GetAll returns every value for the given key across all layers, from lowest
+// to highest precedence. This is meant for multi-valued keys.
+func GetAll(key string) []string {
+	var out []string
+	for _, l := range Layers {
+		for _, e := range l.Entries {
+			if e.Key == key {
+				out = append(out, e.Value)
+			}
+		}
+	}
+	return out
+}
+
+// GetBool returns the effective value for the given key parsed as a boolean,
+// or defaultValue if it is unset or invalid.
+func GetBool(key string, defaultValue bool) bool {
+	v, err := strconv.ParseBool(Get(key))
+	if err != nil {
+		return defaultValue
+	}
+	return v
+}
+
+// GetInt returns the effective value for the given key parsed as an integer,
+// or defaultValue if it is unset or invalid.
+func GetInt(key string, defaultValue int) int {
+	v, err := strconv.Atoi(Get(key))
+	if err != nil {
+		return defaultValue
+	}
+	return v
+}
+
+// Section returns the effective values of every key in the given section,
+// indexed by the key name with the section prefix removed.
+func Section(section string) map[string]string {
+	out := map[string]string{}
+	prefix := section + "."
+	for _, l := range Layers {
+		for _, e := range l.Entries {
+			if name, ok := strings.CutPrefix(e.Key, prefix); ok {
+				out[name] = e.Value
+			}
+		}
+	}
+	return out
+}
+
+// Keys returns the sorted set of keys that are set in any layer.
+func Keys() []string {
+	seen := map[string]struct{}{}
+	for _, l := range Layers {
+		for _, e := range l.Entries {
+			seen[e.Key] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Serialize returns the effective config as `key=value` lines, one per key.
+func Serialize() string {
+	var b strings.Builder
+	for _, k := range Keys() {
+		fmt.Fprintf(&b, "%s=%s\n", k, Get(k))
+	}
+	return b.String()
+}
+
+func readLayer(name, path string) (*Layer, error) {
+	l := &Layer{Name: name, Path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		key, value, s, err := parseLine(scanner.Text(), section)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, lineNumber, err)
+		}
+		section = s
+		if key == "" {
+			continue
+		}
+		l.Entries = append(l.Entries, &Entry{Key: key, Value: value, Layer: l, Line: lineNumber})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// parseLine parses a single config line in the context of the current section.
+// It returns an empty key for blank lines, comments and section headers.
+func parseLine(line, section string) (key, value, newSection string, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+		return "", "", section, nil
+	}
+	if strings.HasPrefix(line, "[") {
+		if !strings.HasSuffix(line, "]") {
+			return "", "", section, fmt.Errorf("malformed section header %q", line)
+		}
+		return "", "", strings.TrimSpace(line[1 : len(line)-1]), nil
+	}
+	name, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", section, fmt.Errorf("expected `key = value`, got %q", line)
+	}
+	name = strings.TrimSpace(name)
+	if section == "" {
+		return "", "", section, fmt.Errorf("key %q is not in a [section]", name)
+	}
+	return section + "." + name, unquote(strings.TrimSpace(value)), section, nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if v, err := strconv.Unquote(value); err == nil {
+			return v
+		}
+	}
+	return value
+}
+
+func quote(value string) string {
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, "\"\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// Set writes `key = value` to the config file of the given layer, replacing
+// the last existing value of the key in that file if there is one.
+func Set(layerName, key, value string) error {
+	section, name, ok := strings.Cut(key, ".")
+	if !ok || section == "" || name == "" {
+		return fmt.Errorf("invalid key %q: keys have the form section.name", key)
+	}
+	path, err := LayerPath(layerName)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	if len(b) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	newLine := fmt.Sprintf("%s = %s", name, quote(value))
+
+	replaceAt, sectionEnd := -1, -1
+	current := ""
+	for i, line := range lines {
+		// Lines that don't parse are kept as they are, and don't stop keys
+		// from being set around them.
+		k, _, s, _ := parseLine(line, current)
+		current = s
+		if current == section && strings.TrimSpace(line) != "" {
+			sectionEnd = i
+		}
+		if k == key {
+			replaceAt = i
+		}
+	}
+	switch {
+	case replaceAt >= 0:
+		lines[replaceAt] = newLine
+	case sectionEnd >= 0:
+		lines = append(lines[:sectionEnd+1], append([]string{newLine}, lines[sectionEnd+1:]...)...)
+	default:
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+section+"]", newLine)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	// Errors in the other layer were already reported when ok started.
+	Load()
+	return nil
+}
+
+// HandleConfig handles the `ok config` command.
+func HandleConfig(args []string) (exitCode int, err error) {
+	layerName := UserLayer
+	if *workspaceFlag {
+		layerName = WorkspaceLayer
+	}
+	switch args[0] {
+	case "list":
+		for _, l := range Layers {
+			for _, e := range l.Entries {
+				fmt.Printf("%s\t%s=%s\n", origin(e), e.Key, e.Value)
+			}
+		}
+		return 0, nil
+	case "get":
+		if len(args) != 2 {
+			return 1, fmt.Errorf("usage: ok config get <key>")
+		}
+		e, ok := Lookup(args[1])
+		if !ok {
+			return 1, nil
+		}
+		fmt.Printf("%s\t%s\n", origin(e), e.Value)
+		return 0, nil
+	case "set":
+		if len(args) != 3 {
+			return 1, fmt.Errorf("usage: ok config set [--workspace] <key> <value>")
+		}
+		if err := Set(layerName, args[1], args[2]); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	return 1, fmt.Errorf("unknown config subcommand %q", args[0])
+}
+
+// origin describes where an entry came from, like "workspace:/repo/.okconfig:3".
+func origin(e *Entry) string {
+	return fmt.Sprintf("%s:%s:%d", e.Layer.Name, e.Layer.Path, e.Line)
+}
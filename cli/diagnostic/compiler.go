@@ -0,0 +1,141 @@
+package diagnostic
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CompilerDiagnostic is a diagnostic printed by a compiler or interpreter
+// that ran as part of a failed action.
+type CompilerDiagnostic struct {
+	// Language is one of "go", "java", "cpp", "python" or "typescript".
+	Language string
+
+	File   string
+	Line   int
+	Column int
+
+	// Severity is "error" or "warning".
+	Severity string
+	// Code is the diagnostic's code, such as "TS2304", if the compiler
+	// prints one.
+	Code    string
+	Message string
+
+	// Details are the lines printed after the diagnostic, such as the
+	// offending source line or javac's strict deps instructions.
+	Details []string
+}
+
+// String formats the diagnostic like "pkg/a.go:3:5: undefined: x".
+func (d *CompilerDiagnostic) String() string {
+	s := location(d.File, d.Line, d.Column) + ": "
+	if d.Severity == "warning" {
+		s += "warning: "
+	}
+	if d.Code != "" {
+		s += d.Code + ": "
+	}
+	return s + d.Message
+}
+
+var (
+	// gccPattern matches gcc, clang, Go and mypy style diagnostics, like
+	// "pkg/a.cc:3:10: error: message" or "pkg/a.go:3:5: message".
+	gccPattern = regexp.MustCompile(`^(?:\w+: )?([\w./+@-]+\.(\w+)):(\d+):(?:(\d+):)? (?:(fatal error|error|warning|note): )?(.+)$`)
+	// javacPattern matches "pkg/A.java:12: error: message".
+	javacPattern = regexp.MustCompile(`^([\w./+@$-]+\.java):(\d+): (error|warning): (.+)$`)
+	// tscPatterns match "pkg/a.ts(12,5): error TS2304: message" and
+	// "pkg/a.ts:12:5 - error TS2304: message".
+	tscPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^([\w./+@-]+\.[cm]?tsx?)\((\d+),(\d+)\): (error|warning) (TS\d+): (.+)$`),
+		regexp.MustCompile(`^([\w./+@-]+\.[cm]?tsx?):(\d+):(\d+) - (error|warning) (TS\d+): (.+)$`),
+	}
+	// pythonFramePattern matches the frames of a Python traceback, and
+	// pythonExceptionPattern matches the exception that ends it.
+	pythonFramePattern     = regexp.MustCompile(`^\s*File "(.+?)", line (\d+)`)
+	pythonExceptionPattern = regexp.MustCompile(`^(\w+(?:\.\w+)*(?:Error|Exception|Exit|Interrupt)): ?(.*)$`)
+
+	languages = map[string]string{
+		"go": "go", "java": "java", "py": "python",
+		"c": "cpp", "cc": "cpp", "cpp": "cpp", "cxx": "cpp", "h": "cpp", "hh": "cpp", "hpp": "cpp", "hxx": "cpp", "m": "cpp", "mm": "cpp",
+		"ts": "typescript", "tsx": "typescript", "mts": "typescript", "cts": "typescript",
+	}
+)
+
+// parseCompilerOutput extracts compiler diagnostics from a failed action's
+// output. Notes are attached to the diagnostic they follow.
+func parseCompilerOutput(output string) []*CompilerDiagnostic {
+	var out []*CompilerDiagnostic
+	var last *CompilerDiagnostic
+	// frame is the innermost frame of the Python traceback being read.
+	var frame *CompilerDiagnostic
+	for _, line := range strings.Split(output, "\n") {
+		if d := parseCompilerLine(line); d != nil {
+			if d.Severity == "note" && last != nil {
+				last.Details = append(last.Details, line)
+				continue
+			}
+			out = append(out, d)
+			last = d
+			continue
+		}
+		if m := pythonFramePattern.FindStringSubmatch(line); m != nil {
+			frame = &CompilerDiagnostic{Language: "python", File: m[1], Severity: "error"}
+			frame.Line, _ = strconv.Atoi(m[2])
+			continue
+		}
+		if m := pythonExceptionPattern.FindStringSubmatch(line); m != nil && frame != nil {
+			frame.Code = m[1]
+			frame.Message = m[2]
+			out = append(out, frame)
+			last, frame = frame, nil
+			continue
+		}
+		if last != nil && strings.TrimSpace(line) != "" && frame == nil {
+			last.Details = append(last.Details, line)
+		}
+	}
+	return out
+}
+
+func parseCompilerLine(line string) *CompilerDiagnostic {
+	for _, p := range tscPatterns {
+		if m := p.FindStringSubmatch(line); m != nil {
+			d := &CompilerDiagnostic{Language: "typescript", File: m[1], Severity: m[4], Code: m[5], Message: m[6]}
+			d.Line, _ = strconv.Atoi(m[2])
+			d.Column, _ = strconv.Atoi(m[3])
+			return d
+		}
+	}
+	if m := javacPattern.FindStringSubmatch(line); m != nil {
+		d := &CompilerDiagnostic{Language: "java", File: m[1], Severity: m[3], Message: m[4]}
+		d.Line, _ = strconv.Atoi(m[2])
+		return d
+	}
+	m := gccPattern.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	language, ok := languages[m[2]]
+	if !ok {
+		return nil
+	}
+	d := &CompilerDiagnostic{Language: language, File: path.Clean(m[1]), Severity: m[5], Message: m[6]}
+	switch d.Severity {
+	case "":
+		// Go and mypy don't print a severity for errors; mypy prints
+		// "error:" as part of the message.
+		d.Severity = "error"
+		if rest, ok := strings.CutPrefix(d.Message, "error: "); ok {
+			d.Message = rest
+		}
+	case "fatal error":
+		d.Severity = "error"
+	}
+	d.Line, _ = strconv.Atoi(m[3])
+	d.Column, _ = strconv.Atoi(m[4])
+	return d
+}
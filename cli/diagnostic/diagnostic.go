@@ -0,0 +1,286 @@
+// Package diagnostic extracts the errors that bazel reports from its
+// terminal output.
+package diagnostic
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kind is the phase of the build that a diagnostic comes from.
+type Kind string
+
+const (
+	KindLoading  Kind = "loading"
+	KindAnalysis Kind = "analysis"
+	KindAction   Kind = "action"
+	KindTest     Kind = "test"
+	KindFetch    Kind = "fetch"
+	// KindOther is for errors that don't fit any other kind, such as invalid
+	// flags.
+	KindOther Kind = "other"
+)
+
+// Diagnostic is an error reported by bazel.
+type Diagnostic struct {
+	Kind Kind
+
+	// Label is the target that the error is about, if any.
+	Label string
+
+	// File, Line and Column locate the error. For action errors, this is the
+	// target's declaration in its BUILD file, and the source locations are in
+	// Compiler. For test errors, File is the test log.
+	File   string
+	Line   int
+	Column int
+
+	Message string
+
+	// Mnemonic is the failing action's mnemonic, such as "GoCompilePkg", if
+	// bazel printed it.
+	Mnemonic string
+
+	// Output is the output of the failing action that bazel printed after the
+	// error.
+	Output string
+
+	// Compiler lists the diagnostics found in Output.
+	Compiler []*CompilerDiagnostic
+}
+
+// String formats the diagnostic on a single line, like
+// "pkg/a.go:3:5: undefined: x".
+func (d *Diagnostic) String() string {
+	if len(d.Compiler) > 0 {
+		return d.Compiler[0].String()
+	}
+	var b strings.Builder
+	if d.File != "" {
+		b.WriteString(location(d.File, d.Line, d.Column) + ": ")
+	}
+	if d.Label != "" && !strings.Contains(d.Message, d.Label) {
+		b.WriteString(d.Label + ": ")
+	}
+	b.WriteString(d.Message)
+	return b.String()
+}
+
+func location(file string, line, column int) string {
+	s := file
+	if line > 0 {
+		s += ":" + strconv.Itoa(line)
+		if column > 0 {
+			s += ":" + strconv.Itoa(column)
+		}
+	}
+	return s
+}
+
+var (
+	// errorPattern matches bazel's error lines, optionally located, like
+	// "ERROR: /ws/pkg/BUILD:3:11: message".
+	errorPattern = regexp.MustCompile(`^ERROR: (?:(\S+?):(\d+):(\d+): )?(.*)$`)
+	// unlocatedFilePattern matches error lines that only name a file, like
+	// "ERROR: /ws/pkg/BUILD: no such target '//pkg:x'".
+	unlocatedFilePattern = regexp.MustCompile(`^(/\S+|\S+/BUILD(?:\.bazel)?): (.*)$`)
+
+	failPattern        = regexp.MustCompile(`^FAIL: (\S+) (?:\(.*\) )?\(see (\S+)\)`)
+	testSummaryPattern = regexp.MustCompile(`^(\S*//\S+)\s+(FAILED|TIMEOUT|NO STATUS|INCOMPLETE|REMOTE FAILURE)(?: in .*)?$`)
+
+	labelPattern     = regexp.MustCompile(`(?:@@?[\w.~+-]*)?//[\w./+-]*(?::[\w./+=,@~-]+)?`)
+	fromTargetLabel  = regexp.MustCompile(`\(from target (\S+?)\)`)
+	ruleLabelPattern = regexp.MustCompile(`(?:rule|target) '?((?:@@?[\w.~+-]*)?//[^\s':]*(?::[^\s':]+)?)'?`)
+	// mnemonicPatterns match "error executing GoCompilePkg command" and, in
+	// older bazel versions, "GoCompilePkg pkg/lib.a failed: (Exit 1)".
+	mnemonicPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`error executing (\w+) command`),
+		regexp.MustCompile(`^(\w+) \S+ failed: \(`),
+	}
+
+	// bazelLinePrefixes start lines that bazel prints itself, which end the
+	// output of a failed action.
+	bazelLinePrefixes = []string{
+		"ERROR: ", "INFO: ", "WARNING: ", "DEBUG: ", "FAIL: ", "Target ",
+		"Loading:", "Analyzing:", "Computing main repo mapping:", "Fetching ",
+		"Executed ", "Use --verbose_failures",
+	}
+
+	// sandboxDebugHint is printed between a failed action's error and its
+	// output, so it doesn't end the output.
+	sandboxDebugHint = "Use --sandbox_debug"
+
+	// summaryMessages are errors that only summarize earlier errors.
+	summaryMessages = []string{
+		"Build did NOT complete successfully",
+		"Build failed. Not running target",
+		"Couldn't start the build. Unable to run tests",
+	}
+	// analysisFailedPattern matches the errors that follow the error that
+	// failed a target's analysis, like "Analysis of target '//pkg:x' failed"
+	// and "Analysis of target '//pkg:x' failed; build aborted: Analysis
+	// failed".
+	analysisFailedPattern = regexp.MustCompile(`^Analysis of target '[^']*' failed(?:; build aborted: Analysis failed)?$`)
+)
+
+// ReadFile extracts the diagnostics from the bazel output in the file at path.
+func ReadFile(path string) ([]*Diagnostic, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(b)), nil
+}
+
+// Parse extracts the diagnostics from bazel's terminal output, which may
+// include ANSI escape codes.
+func Parse(output string) []*Diagnostic {
+	var diagnostics []*Diagnostic
+	// current is the action error whose output is being read.
+	var current *Diagnostic
+	var outputLines []string
+	finishAction := func() {
+		if current != nil {
+			current.Output = strings.TrimRight(strings.Join(outputLines, "\n"), "\n")
+			current.Compiler = parseCompilerOutput(current.Output)
+		}
+		current, outputLines = nil, nil
+	}
+	seenTests := map[string]bool{}
+
+	lines := strings.Split(Strip(output), "\n")
+	for i, line := range lines {
+		if current != nil && !isBazelLine(line) {
+			if !strings.HasPrefix(line, sandboxDebugHint) {
+				outputLines = append(outputLines, line)
+			}
+			continue
+		}
+		finishAction()
+
+		if m := failPattern.FindStringSubmatch(line); m != nil {
+			seenTests[m[1]] = true
+			diagnostics = append(diagnostics, &Diagnostic{Kind: KindTest, Label: m[1], File: m[2], Message: "test failed"})
+			continue
+		}
+		if m := testSummaryPattern.FindStringSubmatch(line); m != nil {
+			if seenTests[m[1]] {
+				continue
+			}
+			seenTests[m[1]] = true
+			d := &Diagnostic{Kind: KindTest, Label: m[1], Message: "test " + strings.ToLower(m[2])}
+			// The summary lists the test's logs on the following lines.
+			if i+1 < len(lines) {
+				if log := strings.TrimSpace(lines[i+1]); strings.HasSuffix(log, "test.log") {
+					d.File = log
+				}
+			}
+			diagnostics = append(diagnostics, d)
+			continue
+		}
+
+		m := errorPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := &Diagnostic{File: m[1], Message: m[4]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		if d.File == "" {
+			if fm := unlocatedFilePattern.FindStringSubmatch(d.Message); fm != nil {
+				d.File, d.Message = fm[1], fm[2]
+			}
+		}
+		if isSummary(d.Message) {
+			continue
+		}
+		d.Label = findLabel(d.Message)
+		d.Kind = classify(d)
+		if d.Kind == KindAction {
+			for _, p := range mnemonicPatterns {
+				if mm := p.FindStringSubmatch(d.Message); mm != nil {
+					d.Mnemonic = mm[1]
+					break
+				}
+			}
+			current = d
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	finishAction()
+	return diagnostics
+}
+
+// Summarize formats the diagnostics on one line each, listing at most max
+// of them.
+func Summarize(diagnostics []*Diagnostic, max int) string {
+	var b strings.Builder
+	for i, d := range diagnostics {
+		if i == max {
+			fmt.Fprintf(&b, "  ... and %d more\n", len(diagnostics)-max)
+			break
+		}
+		fmt.Fprintf(&b, "  %s error: %s\n", d.Kind, d.String())
+	}
+	return b.String()
+}
+
+func isBazelLine(line string) bool {
+	for _, p := range bazelLinePrefixes {
+		if strings.HasPrefix(line, p) {
+			return true
+		}
+	}
+	// Progress lines like "[1,234 / 2,345] Compiling ...".
+	return strings.HasPrefix(line, "[") && strings.Contains(line, " / ") && strings.Contains(line, "] ")
+}
+
+func isSummary(message string) bool {
+	for _, s := range summaryMessages {
+		if strings.HasPrefix(message, s) {
+			return true
+		}
+	}
+	return analysisFailedPattern.MatchString(message)
+}
+
+func findLabel(message string) string {
+	if m := fromTargetLabel.FindStringSubmatch(message); m != nil {
+		return m[1]
+	}
+	if m := ruleLabelPattern.FindStringSubmatch(message); m != nil {
+		return m[1]
+	}
+	return labelPattern.FindString(message)
+}
+
+func classify(d *Diagnostic) Kind {
+	m := d.Message
+	switch {
+	case strings.Contains(m, "error executing") || strings.Contains(m, "failed: (Exit") ||
+		strings.Contains(m, "failed (Exit") || strings.Contains(m, "failed: (Killed") ||
+		strings.Contains(m, "action failed"):
+		return KindAction
+	case strings.Contains(m, "fetch of repository") || strings.Contains(m, "Error downloading") ||
+		strings.Contains(m, "repository mapping") || strings.Contains(m, "module extension") ||
+		strings.Contains(m, "no such package '@") || strings.Contains(m, "Unable to find package for @") ||
+		strings.Contains(m, "MODULE.bazel") || strings.Contains(m, "lockfile"):
+		return KindFetch
+	case strings.Contains(m, "error loading package") || strings.Contains(m, "no such package") ||
+		strings.Contains(m, "no such target") || strings.HasPrefix(m, "Skipping '") ||
+		strings.Contains(m, "is not defined") || strings.Contains(m, "syntax error") ||
+		strings.Contains(m, "Traceback") || strings.Contains(m, "Error in ") ||
+		strings.HasSuffix(d.File, ".bzl") || strings.Contains(m, "target pattern"):
+		return KindLoading
+	case strings.Contains(m, "Analysis of target") || strings.Contains(m, "attribute of") ||
+		strings.Contains(m, "is not visible from") || strings.HasPrefix(m, "in ") ||
+		strings.Contains(m, "analysis"):
+		return KindAnalysis
+	case strings.HasSuffix(d.File, "/BUILD") || strings.HasSuffix(d.File, "/BUILD.bazel"):
+		return KindLoading
+	}
+	return KindOther
+}
@@ -0,0 +1,290 @@
+package diagnostic
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// want describes an expected diagnostic. message only needs to be a prefix
+// of the diagnostic's message, since bazel's action errors end with long
+// command lines.
+type want struct {
+	kind     Kind
+	label    string
+	location string
+	mnemonic string
+	message  string
+	compiler []string
+}
+
+// TestParse parses the logs in testdata. They aren't captured from bazel,
+// which the tests can't run, but written by hand after its output, so they
+// should be checked against bazel when its output changes.
+func TestParse(t *testing.T) {
+	const testLog = "/home/user/.cache/bazel/_bazel_user/8c2e/execroot/_main/bazel-out/k8-fastbuild/testlogs/"
+	for _, tc := range []struct {
+		log  string
+		want []want
+	}{
+		{
+			log: "loading_no_such_target.log",
+			want: []want{{
+				kind:     KindLoading,
+				label:    "//lib:missing",
+				location: "/home/user/ws/app/BUILD.bazel:12:10",
+				message:  "no such target '//lib:missing': target 'missing' not declared in package 'lib'",
+			}},
+		},
+		{
+			log: "loading_starlark_error.log",
+			want: []want{
+				{
+					kind:     KindLoading,
+					location: "/home/user/ws/proto/BUILD:7:15",
+					message:  "name 'proto_libary' is not defined (did you mean 'proto_library'?)",
+				},
+				{
+					kind:     KindLoading,
+					label:    "//app:server",
+					location: "/home/user/ws/app/BUILD.bazel:3:11",
+					message:  "no such package 'proto': Package 'proto' contains errors",
+				},
+				{kind: KindLoading, label: "//app:server", message: "Skipping '//app:server': Error evaluating '//app:server'"},
+				{kind: KindLoading, label: "//app:server", message: "Error evaluating '//app:server'"},
+			},
+		},
+		{
+			log: "analysis_visibility.log",
+			want: []want{{
+				kind:     KindAnalysis,
+				label:    "//app:server",
+				location: "/home/user/ws/app/BUILD.bazel:5:10",
+				message:  "in go_binary rule //app:server: target '//internal/secret:secret' is not visible from target '//app:server'",
+			}},
+		},
+		{
+			log: "action_go.log",
+			want: []want{{
+				kind:     KindAction,
+				label:    "//server:server",
+				location: "/home/user/ws/server/BUILD.bazel:3:11",
+				mnemonic: "GoCompilePkg",
+				message:  "GoCompilePkg server/server.a failed: (Exit 1)",
+				compiler: []string{
+					"go: server/handler.go:14:2: undefined: writeJSON",
+					"go: server/handler.go:27:9: cannot use id (variable of type string) as int value in argument to lookup",
+				},
+			}},
+		},
+		{
+			log: "action_java.log",
+			want: []want{{
+				kind:     KindAction,
+				label:    "//java/com/example:app",
+				location: "/home/user/ws/java/com/example/BUILD:1:13",
+				mnemonic: "Javac",
+				message:  "Building java/com/example/libapp.jar (2 source files) failed: (Exit 1)",
+				compiler: []string{
+					"java: java/com/example/App.java:9: cannot find symbol",
+					"java: java/com/example/App.java:3: [strict] Using type com.google.common.base.Strings from an indirect dependency",
+				},
+			}},
+		},
+		{
+			log: "action_cpp.log",
+			want: []want{{
+				kind:     KindAction,
+				label:    "//native:codec",
+				location: "/home/user/ws/native/BUILD:1:11",
+				mnemonic: "CppCompile",
+				message:  "Compiling native/codec.cc failed: (Exit 1)",
+				compiler: []string{
+					"cpp: native/codec.cc:12:10: 'Frame' was not declared in this scope",
+					"cpp: native/codec.cc:20:7: warning: unused variable 'n' [-Wunused-variable]",
+				},
+			}},
+		},
+		{
+			log: "action_python.log",
+			want: []want{{
+				kind:     KindAction,
+				label:    "//tools:gen_config",
+				location: "/home/user/ws/tools/BUILD.bazel:8:8",
+				mnemonic: "Genrule",
+				message:  "Executing genrule //tools:gen_config failed: (Exit 1)",
+				compiler: []string{"python: tools/render.py:24: KeyError: 'services'"},
+			}},
+		},
+		{
+			log: "action_typescript.log",
+			want: []want{{
+				kind:     KindAction,
+				label:    "//web:app_typings",
+				location: "/home/user/ws/web/BUILD.bazel:4:11",
+				mnemonic: "TsProject",
+				message:  "Compiling TypeScript project //web:app_typings",
+				compiler: []string{
+					"typescript: web/src/main.ts:3:10: TS2305: Module '\"./api\"' has no exported member 'fetchUser'.",
+					"typescript: web/src/main.ts:17:5: TS2322: Type 'string' is not assignable to type 'number'.",
+				},
+			}},
+		},
+		{
+			log: "test_failed.log",
+			want: []want{
+				{kind: KindTest, label: "//server:server_test", location: testLog + "server/server_test/test.log", message: "test failed"},
+				{kind: KindTest, label: "//slow:slow_test", location: testLog + "slow/slow_test/test.log", message: "test timeout"},
+			},
+		},
+		{
+			log: "fetch_failed.log",
+			want: []want{
+				{
+					kind:     KindFetch,
+					location: "/home/user/.cache/bazel/_bazel_user/8c2e/external/bazel_tools/tools/build_defs/repo/http.bzl:132:45",
+					message:  "An error occurred during the fetch of repository 'zlib~':",
+				},
+				{kind: KindFetch, label: "@@zlib~//", message: "no such package '@@zlib~//': java.io.IOException: Error downloading"},
+				{
+					kind:     KindFetch,
+					label:    "//native:codec",
+					location: "/home/user/ws/native/BUILD:1:11",
+					message:  "//native:codec depends on @@zlib~//:zlib in repository @@zlib~ which failed to fetch.",
+				},
+			},
+		},
+		{
+			// The same failure as action_go.log, as bazel prints it on a
+			// terminal, with colors and progress messages.
+			log: "curses_go.log",
+			want: []want{{
+				kind:     KindAction,
+				label:    "//server:server",
+				location: "/home/user/ws/server/BUILD.bazel:3:11",
+				mnemonic: "GoCompilePkg",
+				message:  "GoCompilePkg server/server.a failed: (Exit 1)",
+				compiler: []string{"go: server/handler.go:14:2: undefined: writeJSON"},
+			}},
+		},
+	} {
+		t.Run(tc.log, func(t *testing.T) {
+			diagnostics, err := ReadFile(filepath.Join("testdata", tc.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diagnostics) != len(tc.want) {
+				for _, d := range diagnostics {
+					t.Logf("got %s error: %s", d.Kind, d.Message)
+				}
+				t.Fatalf("got %d diagnostics, want %d", len(diagnostics), len(tc.want))
+			}
+			for i, d := range diagnostics {
+				w := tc.want[i]
+				if d.Kind != w.kind {
+					t.Errorf("diagnostic %d: got kind %q, want %q", i, d.Kind, w.kind)
+				}
+				if d.Label != w.label {
+					t.Errorf("diagnostic %d: got label %q, want %q", i, d.Label, w.label)
+				}
+				if got := diagnosticLocation(d); got != w.location {
+					t.Errorf("diagnostic %d: got location %q, want %q", i, got, w.location)
+				}
+				if d.Mnemonic != w.mnemonic {
+					t.Errorf("diagnostic %d: got mnemonic %q, want %q", i, d.Mnemonic, w.mnemonic)
+				}
+				if !strings.HasPrefix(d.Message, w.message) {
+					t.Errorf("diagnostic %d: got message %q, want it to start with %q", i, d.Message, w.message)
+				}
+				var compiler []string
+				for _, c := range d.Compiler {
+					compiler = append(compiler, c.Language+": "+c.String())
+				}
+				if !prefixesMatch(compiler, w.compiler) {
+					t.Errorf("diagnostic %d: got compiler diagnostics\n%s\nwant\n%s", i, strings.Join(compiler, "\n"), strings.Join(w.compiler, "\n"))
+				}
+			}
+		})
+	}
+}
+
+func TestParseCompilerDetails(t *testing.T) {
+	diagnostics, err := ReadFile(filepath.Join("testdata", "action_cpp.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 1 || len(diagnostics[0].Compiler) == 0 {
+		t.Fatalf("got %v, want one action error with compiler diagnostics", diagnostics)
+	}
+	// The note about the missing include belongs to the error before it.
+	details := diagnostics[0].Compiler[0].Details
+	if len(details) != 3 || !strings.Contains(details[2], "note: 'Frame' is defined in header 'native/frame.h'") {
+		t.Errorf("got details %q, want the source line, the caret and the note", details)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	diagnostics := []*Diagnostic{
+		{Kind: KindLoading, Label: "//lib:missing", File: "/ws/app/BUILD", Line: 12, Column: 10, Message: "no such target '//lib:missing'"},
+		{Kind: KindAnalysis, Label: "//app:server", Message: "target '//internal:secret' is not visible"},
+		{
+			Kind:    KindAction,
+			Label:   "//server:server",
+			Message: "GoCompilePkg server/server.a failed: (Exit 1)",
+			Compiler: []*CompilerDiagnostic{
+				{Language: "go", File: "server/handler.go", Line: 14, Column: 2, Severity: "error", Message: "undefined: writeJSON"},
+			},
+		},
+		{Kind: KindTest, Label: "//server:server_test", File: "/logs/test.log", Message: "test failed"},
+	}
+	for _, tc := range []struct {
+		max  int
+		want string
+	}{
+		{
+			max: 10,
+			want: "  loading error: /ws/app/BUILD:12:10: no such target '//lib:missing'\n" +
+				"  analysis error: //app:server: target '//internal:secret' is not visible\n" +
+				"  action error: server/handler.go:14:2: undefined: writeJSON\n" +
+				"  test error: /logs/test.log: //server:server_test: test failed\n",
+		},
+		{
+			max: 2,
+			want: "  loading error: /ws/app/BUILD:12:10: no such target '//lib:missing'\n" +
+				"  analysis error: //app:server: target '//internal:secret' is not visible\n" +
+				"  ... and 2 more\n",
+		},
+		{max: 0, want: "  ... and 4 more\n"},
+	} {
+		t.Run(fmt.Sprint(tc.max), func(t *testing.T) {
+			if got := Summarize(diagnostics, tc.max); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+	if got := Summarize(nil, 10); got != "" {
+		t.Errorf("got %q for no diagnostics, want an empty summary", got)
+	}
+}
+
+func diagnosticLocation(d *Diagnostic) string {
+	if d.File == "" {
+		return ""
+	}
+	return location(d.File, d.Line, d.Column)
+}
+
+// prefixesMatch tells whether each string of got starts with the string of
+// want at the same position.
+func prefixesMatch(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !strings.HasPrefix(got[i], want[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package diagnostic
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Strip returns the text that the given terminal output would leave on
+// screen, without ANSI escape codes. Bazel's curses progress messages redraw
+// the bottom lines of the screen by moving the cursor up and clearing lines,
+// so this replays the cursor movements instead of dropping the escape codes,
+// which would leave every intermediate progress message in the text.
+func Strip(output string) string {
+	s := &screen{lines: [][]rune{nil}}
+	runes := []rune(output)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch r {
+		case '\x1b':
+			i = s.escape(runes, i)
+		case '\n':
+			s.row++
+			s.col = 0
+			if s.row == len(s.lines) {
+				s.lines = append(s.lines, nil)
+			}
+		case '\r':
+			s.col = 0
+		case '\b':
+			if s.col > 0 {
+				s.col--
+			}
+		case '\t':
+			s.put(' ')
+			for s.col%8 != 0 {
+				s.put(' ')
+			}
+		default:
+			if r >= ' ' {
+				s.put(r)
+			}
+		}
+	}
+	out := make([]string, len(s.lines))
+	for i, l := range s.lines {
+		out[i] = strings.TrimRight(string(l), " ")
+	}
+	return strings.Join(out, "\n")
+}
+
+// screen is a minimal terminal emulator that is just good enough to replay
+// bazel's output.
+type screen struct {
+	lines    [][]rune
+	row, col int
+}
+
+func (s *screen) put(r rune) {
+	line := s.lines[s.row]
+	for len(line) < s.col {
+		line = append(line, ' ')
+	}
+	if s.col < len(line) {
+		line[s.col] = r
+	} else {
+		line = append(line, r)
+	}
+	s.lines[s.row] = line
+	s.col++
+}
+
+// escape handles the escape sequence starting at runes[i], and returns the
+// index of its last rune.
+func (s *screen) escape(runes []rune, i int) int {
+	if i+1 >= len(runes) {
+		return i
+	}
+	switch runes[i+1] {
+	case '[':
+		// CSI: parameters, then a final byte in the range @ to ~.
+		j := i + 2
+		for j < len(runes) && (runes[j] < '@' || runes[j] > '~') {
+			j++
+		}
+		if j == len(runes) {
+			return j - 1
+		}
+		s.csi(string(runes[i+2:j]), runes[j])
+		return j
+	case ']':
+		// OSC: terminated by BEL or ST (ESC \).
+		for j := i + 2; j < len(runes); j++ {
+			if runes[j] == '\a' {
+				return j
+			}
+			if runes[j] == '\x1b' && j+1 < len(runes) && runes[j+1] == '\\' {
+				return j + 1
+			}
+		}
+		return len(runes) - 1
+	case '(', ')':
+		// Character set selection takes one more rune.
+		return min(i+2, len(runes)-1)
+	default:
+		return i + 1
+	}
+}
+
+func (s *screen) csi(params string, final rune) {
+	n, err := strconv.Atoi(strings.TrimLeft(params, "?"))
+	if err != nil {
+		n = 0
+	}
+	switch final {
+	case 'A': // cursor up
+		s.row = max(s.row-max(n, 1), 0)
+	case 'B': // cursor down
+		for i := 0; i < max(n, 1); i++ {
+			s.row++
+			if s.row == len(s.lines) {
+				s.lines = append(s.lines, nil)
+			}
+		}
+	case 'G': // cursor to column
+		s.col = max(n-1, 0)
+	case 'K': // erase in line
+		line := s.lines[s.row]
+		switch n {
+		case 0:
+			if s.col < len(line) {
+				s.lines[s.row] = line[:s.col]
+			}
+		case 2:
+			s.lines[s.row] = nil
+		}
+	case 'J': // erase in display
+		if n == 0 {
+			if s.col < len(s.lines[s.row]) {
+				s.lines[s.row] = s.lines[s.row][:s.col]
+			}
+			s.lines = s.lines[:s.row+1]
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package diagnostic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStrip(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "plain text",
+			output: "INFO: Build completed\n",
+			want:   "INFO: Build completed\n",
+		},
+		{
+			name:   "colors",
+			output: "\x1b[31m\x1b[1mERROR: \x1b[0mBuild did NOT complete successfully",
+			want:   "ERROR: Build did NOT complete successfully",
+		},
+		{
+			name:   "progress redrawn over the previous line",
+			output: "Loading: \n\x1b[1A\x1b[KLoading: 0 packages loaded\n\x1b[1A\x1b[KINFO: Analyzed target //a:b\n",
+			want:   "INFO: Analyzed target //a:b\n",
+		},
+		{
+			name:   "carriage return overwrites",
+			output: "[1 / 2] compiling\r[2 / 2] done     \n",
+			want:   "[2 / 2] done\n",
+		},
+		{
+			name:   "erase the start of a line",
+			output: "abcdef\x1b[3G\x1b[K\n",
+			want:   "ab\n",
+		},
+		{
+			name:   "erase below the cursor",
+			output: "one\ntwo\nthree\x1b[2A\r\x1b[Jfour\n",
+			want:   "four\n",
+		},
+		{
+			name:   "cursor down",
+			output: "a\x1b[2Bb",
+			want:   "a\n\n b",
+		},
+		{
+			name:   "window title",
+			output: "\x1b]0;bazel build\x07ERROR: x",
+			want:   "ERROR: x",
+		},
+		{
+			name:   "character set selection",
+			output: "\x1b(Bok",
+			want:   "ok",
+		},
+		{
+			name:   "tabs and backspaces",
+			output: "a\tb\bc",
+			want:   "a       c",
+		},
+		{
+			name:   "unterminated escape",
+			output: "ok\x1b[",
+			want:   "ok",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Strip(tc.output); got != tc.want {
+				t.Errorf("Strip(%q) = %q, want %q", tc.output, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripLog(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "curses_go.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Strip(string(b))
+	for _, line := range []string{"Loading:", "Analyzing:", "[0 / 3]", "[3 / 4]", "\x1b"} {
+		if strings.Contains(got, line) {
+			t.Errorf("got %q in the stripped log, want it redrawn", line)
+		}
+	}
+	for _, line := range []string{
+		"INFO: Analyzed target //server:server (1 packages loaded, 5 targets configured).",
+		"server/handler.go:14:2: undefined: writeJSON",
+		"Target //server:server failed to build",
+	} {
+		if !strings.Contains(got, "\n"+line+"\n") {
+			t.Errorf("got\n%s\nwant it to contain the line %q", got, line)
+		}
+	}
+}
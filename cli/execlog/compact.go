@@ -0,0 +1,306 @@
+package execlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+
+	"ok.build/cli/wire"
+)
+
+// zstdMagic starts zstd-compressed files, like the logs written by
+// --execution_log_compact_file.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Field numbers of the ExecLogEntry message in bazel's
+// src/main/protobuf/spawn.proto.
+const (
+	entryID        = 1
+	entryFile      = 3
+	entryDirectory = 4
+	entrySymlink   = 5
+	entryInputSet  = 6
+	entrySpawn     = 7
+
+	// Fields of File, Directory and UnresolvedSymlink.
+	filePath       = 1
+	fileDigest     = 2
+	directoryFiles = 2
+	digestHash     = 1
+
+	// InputSet lists the IDs of its entries in input_ids, or in bazel 7.1 in
+	// file_ids, directory_ids and unresolved_symlink_ids.
+	inputSetTransitive = 4
+
+	// Output holds the ID of an output in output_id, or in bazel 7.1 in
+	// file_id, directory_id or unresolved_symlink_id.
+	outputInvalidPath = 4
+
+	spawnArgs        = 1
+	spawnEnv         = 2
+	spawnPlatform    = 3
+	spawnInputSet    = 4
+	spawnToolSet     = 5
+	spawnOutputs     = 6
+	spawnMnemonic    = 10
+	spawnExitCode    = 11
+	spawnStatus      = 12
+	spawnRunner      = 13
+	spawnCacheHit    = 14
+	spawnTargetLabel = 18
+)
+
+// compactLog holds the entries of a compact execution log that later entries
+// refer to by ID.
+type compactLog struct {
+	// files are the files, directories and symlinks, the latter two with
+	// the files they contain.
+	files map[uint64][]*File
+	// sets are the input sets.
+	sets map[uint64]*inputSet
+}
+
+type inputSet struct {
+	inputs, transitive []uint64
+}
+
+// readCompact reads the spawns from a compact execution log, which is a zstd
+// compressed stream of length-prefixed ExecLogEntry messages. Spawns refer to
+// their inputs and outputs by the IDs of earlier entries. A log cut short by
+// an interrupted build is read up to where it ends.
+func readCompact(r io.Reader) ([]*Spawn, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	br := bufio.NewReader(dec)
+	l := &compactLog{files: map[uint64][]*File{}, sets: map[uint64]*inputSet{}}
+	var spawns []*Spawn
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return spawns, nil
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return spawns, nil
+		}
+		s, err := l.add(b)
+		if err != nil {
+			return spawns, fmt.Errorf("invalid entry in the execution log: %s", err)
+		}
+		if s != nil {
+			spawns = append(spawns, s)
+		}
+	}
+}
+
+// add records an entry of the log, and returns it if it is a spawn.
+func (l *compactLog) add(entry []byte) (*Spawn, error) {
+	var id uint64
+	var spawn *Spawn
+	err := wire.Walk(entry, func(field int, value []byte, n uint64) error {
+		var err error
+		switch field {
+		case entryID:
+			id = n
+		case entryFile:
+			var f *File
+			if f, err = readFile(value); err == nil {
+				l.files[id] = []*File{f}
+			}
+		case entryDirectory:
+			l.files[id], err = readDirectory(value)
+		case entrySymlink:
+			var f *File
+			if f, err = readFile(value); err == nil {
+				l.files[id] = []*File{f}
+			}
+		case entryInputSet:
+			l.sets[id], err = readInputSet(value)
+		case entrySpawn:
+			spawn, err = l.readSpawn(value)
+		}
+		return err
+	})
+	return spawn, err
+}
+
+func readFile(b []byte) (*File, error) {
+	f := &File{}
+	err := wire.Walk(b, func(field int, value []byte, _ uint64) error {
+		switch field {
+		case filePath:
+			f.Path = string(value)
+		case fileDigest:
+			return wire.Walk(value, func(field int, value []byte, _ uint64) error {
+				if field == digestHash {
+					f.Digest.Hash = string(value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return f, err
+}
+
+// readDirectory returns the files of a directory, with their full paths.
+func readDirectory(b []byte) ([]*File, error) {
+	dir := ""
+	var files []*File
+	err := wire.Walk(b, func(field int, value []byte, _ uint64) error {
+		switch field {
+		case filePath:
+			dir = string(value)
+		case directoryFiles:
+			f, err := readFile(value)
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+		}
+		return nil
+	})
+	for _, f := range files {
+		f.Path = path.Join(dir, f.Path)
+	}
+	return files, err
+}
+
+func readInputSet(b []byte) (*inputSet, error) {
+	set := &inputSet{}
+	err := wire.Walk(b, func(field int, value []byte, n uint64) error {
+		ids := []uint64{n}
+		if value != nil {
+			var err error
+			if ids, err = wire.Packed(value); err != nil {
+				return err
+			}
+		}
+		if field == inputSetTransitive {
+			set.transitive = append(set.transitive, ids...)
+		} else {
+			set.inputs = append(set.inputs, ids...)
+		}
+		return nil
+	})
+	return set, err
+}
+
+func (l *compactLog) readSpawn(b []byte) (*Spawn, error) {
+	s := &Spawn{}
+	var inputSetID, toolSetID uint64
+	err := wire.Walk(b, func(field int, value []byte, n uint64) error {
+		switch field {
+		case spawnArgs:
+			s.CommandArgs = append(s.CommandArgs, string(value))
+		case spawnEnv:
+			nv, err := readNameVal(value)
+			if err != nil {
+				return err
+			}
+			s.EnvironmentVariables = append(s.EnvironmentVariables, nv)
+		case spawnPlatform:
+			return wire.Walk(value, func(field int, value []byte, _ uint64) error {
+				if field != 1 {
+					return nil
+				}
+				nv, err := readNameVal(value)
+				if err != nil {
+					return err
+				}
+				s.Platform.Properties = append(s.Platform.Properties, nv)
+				return nil
+			})
+		case spawnInputSet:
+			inputSetID = n
+		case spawnToolSet:
+			toolSetID = n
+		case spawnOutputs:
+			return wire.Walk(value, func(field int, value []byte, n uint64) error {
+				if field == outputInvalidPath {
+					s.ListedOutputs = append(s.ListedOutputs, string(value))
+					return nil
+				}
+				files := l.files[n]
+				if len(files) == 1 {
+					s.ListedOutputs = append(s.ListedOutputs, files[0].Path)
+				} else if len(files) > 1 {
+					s.ListedOutputs = append(s.ListedOutputs, path.Dir(files[0].Path))
+				}
+				s.ActualOutputs = append(s.ActualOutputs, files...)
+				return nil
+			})
+		case spawnMnemonic:
+			s.Mnemonic = string(value)
+		case spawnExitCode:
+			s.ExitCode = int(int32(n))
+		case spawnStatus:
+			s.Status = string(value)
+		case spawnRunner:
+			s.Runner = string(value)
+		case spawnCacheHit:
+			s.CacheHit = n != 0
+		case spawnTargetLabel:
+			s.TargetLabel = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	tools := map[string]bool{}
+	for _, f := range l.inputs(toolSetID) {
+		tools[f.Path] = true
+	}
+	for _, f := range l.inputs(inputSetID) {
+		f := *f
+		f.IsTool = tools[f.Path]
+		s.Inputs = append(s.Inputs, &f)
+	}
+	sort.Slice(s.Inputs, func(i, j int) bool { return s.Inputs[i].Path < s.Inputs[j].Path })
+	return s, nil
+}
+
+// inputs returns the files of an input set and the sets it contains.
+func (l *compactLog) inputs(id uint64) []*File {
+	var files []*File
+	seen := map[uint64]bool{}
+	var visit func(id uint64)
+	visit = func(id uint64) {
+		set := l.sets[id]
+		if id == 0 || seen[id] || set == nil {
+			return
+		}
+		seen[id] = true
+		for _, t := range set.transitive {
+			visit(t)
+		}
+		for _, i := range set.inputs {
+			files = append(files, l.files[i]...)
+		}
+	}
+	visit(id)
+	return files
+}
+
+func readNameVal(b []byte) (*nameVal, error) {
+	nv := &nameVal{}
+	err := wire.Walk(b, func(field int, value []byte, _ uint64) error {
+		switch field {
+		case 1:
+			nv.Name = string(value)
+		case 2:
+			nv.Value = string(value)
+		}
+		return nil
+	})
+	return nv, err
+}
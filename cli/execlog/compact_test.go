@@ -0,0 +1,215 @@
+package execlog
+
+import (
+	"encoding/binary"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var update = flag.Bool("update", false, "Rewrite the execution logs in testdata.")
+
+// The logs in testdata are written by writeLogs, since bazel can't run in
+// the tests. They follow src/main/protobuf/spawn.proto: old.exec.log.zst in
+// the layout of bazel 7.1, in which input sets and outputs refer to files,
+// directories and symlinks by separate fields, and new.exec.log.zst in the
+// layout of bazel 8, which has a single field for any of them. Run
+//
+//	go test ./cli/execlog -run TestDiffCompactLogs -update
+//
+// to rewrite them.
+func TestDiffCompactLogs(t *testing.T) {
+	oldPath := filepath.Join("testdata", "old.exec.log.zst")
+	newPath := filepath.Join("testdata", "new.exec.log.zst")
+	if *update {
+		writeLogs(t, oldPath, newPath)
+	}
+	oldSpawns, err := Read(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSpawns, err := Read(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(oldSpawns) != 3 || len(newSpawns) != 4 {
+		t.Fatalf("read %d and %d spawns, want 3 and 4", len(oldSpawns), len(newSpawns))
+	}
+
+	compile := newSpawns[0]
+	if compile.Mnemonic != "GoCompilePkg" || compile.TargetLabel != "//lib:lib" || compile.Runner != "linux-sandbox" {
+		t.Errorf("read %s of %s run by %s, want GoCompilePkg of //lib:lib run by linux-sandbox", compile.Mnemonic, compile.TargetLabel, compile.Runner)
+	}
+	var inputs, tools []string
+	for _, f := range compile.Inputs {
+		inputs = append(inputs, f.Path)
+		if f.IsTool {
+			tools = append(tools, f.Path)
+		}
+	}
+	wantInputs := []string{"external/go_sdk/bin/go", "external/go_sdk/pkg/tool/compile", "lib/lib.go", "lib/util.go"}
+	if !slices.Equal(inputs, wantInputs) || !slices.Equal(tools, wantInputs[:2]) {
+		t.Errorf("read inputs %q with tools %q, want %q with tools %q", inputs, tools, wantInputs, wantInputs[:2])
+	}
+	if want := []string{"bazel-out/k8-fastbuild/bin/lib/lib.a"}; !slices.Equal(compile.ListedOutputs, want) {
+		t.Errorf("read outputs %q, want %q", compile.ListedOutputs, want)
+	}
+	if gen := oldSpawns[2]; !gen.CacheHit || gen.Executed() || len(gen.ActualOutputs) != 2 || gen.ListedOutputs[0] != "bazel-out/k8-fastbuild/bin/gen/out" {
+		t.Errorf("read the genrule as cached %t with outputs %q, want a cache hit with a directory of 2 files", gen.CacheHit, gen.ListedOutputs)
+	}
+
+	type rerun struct {
+		mnemonic string
+		reasons  []Reason
+		inputs   []string
+	}
+	var got []rerun
+	for _, r := range Diff(oldSpawns, newSpawns) {
+		var inputs []string
+		for _, c := range append(r.Inputs, r.Tools...) {
+			inputs = append(inputs, c.String())
+		}
+		got = append(got, rerun{r.Spawn.Mnemonic, r.Reasons, inputs})
+	}
+	want := []rerun{
+		{"GoCompilePkg", []Reason{Inputs}, []string{"lib/lib.go"}},
+		{"GoLink", []Reason{Inputs, Environment}, []string{"bazel-out/k8-fastbuild/bin/lib/lib.a"}},
+		{"TestRunner", []Reason{NewAction}, nil},
+	}
+	if !slices.EqualFunc(got, want, func(a, b rerun) bool {
+		return a.mnemonic == b.mnemonic && slices.Equal(a.reasons, b.reasons) && slices.Equal(a.inputs, b.inputs)
+	}) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+}
+
+// Field numbers of spawn.proto that the reader doesn't need.
+const (
+	entryInvocation  = 2
+	fileIDs          = 1 // InputSet and Output in bazel 7.1
+	directoryIDs     = 2
+	inputIDs         = 5 // InputSet and Output in bazel 8
+	digestSize       = 2
+	invocationHashFn = 1
+)
+
+// msg encodes the fields of a proto message. Values are ints, which are
+// encoded as varints, strings, or []int, which are packed.
+func msg(fields ...any) []byte {
+	var b []byte
+	for i := 0; i < len(fields); i += 2 {
+		field := uint64(fields[i].(int))
+		switch v := fields[i+1].(type) {
+		case int:
+			b = binary.AppendUvarint(b, field<<3)
+			b = binary.AppendUvarint(b, uint64(v))
+		case string:
+			b = appendBytes(b, field, []byte(v))
+		case []byte:
+			b = appendBytes(b, field, v)
+		case []int:
+			var packed []byte
+			for _, n := range v {
+				packed = binary.AppendUvarint(packed, uint64(n))
+			}
+			b = appendBytes(b, field, packed)
+		}
+	}
+	return b
+}
+
+func appendBytes(b []byte, field uint64, v []byte) []byte {
+	b = binary.AppendUvarint(b, field<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func file(path, hash string) []byte {
+	return msg(filePath, path, fileDigest, msg(digestHash, hash, digestSize, 100))
+}
+
+// writeLogs writes a build, and the next one after lib/lib.go changed and
+// the link action's PATH changed. The genrule is a cache hit in both, and the
+// test only runs in the second.
+func writeLogs(t *testing.T, oldPath, newPath string) {
+	const lib, app, gen = "bazel-out/k8-fastbuild/bin/lib/lib.a", "bazel-out/k8-fastbuild/bin/app/app", "bazel-out/k8-fastbuild/bin/gen/out"
+	for _, build := range []struct {
+		path string
+		// bazel8 selects the layout of input sets and outputs.
+		bazel8           bool
+		libHash, libPath string
+	}{
+		{oldPath, false, "1111", "/usr/bin:/bin"},
+		{newPath, true, "2222", "/usr/local/bin:/usr/bin:/bin"},
+	} {
+		files, dirs := fileIDs, directoryIDs
+		if build.bazel8 {
+			files, dirs = inputIDs, inputIDs
+		}
+		entries := [][]byte{
+			msg(entryInvocation, msg(invocationHashFn, "SHA-256")),
+			msg(entryID, 1, entryFile, file("external/go_sdk/bin/go", "aaaa")),
+			msg(entryID, 2, entryFile, file("external/go_sdk/pkg/tool/compile", "bbbb")),
+			msg(entryID, 3, entryInputSet, msg(files, []int{1, 2})),
+			msg(entryID, 4, entryFile, file("lib/lib.go", build.libHash)),
+			msg(entryID, 5, entryFile, file("lib/util.go", "cccc")),
+			msg(entryID, 6, entryInputSet, msg(files, []int{4, 5}, inputSetTransitive, []int{3})),
+			msg(entryID, 7, entryFile, file(lib, build.libHash+"00")),
+			msg(entrySpawn, msg(
+				spawnArgs, "bazel-out/k8-opt-exec/bin/builder", spawnArgs, "compilepkg",
+				spawnInputSet, 6, spawnToolSet, 3,
+				spawnOutputs, msg(files, 7),
+				spawnMnemonic, "GoCompilePkg", spawnRunner, "linux-sandbox", spawnStatus, "SUCCESS",
+				spawnTargetLabel, "//lib:lib")),
+			msg(entryID, 8, entryFile, file("app/main.go", "dddd")),
+			msg(entryID, 9, entryInputSet, msg(files, []int{7, 8})),
+			msg(entryID, 10, entryFile, file(app, build.libHash+"01")),
+			msg(entrySpawn, msg(
+				spawnArgs, "bazel-out/k8-opt-exec/bin/builder", spawnArgs, "link",
+				spawnEnv, msg(1, "PATH", 2, build.libPath),
+				spawnInputSet, 9,
+				spawnOutputs, msg(files, 10),
+				spawnMnemonic, "GoLink", spawnRunner, "linux-sandbox",
+				spawnTargetLabel, "//app:app")),
+			msg(entryID, 11, entryDirectory, msg(filePath, gen, directoryFiles, file("a.txt", "eeee"), directoryFiles, file("b.txt", "ffff"))),
+			msg(entrySpawn, msg(
+				spawnArgs, "/bin/bash", spawnArgs, "-c", spawnArgs, "gen.sh",
+				spawnOutputs, msg(dirs, 11),
+				spawnMnemonic, "Genrule", spawnRunner, "remote cache hit", spawnCacheHit, 1,
+				spawnTargetLabel, "//gen:gen")),
+		}
+		if build.bazel8 {
+			entries = append(entries,
+				msg(entryID, 12, entryInputSet, msg(inputIDs, []int{10})),
+				msg(entrySpawn, msg(
+					spawnArgs, "bazel-out/k8-fastbuild/bin/app/app_test",
+					spawnInputSet, 12,
+					spawnOutputs, msg(outputInvalidPath, "bazel-out/k8-fastbuild/testlogs/app/app_test/test.xml"),
+					spawnMnemonic, "TestRunner", spawnRunner, "linux-sandbox", spawnExitCode, 0,
+					spawnTargetLabel, "//app:app_test")))
+		}
+
+		f, err := os.Create(build.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := zstd.NewWriter(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			w.Write(binary.AppendUvarint(nil, uint64(len(e))))
+			w.Write(e)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package execlog
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"ok.build/cli/format"
+)
+
+const (
+	// maxCauses is how many changed files are listed.
+	maxCauses = 10
+	// maxDetails is how many changes of each kind are listed per action.
+	maxDetails = 5
+)
+
+// Reason is why an action ran again.
+type Reason string
+
+const (
+	NewAction   Reason = "new action"
+	Inputs      Reason = "inputs changed"
+	Toolchain   Reason = "toolchain changed"
+	CommandLine Reason = "command line changed"
+	Environment Reason = "environment changed"
+	Platform    Reason = "execution platform changed"
+	// Unexplained is for actions that ran again although nothing in the log
+	// changed, for example because their outputs were deleted or evicted from
+	// the cache, or because the action is not cacheable.
+	Unexplained Reason = "nothing in the log changed"
+)
+
+// reasons is the order in which reasons are listed.
+var reasons = []Reason{NewAction, Inputs, Toolchain, CommandLine, Environment, Platform, Unexplained}
+
+// Rerun is an action of the new log that ran, and what changed since the old
+// log.
+type Rerun struct {
+	Spawn *Spawn
+	// Old is the same action in the old log, or nil for new actions.
+	Old     *Spawn
+	Reasons []Reason
+	// Inputs are the input files that were added, removed or changed, with
+	// the tools and toolchain files among them in Tools.
+	Inputs, Tools []*InputChange
+	// Args are the arguments that were removed from the command line,
+	// prefixed with "-", and added to it, prefixed with "+".
+	Args []string
+	// Env and PlatformProperties describe the environment variables and
+	// platform properties that changed, like "PATH: /bin → /usr/bin".
+	Env, PlatformProperties []string
+}
+
+// InputChange is an input file that differs between the logs. Digests are
+// empty for files that were added or removed.
+type InputChange struct {
+	Path      string
+	OldDigest string
+	NewDigest string
+}
+
+func (c *InputChange) String() string {
+	switch {
+	case c.OldDigest == "":
+		return c.Path + " (added)"
+	case c.NewDigest == "":
+		return c.Path + " (removed)"
+	}
+	return c.Path
+}
+
+// Diff returns the actions that ran in the new log, with what changed since
+// the old log. Actions taken from a cache aren't included.
+func Diff(oldSpawns, newSpawns []*Spawn) []*Rerun {
+	byKey := map[string]*Spawn{}
+	for _, s := range oldSpawns {
+		byKey[s.Key()] = s
+	}
+	var reruns []*Rerun
+	for _, s := range newSpawns {
+		if !s.Executed() {
+			continue
+		}
+		r := &Rerun{Spawn: s, Old: byKey[s.Key()]}
+		if r.Old == nil {
+			r.Reasons = []Reason{NewAction}
+			reruns = append(reruns, r)
+			continue
+		}
+		r.diffInputs()
+		r.Args = diffArgs(r.Old.CommandArgs, s.CommandArgs)
+		r.Env = diffNameVals(r.Old.EnvironmentVariables, s.EnvironmentVariables)
+		r.PlatformProperties = diffNameVals(r.Old.Platform.Properties, s.Platform.Properties)
+		for reason, changed := range map[Reason]bool{
+			Inputs:      len(r.Inputs) > 0,
+			Toolchain:   len(r.Tools) > 0,
+			CommandLine: len(r.Args) > 0,
+			Environment: len(r.Env) > 0,
+			Platform:    len(r.PlatformProperties) > 0,
+		} {
+			if changed {
+				r.Reasons = append(r.Reasons, reason)
+			}
+		}
+		if len(r.Reasons) == 0 {
+			r.Reasons = []Reason{Unexplained}
+		}
+		sort.Slice(r.Reasons, func(i, j int) bool {
+			return slices.Index(reasons, r.Reasons[i]) < slices.Index(reasons, r.Reasons[j])
+		})
+		reruns = append(reruns, r)
+	}
+	return reruns
+}
+
+// diffInputs sets the changed inputs of r, sorting tools and toolchain files
+// into Tools.
+func (r *Rerun) diffInputs() {
+	oldInputs := map[string]*File{}
+	for _, f := range r.Old.Inputs {
+		oldInputs[f.Path] = f
+	}
+	add := func(c *InputChange, tool bool) {
+		if tool || isToolchain(c.Path) {
+			r.Tools = append(r.Tools, c)
+		} else {
+			r.Inputs = append(r.Inputs, c)
+		}
+	}
+	for _, f := range r.Spawn.Inputs {
+		o := oldInputs[f.Path]
+		delete(oldInputs, f.Path)
+		switch {
+		case o == nil:
+			add(&InputChange{Path: f.Path, NewDigest: f.Digest.Hash}, f.IsTool)
+		case o.Digest.Hash != f.Digest.Hash:
+			add(&InputChange{Path: f.Path, OldDigest: o.Digest.Hash, NewDigest: f.Digest.Hash}, f.IsTool || o.IsTool)
+		}
+	}
+	for _, o := range r.Old.Inputs {
+		if _, removed := oldInputs[o.Path]; removed {
+			add(&InputChange{Path: o.Path, OldDigest: o.Digest.Hash}, o.IsTool)
+		}
+	}
+}
+
+// isToolchain returns whether path is in an external repository that holds a
+// toolchain, like external/local_config_cc or external/go_sdk.
+func isToolchain(path string) bool {
+	path = strings.TrimPrefix(path, "external/")
+	repo, _, _ := strings.Cut(path, "/")
+	repo = strings.ToLower(repo)
+	for _, s := range []string{"toolchain", "local_config_", "_sdk", "sdk_", "jdk", "llvm", "clang", "gcc", "nodejs_", "python_3", "rust_"} {
+		if strings.Contains(repo, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffArgs returns the arguments of old that aren't in new and those of new
+// that aren't in old.
+func diffArgs(oldArgs, newArgs []string) []string {
+	if slices.Equal(oldArgs, newArgs) {
+		return nil
+	}
+	var diff []string
+	for _, a := range oldArgs {
+		if !slices.Contains(newArgs, a) {
+			diff = append(diff, "- "+a)
+		}
+	}
+	for _, a := range newArgs {
+		if !slices.Contains(oldArgs, a) {
+			diff = append(diff, "+ "+a)
+		}
+	}
+	if len(diff) == 0 {
+		diff = []string{"(the arguments were reordered)"}
+	}
+	return diff
+}
+
+func diffNameVals(oldVals, newVals []*nameVal) []string {
+	oldByName := map[string]string{}
+	for _, v := range oldVals {
+		oldByName[v.Name] = v.Value
+	}
+	var diff []string
+	for _, v := range newVals {
+		o, ok := oldByName[v.Name]
+		delete(oldByName, v.Name)
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("%s: set to %q", v.Name, v.Value))
+		case o != v.Value:
+			diff = append(diff, fmt.Sprintf("%s: %q → %q", v.Name, o, v.Value))
+		}
+	}
+	for _, v := range oldVals {
+		if _, removed := oldByName[v.Name]; removed {
+			diff = append(diff, fmt.Sprintf("%s: unset, was %q", v.Name, v.Value))
+		}
+	}
+	return diff
+}
+
+// Cause is a changed file that isn't an output of an action in the log, such
+// as a source file, with the number of actions that ran again because of it.
+type Cause struct {
+	Path    string
+	Actions int
+}
+
+// Causes returns the changed files that aren't outputs of other actions,
+// which are what caused the reruns, with those that affected most actions
+// first.
+func Causes(reruns []*Rerun, spawns []*Spawn) []*Cause {
+	outputs := map[string]bool{}
+	for _, s := range spawns {
+		for _, o := range s.ListedOutputs {
+			outputs[o] = true
+		}
+		for _, o := range s.ActualOutputs {
+			outputs[o.Path] = true
+		}
+	}
+	counts := map[string]int{}
+	for _, r := range reruns {
+		for _, c := range append(slices.Clone(r.Inputs), r.Tools...) {
+			if !outputs[c.Path] {
+				counts[c.Path]++
+			}
+		}
+	}
+	var causes []*Cause
+	for path, n := range counts {
+		causes = append(causes, &Cause{Path: path, Actions: n})
+	}
+	sort.Slice(causes, func(i, j int) bool {
+		if causes[i].Actions != causes[j].Actions {
+			return causes[i].Actions > causes[j].Actions
+		}
+		return causes[i].Path < causes[j].Path
+	})
+	return causes
+}
+
+// WriteDiff writes why the actions of the new log ran again to w. At most
+// maxReruns actions are described in detail, or all of them if maxReruns is
+// negative.
+func WriteDiff(w io.Writer, oldInv, newInv *Invocation, oldSpawns, newSpawns []*Spawn, maxReruns int) {
+	fmt.Fprintf(w, "Comparing %s\n     with %s\n", newInv, oldInv)
+	reruns := Diff(oldSpawns, newSpawns)
+	if len(reruns) == 0 {
+		fmt.Fprintf(w, "\nNo actions ran; all %s were cached.\n", format.Plural(len(newSpawns), "action"))
+		return
+	}
+	counts := map[Reason]int{}
+	for _, r := range reruns {
+		for _, reason := range r.Reasons {
+			counts[reason]++
+		}
+	}
+	fmt.Fprintf(w, "\n%s of %d ran:\n", format.Plural(len(reruns), "action"), len(newSpawns))
+	for _, reason := range reasons {
+		if counts[reason] > 0 {
+			fmt.Fprintf(w, "  %5d  %s\n", counts[reason], reason)
+		}
+	}
+
+	if causes := Causes(reruns, newSpawns); len(causes) > 0 {
+		fmt.Fprintln(w, "\nChanged files that caused them:")
+		for _, c := range causes[:min(len(causes), maxCauses)] {
+			fmt.Fprintf(w, "  %5d  %s\n", c.Actions, c.Path)
+		}
+		if len(causes) > maxCauses {
+			fmt.Fprintf(w, "  … and %s more\n", format.Plural(len(causes)-maxCauses, "file"))
+		}
+	}
+
+	shown := reruns
+	if maxReruns >= 0 && len(shown) > maxReruns {
+		shown = shown[:maxReruns]
+	}
+	fmt.Fprintln(w, "\nActions:")
+	for _, r := range shown {
+		writeRerun(w, r)
+	}
+	if len(shown) < len(reruns) {
+		fmt.Fprintf(w, "\n… and %s more. Pass --all to list them.\n", format.Plural(len(reruns)-len(shown), "action"))
+	}
+}
+
+func writeRerun(w io.Writer, r *Rerun) {
+	s := r.Spawn
+	fmt.Fprintf(w, "\n  %s %s  %s\n", s.TargetLabel, s.Mnemonic, s.Key())
+	if r.Old == nil {
+		fmt.Fprintln(w, "    new action: not in the other log")
+		return
+	}
+	details := func(what string, items []string) {
+		for _, item := range items[:min(len(items), maxDetails)] {
+			fmt.Fprintf(w, "    %s: %s\n", what, item)
+		}
+		if len(items) > maxDetails {
+			fmt.Fprintf(w, "    … and %d more\n", len(items)-maxDetails)
+		}
+	}
+	details("input", inputStrings(r.Inputs))
+	details("toolchain", inputStrings(r.Tools))
+	details("argument", r.Args)
+	details("env", r.Env)
+	details("platform", r.PlatformProperties)
+	if slices.Contains(r.Reasons, Unexplained) {
+		fmt.Fprintf(w, "    %s; its outputs may have been deleted or it may not be cacheable\n", Unexplained)
+	}
+}
+
+func inputStrings(changes []*InputChange) []string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.String())
+	}
+	return s
+}
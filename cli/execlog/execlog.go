@@ -0,0 +1,98 @@
+// Package execlog keeps bazel's execution logs, which describe every action
+// that bazel ran, and compares them to explain why actions ran again.
+package execlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Spawn is an action that bazel ran, or looked up in a cache. Its fields
+// follow the log written by --execution_log_json_file; compact logs are
+// converted to it.
+type Spawn struct {
+	CommandArgs          []string   `json:"commandArgs"`
+	EnvironmentVariables []*nameVal `json:"environmentVariables"`
+	Platform             struct {
+		Properties []*nameVal `json:"properties"`
+	} `json:"platform"`
+	Inputs        []*File  `json:"inputs"`
+	ListedOutputs []string `json:"listedOutputs"`
+	ActualOutputs []*File  `json:"actualOutputs"`
+	Mnemonic      string   `json:"mnemonic"`
+	TargetLabel   string   `json:"targetLabel"`
+	Runner        string   `json:"runner"`
+	CacheHit      bool     `json:"cacheHit"`
+	Status        string   `json:"status"`
+	ExitCode      int      `json:"exitCode"`
+}
+
+// File is an input or output of a spawn.
+type File struct {
+	Path   string `json:"path"`
+	Digest struct {
+		Hash string `json:"hash"`
+	} `json:"digest"`
+	IsTool bool `json:"isTool"`
+}
+
+type nameVal struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Key identifies the spawn across builds by its first output, since the
+// outputs of an action don't change unless its target's configuration does.
+func (s *Spawn) Key() string {
+	if len(s.ListedOutputs) > 0 {
+		return s.ListedOutputs[0]
+	}
+	if len(s.ActualOutputs) > 0 {
+		return s.ActualOutputs[0].Path
+	}
+	return s.Mnemonic + " " + strings.Join(s.CommandArgs, " ")
+}
+
+// Executed returns whether the spawn ran, rather than being taken from a
+// cache.
+func (s *Spawn) Executed() bool {
+	return !s.CacheHit && !strings.Contains(s.Runner, "cache hit")
+}
+
+// Read reads the spawns from an execution log, which is either a compact log
+// or a JSON log, which is a stream of JSON objects. A log cut short by an
+// interrupted build is read up to where it ends.
+func Read(path string) ([]*Spawn, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(len(zstdMagic)); bytes.Equal(magic, zstdMagic) {
+		spawns, err := readCompact(r)
+		if err != nil {
+			return spawns, fmt.Errorf("failed to read %s: %s", path, err)
+		}
+		return spawns, nil
+	}
+	var spawns []*Spawn
+	dec := json.NewDecoder(r)
+	for {
+		s := &Spawn{}
+		err := dec.Decode(s)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return spawns, nil
+		}
+		if err != nil {
+			return spawns, fmt.Errorf("failed to read %s: %s", path, err)
+		}
+		spawns = append(spawns, s)
+	}
+}
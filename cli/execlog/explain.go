@@ -0,0 +1,88 @@
+package execlog
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// defaultMaxReruns is how many actions are described unless --all is passed.
+const defaultMaxReruns = 20
+
+var (
+	Flags = flag.NewFlagSet("explain-rebuild", flag.ContinueOnError)
+
+	all  = Flags.Bool("all", false, "Describe every action that ran, not just the first 20.")
+	list = Flags.Bool("list", false, "List the invocations whose execution logs were kept.")
+)
+
+const Description = `
+Explains why actions ran again, by comparing the execution logs of two bazel
+invocations. ok asks bazel for an execution log on every build, run, test and
+coverage command, with --execution_log_compact_file, or
+--execution_log_json_file before bazel 7.1, and keeps the logs of the last 10
+invocations in each workspace.
+
+For each action that ran rather than being taken from a cache, the report
+shows whether it is new, or which of its input digests, environment
+variables, command line arguments or toolchain files changed. It also lists
+the changed source files that caused the most actions to run.
+
+Invocations are numbered back from the latest, which is 1, and can also be
+given as the path of an execution log. By default the latest invocation is
+compared with the one before it:
+
+  ok explain-rebuild          # compares 1 with 2
+  ok explain-rebuild 3 1      # compares 1 with 3
+  ok explain-rebuild --list   # lists the kept invocations
+
+Set the explain.execution_log config key to false to stop writing execution
+logs, which can be large, and explain.keep to change how many are kept.
+`
+
+// HandleExplainRebuild handles the `ok explain-rebuild` command.
+func HandleExplainRebuild(args []string) (exitCode int, err error) {
+	invocations, err := List()
+	if err != nil {
+		return 1, err
+	}
+	if *list {
+		if len(invocations) == 0 {
+			fmt.Println("No execution logs have been kept yet.")
+		}
+		for i, inv := range invocations {
+			fmt.Printf("%3d  %s\n", i+1, inv)
+		}
+		return 0, nil
+	}
+	oldRef, newRef := "2", "1"
+	switch len(args) {
+	case 0:
+	case 1:
+		oldRef = args[0]
+	default:
+		oldRef, newRef = args[0], args[1]
+	}
+	oldInv, err := find(invocations, oldRef)
+	if err != nil {
+		return 1, err
+	}
+	newInv, err := find(invocations, newRef)
+	if err != nil {
+		return 1, err
+	}
+	oldSpawns, err := Read(oldInv.Log)
+	if err != nil {
+		return 1, err
+	}
+	newSpawns, err := Read(newInv.Log)
+	if err != nil {
+		return 1, err
+	}
+	maxReruns := defaultMaxReruns
+	if *all {
+		maxReruns = -1
+	}
+	WriteDiff(os.Stdout, oldInv, newInv, oldSpawns, newSpawns, maxReruns)
+	return 0, nil
+}
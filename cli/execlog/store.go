@@ -0,0 +1,201 @@
+package execlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/bazelisk"
+	"ok.build/cli/config"
+	"ok.build/cli/log"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// logName and commandName are the names of an invocation's execution log
+	// and command line in its directory. The log is a compact one, or a JSON
+	// one before bazel 7.1; Read tells them apart.
+	logName     = "exec.log"
+	commandName = "command.txt"
+
+	// defaultKeep is how many logs are kept per workspace when the
+	// explain.keep config key isn't set.
+	defaultKeep = 10
+)
+
+// loggedCommands are the bazel commands whose execution logs are kept.
+var loggedCommands = []string{"build", "coverage", "run", "test"}
+
+// Invocation is a bazel command whose execution log was kept.
+type Invocation struct {
+	// Dir holds the log and the command line, for logs that ok kept.
+	Dir string
+	// Log is the path of the execution log.
+	Log  string
+	Time time.Time
+	// Command is the bazel command line, if known.
+	Command string
+}
+
+// String describes the invocation, like
+// "build //... (2006-01-02 15:04:05)".
+func (inv *Invocation) String() string {
+	what := inv.Command
+	if what == "" {
+		what = inv.Log
+	}
+	return fmt.Sprintf("%s (%s)", what, inv.Time.Format(time.DateTime))
+}
+
+// AddFlag asks bazel to write an execution log to a new directory for the
+// invocation, unless the explain.execution_log config key is false, the
+// command doesn't run actions, or args already ask for an execution log. It
+// returns the updated args and the directory, which is empty if no log is
+// kept.
+func AddFlag(args []string) ([]string, string) {
+	command, idx := arg.GetCommandAndIndex(args)
+	if !slices.Contains(loggedCommands, command) || !config.GetBool("explain.execution_log", true) {
+		return args, ""
+	}
+	for _, name := range []string{"execution_log_json_file", "execution_log_binary_file", "execution_log_compact_file"} {
+		if _, i, _ := arg.Find(arg.GetBazelArgs(args), name); i >= 0 {
+			return args, ""
+		}
+	}
+	root, err := logsDir()
+	if err != nil {
+		log.Debugf("Not keeping the execution log: %s", err)
+		return args, ""
+	}
+	dir := filepath.Join(root, time.Now().Format("20060102-150405.000"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Debugf("Not keeping the execution log: %s", err)
+		return args, ""
+	}
+	if err := os.WriteFile(filepath.Join(dir, commandName), []byte(strings.Join(args, " ")+"\n"), 0644); err != nil {
+		log.Debugf("Not keeping the execution log: %s", err)
+		return args, ""
+	}
+	option := "--execution_log_compact_file="
+	if !writesCompactLogs() {
+		option = "--execution_log_json_file="
+	}
+	return slices.Insert(args, idx+1, option+filepath.Join(dir, logName)), dir
+}
+
+// writesCompactLogs tells whether the bazel version that runs writes compact
+// execution logs, which bazel 7.1 added. Versions that aren't numbers, like
+// "latest", are taken to be recent.
+func writesCompactLogs() bool {
+	version, err := bazelisk.GetBazelVersion()
+	if err != nil {
+		log.Debugf("Failed to get the bazel version: %s", err)
+		return true
+	}
+	major, rest, _ := strings.Cut(version, ".")
+	minor, _, _ := strings.Cut(rest, ".")
+	m, err := strconv.Atoi(major)
+	if err != nil {
+		return true
+	}
+	if m != 7 {
+		return m > 7
+	}
+	n, err := strconv.Atoi(minor)
+	return err != nil || n >= 1
+}
+
+// Finish removes the invocation's directory if bazel didn't write a log, for
+// example because loading failed, and removes old logs so that only the
+// number given by the explain.keep config key are kept.
+func Finish(dir string) {
+	if dir == "" {
+		return
+	}
+	if fi, err := os.Stat(filepath.Join(dir, logName)); err != nil || fi.Size() == 0 {
+		os.RemoveAll(dir)
+	}
+	invocations, err := List()
+	if err != nil {
+		return
+	}
+	keep := config.GetInt("explain.keep", defaultKeep)
+	for _, inv := range invocations[min(len(invocations), keep):] {
+		if err := os.RemoveAll(inv.Dir); err != nil {
+			log.Debugf("Failed to remove %s: %s", inv.Dir, err)
+		}
+	}
+}
+
+// List returns the invocations of the workspace whose execution logs were
+// kept, newest first.
+func List() ([]*Invocation, error) {
+	root, err := logsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var invocations []*Invocation
+	for _, e := range entries {
+		t, err := time.ParseInLocation("20060102-150405.000", e.Name(), time.Local)
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		inv := &Invocation{Dir: dir, Log: filepath.Join(dir, logName), Time: t}
+		if b, err := os.ReadFile(filepath.Join(inv.Dir, commandName)); err == nil {
+			inv.Command = strings.TrimSpace(string(b))
+		}
+		invocations = append(invocations, inv)
+	}
+	sort.Slice(invocations, func(i, j int) bool { return invocations[i].Time.After(invocations[j].Time) })
+	return invocations, nil
+}
+
+// logsDir returns the directory that the workspace's logs are kept in.
+func logsDir() (string, error) {
+	ws, err := workspace.Path()
+	if err != nil {
+		return "", err
+	}
+	okDir, err := config.OkDir()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(ws))
+	return filepath.Join(okDir, "execlogs", hex.EncodeToString(h[:8])), nil
+}
+
+// find returns the invocation that ref refers to: a number counting back from
+// the latest invocation, which is 1, or the path of a log or its directory.
+func find(invocations []*Invocation, ref string) (*Invocation, error) {
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(invocations) {
+			return nil, fmt.Errorf("there is no invocation %d; ok has kept the execution logs of %d", n, len(invocations))
+		}
+		return invocations[n-1], nil
+	}
+	fi, err := os.Stat(ref)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		inv := &Invocation{Dir: ref, Log: filepath.Join(ref, logName), Time: fi.ModTime()}
+		if b, err := os.ReadFile(filepath.Join(ref, commandName)); err == nil {
+			inv.Command = strings.TrimSpace(string(b))
+		}
+		return inv, nil
+	}
+	return &Invocation{Log: ref, Time: fi.ModTime()}, nil
+}
@@ -0,0 +1,23 @@
+package execlog
+
+import "testing"
+
+func TestWritesCompactLogs(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    bool
+	}{
+		{"6.5.0", false},
+		{"7.0.2", false},
+		{"7.1.0", true},
+		{"7.4.1", true},
+		{"8.0.0rc2", true},
+		{"7.x", true},
+		{"latest", true},
+	} {
+		t.Setenv("USE_BAZEL_VERSION", tc.version)
+		if got := writesCompactLogs(); got != tc.want {
+			t.Errorf("writesCompactLogs() with bazel %s = %t, want %t", tc.version, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package fixer proposes deterministic fixes for common bazel errors, such as
+// a missing dep, which can be applied without asking an agent.
+package fixer
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"ok.build/cli/bazelisk"
+	"ok.build/cli/buildfile"
+	"ok.build/cli/diagnostic"
+	"ok.build/cli/workspace"
+)
+
+// Fix is a concrete change that should fix an error.
+type Fix struct {
+	// Description says what the fix does, like
+	// "Add //foo:bar to deps of //baz:qux".
+	Description string
+
+	Apply func() error
+}
+
+// Fixer recognizes a kind of error and proposes fixes for it.
+type Fixer struct {
+	Name string
+
+	// Propose returns fixes for the diagnostic, or nil if the fixer doesn't
+	// recognize it.
+	Propose func(d *diagnostic.Diagnostic) []*Fix
+}
+
+// Fixers lists every known fixer, in the order their fixes are proposed.
+var Fixers = []*Fixer{
+	javaStrictDeps,
+	goStrictDeps,
+	visibility,
+	missingLoad,
+	unknownRepo,
+	staleLockfile,
+}
+
+// Propose returns the fixes that the fixers propose for the diagnostics.
+// Fixes with the same description are only proposed once.
+func Propose(diagnostics []*diagnostic.Diagnostic) []*Fix {
+	var fixes []*Fix
+	seen := map[string]bool{}
+	for _, d := range diagnostics {
+		for _, f := range Fixers {
+			for _, fix := range f.Propose(d) {
+				if !seen[fix.Description] {
+					seen[fix.Description] = true
+					fixes = append(fixes, fix)
+				}
+			}
+		}
+	}
+	return fixes
+}
+
+// editRule returns a fix that edits the declaration of a target in the main
+// repository.
+func editRule(description, label string, edit func(f *buildfile.File, rule *buildfile.Call) error) *Fix {
+	return &Fix{
+		Description: description,
+		Apply: func() error {
+			pkg, name, err := splitLabel(label)
+			if err != nil {
+				return err
+			}
+			ws, err := workspace.Path()
+			if err != nil {
+				return err
+			}
+			path := buildfile.Find(filepath.Join(ws, pkg))
+			if path == "" {
+				return fmt.Errorf("no BUILD file found for %s", label)
+			}
+			f, err := buildfile.Read(path)
+			if err != nil {
+				return err
+			}
+			rule := f.Rule(name)
+			if rule == nil {
+				return fmt.Errorf("%s is not declared in %s", label, path)
+			}
+			if err := edit(f, rule); err != nil {
+				return err
+			}
+			return f.Save()
+		},
+	}
+}
+
+// MarkFlaky returns a fix that marks a test as flaky, so that bazel retries
+// it when it fails.
+func MarkFlaky(label string) *Fix {
+	return editRule(fmt.Sprintf("Mark %s as flaky", label), label, func(f *buildfile.File, rule *buildfile.Call) error {
+		f.SetAttr(rule, "flaky", "True")
+		return nil
+	})
+}
+
+// addToList returns a fix that adds values to a list attribute of a target.
+func addToList(label, attr string, values ...string) *Fix {
+	description := fmt.Sprintf("Add %s to %s of %s", strings.Join(values, ", "), attr, label)
+	return editRule(description, label, func(f *buildfile.File, rule *buildfile.Call) error {
+		return f.AddToList(rule, attr, values...)
+	})
+}
+
+// addDeps returns a fix that adds deps to a target, using relative labels
+// for deps in the same package.
+func addDeps(label string, deps ...string) *Fix {
+	pkg, _, _ := splitLabel(label)
+	relative := make([]string, len(deps))
+	for i, dep := range deps {
+		relative[i] = dep
+		if depPkg, name, err := splitLabel(dep); err == nil && depPkg == pkg {
+			relative[i] = ":" + name
+		}
+	}
+	fix := addToList(label, "deps", relative...)
+	fix.Description = fmt.Sprintf("Add %s to deps of %s", strings.Join(deps, ", "), label)
+	return fix
+}
+
+// runBazel returns a fix that runs a bazel command. Bazel may only use
+// files it already downloaded, so that the fix works offline; if it needs
+// more, the command fails rather than fetching them.
+func runBazel(description string, args ...string) *Fix {
+	return &Fix{
+		Description: description,
+		Apply: func() error {
+			args := append(args, "--experimental_repository_disable_download")
+			exitCode, err := bazelisk.Run(args, &bazelisk.RunOpts{Stdout: os.Stderr, Stderr: os.Stderr})
+			if err != nil {
+				return err
+			}
+			if exitCode != 0 {
+				return fmt.Errorf("bazel %s failed with exit code %d", strings.Join(args, " "), exitCode)
+			}
+			return nil
+		},
+	}
+}
+
+// splitLabel splits a main repository label into its package and name.
+func splitLabel(label string) (pkg, name string, err error) {
+	l := strings.TrimPrefix(strings.TrimPrefix(label, "@@"), "@")
+	if !strings.HasPrefix(l, "//") {
+		return "", "", fmt.Errorf("%s is not in the main repository", label)
+	}
+	pkg, name, ok := strings.Cut(l[2:], ":")
+	if !ok {
+		name = path.Base(pkg)
+	}
+	return pkg, name, nil
+}
+
+// isMainRepoLabel returns whether label is in the main repository.
+func isMainRepoLabel(label string) bool {
+	_, _, err := splitLabel(label)
+	return err == nil
+}
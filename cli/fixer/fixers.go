@@ -0,0 +1,312 @@
+package fixer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"ok.build/cli/buildfile"
+	"ok.build/cli/diagnostic"
+	"ok.build/cli/log"
+	"ok.build/cli/workspace"
+)
+
+var (
+	// javaStrictDeps handles javac's strict deps errors, which list the
+	// missing deps like
+	//
+	//	** Please add the following dependencies:
+	//	  //other:foo to //pkg:a
+	javaStrictDeps = &Fixer{
+		Name: "java-strict-deps",
+		Propose: func(d *diagnostic.Diagnostic) []*Fix {
+			var fixes []*Fix
+			for _, c := range d.Compiler {
+				if c.Language != "java" {
+					continue
+				}
+				adding := false
+				for _, line := range c.Details {
+					if strings.Contains(line, "Please add the following dependencies") {
+						adding = true
+						continue
+					}
+					if !adding {
+						continue
+					}
+					m := javaMissingDepPattern.FindStringSubmatch(line)
+					if m == nil {
+						adding = false
+						continue
+					}
+					if isMainRepoLabel(m[2]) {
+						fixes = append(fixes, addDeps(m[2], m[1]))
+					}
+				}
+			}
+			return fixes
+		},
+	}
+	javaMissingDepPattern = regexp.MustCompile(`^\s*(\S+) to (\S+)\s*$`)
+
+	// goStrictDeps handles rules_go's strict deps errors, like
+	//
+	//	compilepkg: missing strict dependencies:
+	//		/path/pkg/a.go: import of "example.com/foo"
+	//
+	// by looking up the go_library with that import path in the BUILD files.
+	goStrictDeps = &Fixer{
+		Name: "go-strict-deps",
+		Propose: func(d *diagnostic.Diagnostic) []*Fix {
+			if !strings.Contains(d.Output, "missing strict dependencies") || !isMainRepoLabel(d.Label) {
+				return nil
+			}
+			var deps []string
+			for _, m := range goImportPattern.FindAllStringSubmatch(d.Output, -1) {
+				dep, err := goLibraryForImportPath(m[1])
+				if err != nil {
+					log.Debugf("Failed to find the target for %q: %s", m[1], err)
+					return nil
+				}
+				deps = append(deps, dep)
+			}
+			if len(deps) == 0 {
+				return nil
+			}
+			return []*Fix{addDeps(d.Label, deps...)}
+		},
+	}
+	goImportPattern = regexp.MustCompile(`: import of "([^"]+)"`)
+
+	// visibility handles targets that depend on targets they can't see, by
+	// making the dependency visible to the package of the dependent.
+	visibility = &Fixer{
+		Name: "visibility",
+		Propose: func(d *diagnostic.Diagnostic) []*Fix {
+			m := notVisiblePattern.FindStringSubmatch(d.Message)
+			if m == nil || !isMainRepoLabel(m[1]) {
+				return nil
+			}
+			dep, from := m[1], m[2]
+			pkg, _, err := splitLabel(from)
+			if err != nil {
+				return nil
+			}
+			visibleTo := "//" + pkg + ":__pkg__"
+			description := fmt.Sprintf("Add %s to visibility of %s", visibleTo, dep)
+			return []*Fix{editRule(description, dep, func(f *buildfile.File, rule *buildfile.Call) error {
+				name := f.StringAttr(rule, "name")
+				// A visibility attribute replaces the package's default
+				// visibility, so keep what the default allowed.
+				if rule.Attr("visibility") == nil {
+					if p := f.Call("package"); p != nil {
+						if defaults, ok := f.ListAttr(p, "default_visibility"); ok {
+							if err := f.AddToList(rule, "visibility", defaults...); err != nil {
+								return err
+							}
+						}
+					}
+				}
+				f.RemoveFromList(f.Rule(name), "visibility", "//visibility:private")
+				return f.AddToList(f.Rule(name), "visibility", visibleTo)
+			})}
+		},
+	}
+	notVisiblePattern = regexp.MustCompile(`target '(\S+)' is not visible from target '(\S+)'`)
+
+	// missingLoad handles rules and macros that are used without being
+	// loaded, loading them from where other BUILD files in the workspace do,
+	// or else from where well-known rules are defined.
+	missingLoad = &Fixer{
+		Name: "missing-load",
+		Propose: func(d *diagnostic.Diagnostic) []*Fix {
+			m := notDefinedPattern.FindStringSubmatch(d.Message)
+			if m == nil || d.File == "" {
+				return nil
+			}
+			symbol := m[1]
+			module := findLoad(symbol)
+			if module == "" {
+				return nil
+			}
+			file := d.File
+			rel := file
+			if ws, err := workspace.Path(); err == nil {
+				if r, err := filepath.Rel(ws, file); err == nil {
+					rel = r
+				}
+			}
+			return []*Fix{{
+				Description: fmt.Sprintf("Load %s from %s in %s", symbol, module, rel),
+				Apply: func() error {
+					f, err := buildfile.Read(file)
+					if err != nil {
+						return err
+					}
+					f.AddLoad(module, symbol)
+					return f.Save()
+				},
+			}}
+		},
+	}
+	notDefinedPattern = regexp.MustCompile(`name '(\w+)' is not defined`)
+
+	// wellKnownLoads says where to load common rules from, for workspaces
+	// that don't already load them.
+	wellKnownLoads = map[string]string{
+		"go_binary":     "@rules_go//go:def.bzl",
+		"go_library":    "@rules_go//go:def.bzl",
+		"go_test":       "@rules_go//go:def.bzl",
+		"cc_binary":     "@rules_cc//cc:defs.bzl",
+		"cc_import":     "@rules_cc//cc:defs.bzl",
+		"cc_library":    "@rules_cc//cc:defs.bzl",
+		"cc_test":       "@rules_cc//cc:defs.bzl",
+		"java_binary":   "@rules_java//java:defs.bzl",
+		"java_import":   "@rules_java//java:defs.bzl",
+		"java_library":  "@rules_java//java:defs.bzl",
+		"java_test":     "@rules_java//java:defs.bzl",
+		"py_binary":     "@rules_python//python:defs.bzl",
+		"py_library":    "@rules_python//python:defs.bzl",
+		"py_test":       "@rules_python//python:defs.bzl",
+		"sh_binary":     "@rules_shell//shell:sh_binary.bzl",
+		"sh_library":    "@rules_shell//shell:sh_library.bzl",
+		"sh_test":       "@rules_shell//shell:sh_test.bzl",
+		"proto_library": "@protobuf//bazel:proto_library.bzl",
+		"ts_project":    "@aspect_rules_ts//ts:defs.bzl",
+		"js_library":    "@aspect_rules_js//js:defs.bzl",
+	}
+
+	// unknownRepo handles repositories that are used without being visible
+	// to the main repository, which usually means that a use_repo() call in
+	// MODULE.bazel is missing them.
+	unknownRepo = &Fixer{
+		Name: "unknown-repo",
+		Propose: func(d *diagnostic.Diagnostic) []*Fix {
+			for _, p := range unknownRepoPatterns {
+				if m := p.FindStringSubmatch(d.Message); m != nil {
+					return []*Fix{runBazel(fmt.Sprintf("Make @%s visible by running `bazel mod tidy`", m[1]), "mod", "tidy")}
+				}
+			}
+			return nil
+		},
+	}
+	unknownRepoPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`No repository visible as '@([\w.~+-]+)' from main repository`),
+		regexp.MustCompile(`Unable to find package for @([\w.~+-]+)//`),
+		regexp.MustCompile(`[Tt]he repository '@([\w.~+-]+)' could not be resolved`),
+	}
+
+	// staleLockfile handles MODULE.bazel.lock files that are out of date when
+	// bazel runs with --lockfile_mode=error.
+	staleLockfile = &Fixer{
+		Name: "stale-lockfile",
+		Propose: func(d *diagnostic.Diagnostic) []*Fix {
+			if !strings.Contains(d.Message, "MODULE.bazel.lock") || !strings.Contains(d.Message, "up-to-date") {
+				return nil
+			}
+			return []*Fix{runBazel("Update MODULE.bazel.lock by running `bazel mod deps --lockfile_mode=update`", "mod", "deps", "--lockfile_mode=update")}
+		},
+	}
+)
+
+// maxBuildFilesScanned bounds how many BUILD files are read when looking for
+// a target or a load.
+const maxBuildFilesScanned = 2000
+
+// goLibraryForImportPath finds the go_library with the given import path in
+// the main repository, by reading its BUILD files rather than querying bazel,
+// so that proposing the fix is instant and works offline.
+func goLibraryForImportPath(importPath string) (string, error) {
+	ws, err := workspace.Path()
+	if err != nil {
+		return "", err
+	}
+	pattern := regexp.MustCompile(`importpath\s*=\s*"` + regexp.QuoteMeta(importPath) + `"`)
+	label := ""
+	walkBuildFiles(ws, func(path string) bool {
+		if findInFile(path, pattern) == "" {
+			return true
+		}
+		f, err := buildfile.Read(path)
+		if err != nil {
+			return true
+		}
+		for _, c := range f.Calls() {
+			if c.Kind == "go_library" && f.StringAttr(c, "importpath") == importPath {
+				pkg, _ := filepath.Rel(ws, filepath.Dir(path))
+				if pkg == "." {
+					pkg = ""
+				}
+				label = "//" + filepath.ToSlash(pkg) + ":" + f.StringAttr(c, "name")
+				return false
+			}
+		}
+		return true
+	})
+	if label == "" {
+		return "", fmt.Errorf("no go_library has importpath %q", importPath)
+	}
+	return label, nil
+}
+
+// findLoad returns the module that symbol is loaded from by the workspace's
+// BUILD files, or else from where well-known rules are defined.
+func findLoad(symbol string) string {
+	if ws, err := workspace.Path(); err == nil {
+		pattern := regexp.MustCompile(`load\(\s*"([^"]+)"[^)]*"` + regexp.QuoteMeta(symbol) + `"`)
+		module := ""
+		walkBuildFiles(ws, func(path string) bool {
+			module = findInFile(path, pattern)
+			return module == ""
+		})
+		if module != "" {
+			return module
+		}
+	}
+	return wellKnownLoads[symbol]
+}
+
+// walkBuildFiles calls visit with the path of each BUILD file in the
+// workspace, up to maxBuildFilesScanned of them, until visit returns false.
+func walkBuildFiles(ws string, visit func(path string) bool) {
+	scanned := 0
+	filepath.WalkDir(ws, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if scanned == maxBuildFilesScanned {
+			return filepath.SkipAll
+		}
+		if d.IsDir() {
+			if path != ws && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "bazel-") || d.Name() == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "BUILD" && d.Name() != "BUILD.bazel" {
+			return nil
+		}
+		scanned++
+		if !visit(path) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+func findInFile(path string, pattern *regexp.Regexp) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if m := pattern.FindSubmatch(b); m != nil {
+		if len(m) > 1 {
+			return string(m[1])
+		}
+		return string(m[0])
+	}
+	return ""
+}
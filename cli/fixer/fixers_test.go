@@ -0,0 +1,252 @@
+package fixer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ok.build/cli/diagnostic"
+)
+
+// ws is the workspace that the tests run in. It is shared by the tests, since
+// the workspace is only looked up once, so each test uses its own packages.
+var ws string
+
+func TestMain(m *testing.M) {
+	var err error
+	ws, err = os.MkdirTemp("", "ws")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "MODULE.bazel"), nil, 0644); err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(ws); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(ws)
+	os.Exit(code)
+}
+
+// writeFiles writes files to the workspace, by their paths relative to it.
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		path = filepath.Join(ws, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// apply applies the fixes that the fixer proposes for the diagnostic, which
+// must be described by want, and returns the content of the file at path
+// afterwards.
+func apply(t *testing.T, f *Fixer, d *diagnostic.Diagnostic, path string, want ...string) string {
+	t.Helper()
+	fixes := f.Propose(d)
+	if len(fixes) != len(want) {
+		t.Fatalf("%s proposed %d fixes, want %q", f.Name, len(fixes), want)
+	}
+	for i, fix := range fixes {
+		if fix.Description != want[i] {
+			t.Errorf("got fix %q, want %q", fix.Description, want[i])
+		}
+		if err := fix.Apply(); err != nil {
+			t.Fatalf("%s failed: %s", fix.Description, err)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(ws, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestJavaStrictDeps(t *testing.T) {
+	writeFiles(t, map[string]string{
+		"java/app/BUILD": `java_library(
+    name = "app",
+    srcs = ["App.java"],
+    deps = [":util"],
+)
+`,
+	})
+	d := &diagnostic.Diagnostic{Label: "//java/app:app", Compiler: []*diagnostic.CompilerDiagnostic{{
+		Language: "java",
+		File:     "java/app/App.java",
+		Message:  "[strict] Using type Strings from an indirect dependency (TOOL_INFO: \"//java/lib:strings\").",
+		Details: []string{
+			"** Please add the following dependencies:",
+			"  //java/lib:strings to //java/app:app",
+			"  //java/app:model to //java/app:app",
+			"** You can use the following buildozer command:",
+			"buildozer 'add deps //java/lib:strings //java/app:model' //java/app:app",
+		},
+	}}}
+	got := apply(t, javaStrictDeps, d, "java/app/BUILD",
+		"Add //java/lib:strings to deps of //java/app:app",
+		"Add //java/app:model to deps of //java/app:app")
+	want := `java_library(
+    name = "app",
+    srcs = ["App.java"],
+    deps = [":model", ":util", "//java/lib:strings"],
+)
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGoStrictDeps(t *testing.T) {
+	writeFiles(t, map[string]string{
+		"go/lib/BUILD": `go_library(
+    name = "lib",
+    srcs = ["lib.go"],
+    importpath = "example.com/go/lib",
+)
+`,
+		"go/app/BUILD": `go_binary(
+    name = "app",
+    srcs = ["main.go"],
+)
+`,
+	})
+	d := &diagnostic.Diagnostic{
+		Label:  "//go/app:app",
+		Output: "compilepkg: missing strict dependencies:\n\t/sandbox/go/app/main.go: import of \"example.com/go/lib\"\nNo dependencies were provided.\n",
+	}
+	got := apply(t, goStrictDeps, d, "go/app/BUILD", "Add //go/lib:lib to deps of //go/app:app")
+	want := `go_binary(
+    name = "app",
+    srcs = ["main.go"],
+    deps = ["//go/lib:lib"],
+)
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	d.Output = "compilepkg: missing strict dependencies:\n\t/sandbox/go/app/main.go: import of \"example.com/unknown\"\n"
+	if fixes := goStrictDeps.Propose(d); len(fixes) != 0 {
+		t.Errorf("got fix %q for an import path that no go_library has", fixes[0].Description)
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	writeFiles(t, map[string]string{
+		"vis/lib/BUILD": `package(default_visibility = ["//vis/internal:__pkg__"])
+
+cc_library(
+    name = "lib",
+)
+
+cc_library(
+    name = "private",
+    visibility = ["//visibility:private"],
+)
+`,
+	})
+	message := func(dep string) *diagnostic.Diagnostic {
+		return &diagnostic.Diagnostic{
+			Label:   "//vis/app:app",
+			Message: "in cc_binary rule //vis/app:app: target '" + dep + "' is not visible from target '//vis/app:app'. Check the visibility declaration of the former target if you think the dependency is legitimate",
+		}
+	}
+	apply(t, visibility, message("//vis/lib:lib"), "vis/lib/BUILD", "Add //vis/app:__pkg__ to visibility of //vis/lib:lib")
+	got := apply(t, visibility, message("//vis/lib:private"), "vis/lib/BUILD", "Add //vis/app:__pkg__ to visibility of //vis/lib:private")
+	want := `package(default_visibility = ["//vis/internal:__pkg__"])
+
+cc_library(
+    name = "lib",
+    visibility = ["//vis/app:__pkg__", "//vis/internal:__pkg__"],
+)
+
+cc_library(
+    name = "private",
+    visibility = ["//vis/app:__pkg__"],
+)
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMissingLoad(t *testing.T) {
+	writeFiles(t, map[string]string{
+		"load/lib/BUILD": "load(\"@io_bazel_rules_go//go:def.bzl\", \"go_library\", \"go_test\")\n",
+		"load/app/BUILD": `"""The app."""
+
+go_test(name = "app_test")
+
+sh_test(name = "smoke_test")
+`,
+	})
+	file := filepath.Join(ws, "load/app/BUILD")
+	apply(t, missingLoad, &diagnostic.Diagnostic{File: file, Message: "name 'go_test' is not defined"}, "load/app/BUILD",
+		"Load go_test from @io_bazel_rules_go//go:def.bzl in load/app/BUILD")
+	// Rules that no BUILD file loads are loaded from where they are defined.
+	got := apply(t, missingLoad, &diagnostic.Diagnostic{File: file, Message: "name 'sh_test' is not defined"}, "load/app/BUILD",
+		"Load sh_test from @rules_shell//shell:sh_test.bzl in load/app/BUILD")
+	want := `"""The app."""
+
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+load("@rules_shell//shell:sh_test.bzl", "sh_test")
+
+go_test(name = "app_test")
+
+sh_test(name = "smoke_test")
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if fixes := missingLoad.Propose(&diagnostic.Diagnostic{File: file, Message: "name 'my_macro' is not defined"}); len(fixes) != 0 {
+		t.Errorf("got fix %q for a symbol that nothing loads", fixes[0].Description)
+	}
+}
+
+// The fixes of unknownRepo and staleLockfile run bazel, so only what they
+// propose is tested.
+func TestUnknownRepo(t *testing.T) {
+	for _, message := range []string{
+		"No repository visible as '@rules_foo' from main repository",
+		"no such package '@rules_foo//lib': The repository '@rules_foo' could not be resolved: Repository '@rules_foo' is not defined",
+	} {
+		fixes := unknownRepo.Propose(&diagnostic.Diagnostic{Message: message})
+		if want := "Make @rules_foo visible by running `bazel mod tidy`"; len(fixes) != 1 || fixes[0].Description != want {
+			t.Errorf("got %d fixes for %q, want %q", len(fixes), message, want)
+		}
+	}
+}
+
+func TestStaleLockfile(t *testing.T) {
+	fixes := staleLockfile.Propose(&diagnostic.Diagnostic{Message: "MODULE.bazel.lock is no longer up-to-date because: the root MODULE.bazel has been modified"})
+	if want := "Update MODULE.bazel.lock by running `bazel mod deps --lockfile_mode=update`"; len(fixes) != 1 || fixes[0].Description != want {
+		t.Errorf("got %d fixes, want %q", len(fixes), want)
+	}
+	if fixes := staleLockfile.Propose(&diagnostic.Diagnostic{Message: "MODULE.bazel.lock: permission denied"}); len(fixes) != 0 {
+		t.Errorf("got fix %q for another error about the lockfile", fixes[0].Description)
+	}
+}
+
+func TestMarkFlaky(t *testing.T) {
+	writeFiles(t, map[string]string{
+		"flaky/BUILD": "sh_test(\n    name = \"net_test\",\n    size = \"small\",  # fast\n)\n",
+	})
+	fix := MarkFlaky("//flaky:net_test")
+	if err := fix.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(ws, "flaky/BUILD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "sh_test(\n    name = \"net_test\",\n    size = \"small\",  # fast\n    flaky = True,\n)\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,272 @@
+// Package flakes reruns failed tests to tell flaky tests from tests that
+// fail consistently.
+package flakes
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/bazelisk"
+	"ok.build/cli/bep"
+	"ok.build/cli/config"
+	"ok.build/cli/diagnostic"
+	"ok.build/cli/format"
+	"ok.build/cli/log"
+)
+
+// Class is how a test behaves when it's run repeatedly.
+type Class string
+
+const (
+	// Consistent tests fail every run.
+	Consistent Class = "consistently failing"
+	// Flaky tests pass some runs and fail others.
+	Flaky Class = "flaky"
+	// TimeoutSensitive tests pass some runs and only fail the others by
+	// timing out, which usually means that they're too slow for their size or
+	// depend on the machine's load. Tests that time out every run are
+	// consistently failing.
+	TimeoutSensitive Class = "timeout-sensitive"
+)
+
+// Result is how a test did over the reruns.
+type Result struct {
+	Label string
+	Class Class
+
+	Runs     int
+	Passed   int
+	Failed   int
+	TimedOut int
+
+	// RunResults has the results of each run, ordered by run and shard.
+	RunResults []*bep.TestResult
+}
+
+// PassRate returns the fraction of runs that passed.
+func (r *Result) PassRate() float64 {
+	if r.Runs == 0 {
+		return 0
+	}
+	return float64(r.Passed) / float64(r.Runs)
+}
+
+// String describes the result on one line, like
+// "//pkg:foo_test is flaky: passed 3 of 5 runs (60%)".
+func (r *Result) String() string {
+	switch r.Class {
+	case Consistent:
+		return fmt.Sprintf("%s is consistently failing: failed all %d runs", r.Label, r.Runs)
+	case TimeoutSensitive:
+		return fmt.Sprintf("%s is timeout-sensitive: timed out in %d of %d runs", r.Label, r.TimedOut, r.Runs)
+	default:
+		return fmt.Sprintf("%s is flaky: passed %d of %d runs (%.0f%%)", r.Label, r.Passed, r.Runs, 100*r.PassRate())
+	}
+}
+
+// FailedTests returns the labels of the failed tests, from the build events
+// or else from bazel's output.
+func FailedTests(inv *bep.Invocation, diagnostics []*diagnostic.Diagnostic) []string {
+	var labels []string
+	if inv != nil {
+		for _, t := range inv.FailedTargets() {
+			if t.TestSummary != nil || len(t.TestResults) > 0 {
+				labels = append(labels, t.Label)
+			}
+		}
+	}
+	if len(labels) > 0 {
+		return labels
+	}
+	for _, d := range diagnostics {
+		if d.Kind == diagnostic.KindTest && d.Label != "" && !slices.Contains(labels, d.Label) {
+			labels = append(labels, d.Label)
+		}
+	}
+	return labels
+}
+
+// Detect reruns the tests runs times each, with the options of the original
+// test command but without cached results or retries, and classifies them.
+// Bazel's output is shown as the tests run. The rerun's build events are
+// returned too, since they describe the latest test logs.
+func Detect(args, labels []string, runs int, tempDir string) ([]*Result, *bep.Invocation, error) {
+	eventsFile := filepath.Join(tempDir, "flakes_build_events.json")
+	rerun := rerunArgs(args, labels, runs, eventsFile)
+	log.Printf("Rerunning %s %d times to detect flakes.", format.Plural(len(labels), "failed test"), runs)
+	log.Debugf("Running bazel %s", strings.Join(rerun, " "))
+	if _, err := bazelisk.RunWithLogFile(rerun, filepath.Join(tempDir, "flakes.log")); err != nil {
+		return nil, nil, err
+	}
+	inv, err := bep.ReadFile(eventsFile)
+	if err != nil {
+		if inv == nil {
+			return nil, nil, fmt.Errorf("failed to read build events of the rerun: %s", err)
+		}
+		log.Debugf("Failed to read all build events of the rerun: %s", err)
+	}
+	return Classify(inv, labels), inv, nil
+}
+
+// rerunArgs returns args with the target patterns replaced by labels and the
+// options that make bazel run each test runs times.
+func rerunArgs(args, labels []string, runs int, eventsFile string) []string {
+	args = arg.RemoveTargets(args)
+	for _, name := range []string{"build_event_json_file", "runs_per_test", "flaky_test_attempts", "cache_test_results"} {
+		for {
+			_, i, _ := arg.Find(args, name)
+			if i < 0 {
+				break
+			}
+			_, args = arg.Pop(args, name)
+		}
+	}
+	args = append(args,
+		fmt.Sprintf("--runs_per_test=%d", runs),
+		"--cache_test_results=no",
+		"--flaky_test_attempts=1",
+		"--build_event_json_file="+eventsFile,
+		"--")
+	return append(args, labels...)
+}
+
+// Classify classifies the tests by their results in inv. A run of a sharded
+// test passes if all of its shards pass.
+func Classify(inv *bep.Invocation, labels []string) []*Result {
+	var results []*Result
+	for _, label := range labels {
+		r := &Result{Label: label}
+		if inv != nil {
+			if t := inv.Target(label); t != nil {
+				r.RunResults = append(r.RunResults, t.TestResults...)
+			}
+		}
+		sort.SliceStable(r.RunResults, func(i, j int) bool {
+			a, b := r.RunResults[i], r.RunResults[j]
+			if a.Run != b.Run {
+				return a.Run < b.Run
+			}
+			return a.Shard < b.Shard
+		})
+		runs := map[int]string{}
+		for _, tr := range r.RunResults {
+			status := tr.Status
+			if prev, ok := runs[tr.Run]; ok && worse(prev, status) {
+				status = prev
+			}
+			runs[tr.Run] = status
+		}
+		for _, status := range runs {
+			r.Runs++
+			switch status {
+			case "PASSED", "FLAKY":
+				r.Passed++
+			case "TIMEOUT":
+				r.TimedOut++
+			default:
+				r.Failed++
+			}
+		}
+		switch {
+		case r.Runs == 0:
+			// The test didn't run again, for example because it no longer
+			// builds, so treat it like a test that always fails.
+			r.Class = Consistent
+		case r.TimedOut > 0 && r.Failed == 0 && r.Passed > 0:
+			r.Class = TimeoutSensitive
+		case r.Passed > 0:
+			r.Class = Flaky
+		default:
+			r.Class = Consistent
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// worse returns whether status a is worse than b, so that a run of a sharded
+// test fails if any shard fails.
+func worse(a, b string) bool {
+	rank := func(s string) int {
+		switch s {
+		case "PASSED", "FLAKY":
+			return 0
+		case "TIMEOUT":
+			return 1
+		default:
+			return 2
+		}
+	}
+	return rank(a) > rank(b)
+}
+
+// Summary describes the results, one test per line.
+func Summary(results []*Result) string {
+	var b strings.Builder
+	b.WriteString("Flake detection results:\n")
+	for _, r := range results {
+		fmt.Fprintf(&b, "  %s\n", r)
+	}
+	return b.String()
+}
+
+// Report is a detailed report of the results, with each run's status,
+// duration and log.
+func Report(args []string, results []*Result) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Flake report\n\nbazel %s\n", strings.Join(args, " "))
+	for _, r := range results {
+		fmt.Fprintf(&b, "\n## %s\n\n%s.\n\n", r.Label, strings.TrimPrefix(r.String(), r.Label+" is "))
+		sharded := slices.ContainsFunc(r.RunResults, func(tr *bep.TestResult) bool { return tr.Shard > 1 })
+		for _, tr := range r.RunResults {
+			fmt.Fprintf(&b, "- run %d", tr.Run)
+			if sharded {
+				fmt.Fprintf(&b, ", shard %d", tr.Shard)
+			}
+			fmt.Fprintf(&b, ": %s in %s", strings.ToLower(tr.Status), tr.Duration.Round(time.Millisecond))
+			if tr.LogPath != "" {
+				fmt.Fprintf(&b, " (%s)", tr.LogPath)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// WriteReport writes the report to the flakes directory in ~/.ok, where it
+// outlives the command, and returns its path.
+func WriteReport(args []string, results []*Result) (string, error) {
+	okDir, err := config.OkDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(okDir, "flakes")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, time.Now().Format("20060102-150405")+".md")
+	if err := os.WriteFile(path, []byte(Report(args, results)), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// OpenReport shows the report at path with $PAGER, or less.
+func OpenReport(path string) error {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	cmd := exec.Command("sh", "-c", pager+` "$0"`, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,46 @@
+package flakes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"ok.build/cli/bep"
+)
+
+func TestClassify(t *testing.T) {
+	// The statuses of each test, by run and then by shard.
+	runs := map[string][][]string{
+		"//:flaky":   {{"PASSED"}, {"FAILED"}, {"PASSED"}},
+		"//:slow":    {{"PASSED"}, {"TIMEOUT"}, {"PASSED"}},
+		"//:hangs":   {{"TIMEOUT"}, {"TIMEOUT"}, {"TIMEOUT"}},
+		"//:broken":  {{"FAILED"}, {"TIMEOUT"}, {"FAILED"}},
+		"//:sharded": {{"PASSED", "FAILED"}, {"PASSED", "PASSED"}, {"FAILED", "PASSED"}},
+	}
+	var events strings.Builder
+	for label, shards := range runs {
+		for run, statuses := range shards {
+			for shard, status := range statuses {
+				fmt.Fprintf(&events, `{"id": {"testResult": {"label": %q, "run": %d, "shard": %d, "attempt": 1}}, "testResult": {"status": %q}}`+"\n", label, run+1, shard+1, status)
+			}
+		}
+	}
+	inv, err := bep.Read(strings.NewReader(events.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range Classify(inv, []string{"//:flaky", "//:slow", "//:hangs", "//:broken", "//:sharded", "//:gone"}) {
+		want := map[string]Class{
+			"//:flaky":   Flaky,
+			"//:slow":    TimeoutSensitive,
+			"//:hangs":   Consistent,
+			"//:broken":  Consistent,
+			"//:sharded": Flaky,
+			"//:gone":    Consistent,
+		}[r.Label]
+		if r.Class != want {
+			t.Errorf("%s is %s, want %s", r, r.Class, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Package format formats counts and durations for the reports that ok prints.
+package format
+
+import (
+	"strconv"
+	"time"
+)
+
+// Duration rounds d to a precision that suits its size, like 350ms, 4.2s or
+// 3m12s.
+func Duration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+// Number formats n with thousands separators, like 2,134.
+func Number(n int) string {
+	if n < 0 {
+		return "-" + Number(-n)
+	}
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// Plural formats a count of things, like "1 test" or "2,134 tests".
+func Plural(n int, what string) string {
+	if n == 1 {
+		return "1 " + what
+	}
+	return Number(n) + " " + what + "s"
+}
@@ -6,11 +6,14 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 
 	"ok.build/cli/arg"
 	"ok.build/cli/bazelisk"
 	"ok.build/cli/command"
+	"ok.build/cli/plugin"
+	"ok.build/cli/shortcuts"
 )
 
 const (
@@ -26,7 +29,7 @@ var (
 )
 
 // HandleHelp Valid cases to trigger help:
-// * ok (no additional command passed)
+// * ok (no additional command passed, and no `default.command` configured)
 // * ok help
 // * ok help `command name`
 // * ok -h `command name`
@@ -38,28 +41,61 @@ func HandleHelp(args []string) (exitCode int, err error) {
 
 	// Returns first non-flag
 	cmd, idx := arg.GetCommandAndIndex(args)
-	// If no command is specified, show general help.
-	// TODO: Allow configuring a "default command" that is run when
-	// no args are passed? Like `build //...`
+	// If no command is specified, show general help. When a default command
+	// is configured, this is only reached with -h or --help.
 	if idx == -1 {
 		return showHelp("", getHelpModifiers(args))
 	}
 	if cmd == "help" {
 		helpTopic := arg.GetCommand(args[idx+1:])
+		if c := command.GetCommand(helpTopic); c != nil {
+			if c.External != "" {
+				return c.Handler([]string{"--help"})
+			}
+			c.WriteHelp(os.Stdout)
+			return 0, nil
+		}
+		if slices.Contains(command.BazelCommands, helpTopic) {
+			return showCommandHelp(helpTopic, getHelpModifiers(args))
+		}
 		return showHelp(helpTopic, getHelpModifiers(args))
 	}
 	if arg.ContainsExact(args, "-h") || arg.ContainsExact(args, "--help") {
-		// bazelCommand, _ := parser.GetBazelCommandAndIndex(args)
-		// // Sanity check to work around potential issues with
-		// // GetBazelCommandAndIndex (see TODOs on that func).
-		// if cmd != bazelCommand {
-		// 	return -1, nil
-		// }
-		// return showHelp(bazelCommand, getHelpModifiers(args))
+		// ok commands, including external ones, handle -h and --help
+		// themselves. Anything that isn't a bazel command is left for bazel
+		// to reject.
+		if command.GetCommand(cmd) != nil || !slices.Contains(command.BazelCommands, cmd) {
+			return -1, nil
+		}
+		return showCommandHelp(cmd, getHelpModifiers(args))
 	}
 	return -1, nil
 }
 
+// showCommandHelp shows bazel's help for a bazel command, followed by the ok
+// options, shortcuts and plugins that apply to it.
+func showCommandHelp(bazelCommand string, modifiers []string) (exitCode int, err error) {
+	exitCode, err = showHelp(bazelCommand, modifiers)
+	if err != nil || exitCode != 0 {
+		return exitCode, err
+	}
+	fmt.Println()
+	printOkOptions()
+	if s := shortcuts.ForCommand(bazelCommand); len(s) > 0 {
+		fmt.Printf("ok shortcuts for %s:\n", bazelCommand)
+		printSorted(s)
+	}
+	plugins := plugin.Load()
+	if len(plugins) > 0 {
+		fmt.Println("ok plugins (run around every bazel command):")
+		for _, p := range plugins {
+			fmt.Printf("  %s  %s\n", padEnd(p.Name(), 18), strings.Join(p.Hooks(), ", "))
+		}
+		fmt.Println()
+	}
+	return 0, nil
+}
+
 func showHelp(subcommand string, modifiers []string) (exitCode int, err error) {
 	bazelArgs := []string{"help"}
 	if subcommand != "" {
@@ -119,9 +155,52 @@ func showHelp(subcommand string, modifiers []string) (exitCode int, err error) {
 func printBBCommands() {
 	fmt.Println("ok commands:")
 	for _, c := range command.Commands {
+		if c.Hidden {
+			continue
+		}
 		fmt.Printf("  %s  %s\n", padEnd(c.Name, 18), c.Help)
 	}
 	fmt.Println()
+	if external := command.ExternalCommands(); len(external) > 0 {
+		fmt.Println("external commands:")
+		for _, c := range external {
+			fmt.Printf("  %s  %s\n", padEnd(c.Name, 18), c.External)
+		}
+		fmt.Println()
+	}
+	printAliases()
+	printOkOptions()
+}
+
+func printOkOptions() {
+	fmt.Println("ok options:")
+	for _, f := range command.GlobalFlags {
+		fmt.Printf("  %s  %s\n", padEnd(f.Usage(), 16), f.Help)
+	}
+	fmt.Println()
+}
+
+func printAliases() {
+	aliases := shortcuts.Aliases()
+	if len(aliases) == 0 {
+		return
+	}
+	fmt.Println("ok aliases:")
+	printSorted(aliases)
+}
+
+// printSorted prints the entries of m sorted by key, followed by a blank
+// line.
+func printSorted(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Printf("  %s  %s\n", padEnd(k, 18), m[k])
+	}
+	fmt.Println()
 }
 
 func getHelpModifiers(args []string) []string {
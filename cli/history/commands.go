@@ -0,0 +1,297 @@
+package history
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/format"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// defaultLimit is how many entries `ok history` lists unless -n is
+	// passed.
+	defaultLimit = 20
+	// logTail is how many lines of the log `ok last` shows unless --log is
+	// passed.
+	logTail = 20
+)
+
+var (
+	Flags = flag.NewFlagSet("history", flag.ContinueOnError)
+
+	failed        = Flags.Bool("failed", false, "Only list commands that failed.")
+	commandFilter = Flags.String("command", "", "Only list invocations of this bazel command, like build or test.")
+	allWorkspaces = Flags.Bool("all", false, "List the commands of every workspace, not just the current one.")
+	limit         = Flags.Int("n", defaultLimit, "How many commands to list. 0 lists all of them.")
+)
+
+var (
+	LastFlags = flag.NewFlagSet("last", flag.ContinueOnError)
+
+	fullLog           = LastFlags.Bool("log", false, "Print the whole log rather than its last lines.")
+	lastAllWorkspaces = LastFlags.Bool("all", false, "Count back through the commands of every workspace, not just the current one.")
+)
+
+var (
+	RerunFlags = flag.NewFlagSet("rerun", flag.ContinueOnError)
+
+	rerunAllWorkspaces = RerunFlags.Bool("all", false, "Count back through the commands of every workspace, not just the current one.")
+)
+
+var (
+	FixFlags = flag.NewFlagSet("fix", flag.ContinueOnError)
+
+	fixAllWorkspaces = FixFlags.Bool("all", false, "Count back through the commands of every workspace, not just the current one.")
+)
+
+const Description = `
+Lists the bazel commands that ok ran in the current workspace, newest first,
+with when they ran, how long they took and how they ended. Commands whose
+failure an agent worked on are marked with "agent".
+
+Pass words to only list the commands that contain all of them:
+
+  ok history --failed test //foo
+
+Each command's output, build events and the context given to the agent are
+kept under ~/.ok/history. The history.keep config key sets how many commands
+are kept (default 100); set it to 0 to keep none.
+
+Commands are numbered back from the latest, which is 1. Use the number or the
+command's ID with ok last, ok rerun and ok fix. Numbers from ok history --all
+count through every workspace, so pass --all to those commands as well.
+`
+
+const LastDescription = `
+Shows a command from the history: the command line, the directory it ran in,
+how it ended, a summary of its build events, the end of its output and the
+agent session that worked on its failure, if any. Without an argument, the
+latest command in the workspace is shown.
+`
+
+const RerunDescription = `
+Runs a command from the history again, with the same arguments and in the
+same directory. Without an argument, the latest command in the workspace is
+rerun.
+`
+
+const FixDescription = `
+Offers to fix the failure of an earlier command, the same way ok does right
+after a command fails: with deterministic fixes, the failing test cases, and
+the agent, which is given the command's errors and their context. Without an
+argument, the latest failed command in the workspace is fixed.
+
+If an agent already worked on the failure, ok fix offers to continue its
+session, so that the agent picks up where it left off.
+`
+
+// HandleHistory handles the `ok history` command.
+func HandleHistory(args []string) (exitCode int, err error) {
+	entries, err := workspaceEntries(*allWorkspaces)
+	if err != nil {
+		return 1, err
+	}
+	shown := 0
+	for i, e := range entries {
+		if *limit > 0 && shown == *limit {
+			break
+		}
+		if !matches(e, args) {
+			continue
+		}
+		shown++
+		status := "ok"
+		if e.Failed() {
+			status = fmt.Sprintf("exit %d", e.ExitCode)
+		}
+		agent := ""
+		if e.SessionID != "" {
+			agent = "  (agent)"
+		}
+		fmt.Printf("%4d  %s  %8s  %-7s  %s%s\n", i+1, e.Start.Format(time.DateTime), format.Duration(e.Duration), status, e.Command(), agent)
+	}
+	if shown == 0 {
+		fmt.Println("No commands found.")
+	}
+	return 0, nil
+}
+
+// matches returns whether e passes the filters given by the flags and words.
+func matches(e *Entry, words []string) bool {
+	if *failed && !e.Failed() {
+		return false
+	}
+	if *commandFilter != "" && arg.GetCommand(e.BazelArgs) != *commandFilter {
+		return false
+	}
+	command := e.Command()
+	for _, w := range words {
+		if !strings.Contains(command, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleLast handles the `ok last` command.
+func HandleLast(args []string) (exitCode int, err error) {
+	e, err := entryArg(args, *lastAllWorkspaces, false)
+	if err != nil {
+		return 1, err
+	}
+	fmt.Printf("Command:   %s\n", e.Command())
+	if len(e.BazelArgs) > 0 {
+		fmt.Printf("Bazel:     bazel %s\n", arg.JoinShell(e.BazelArgs))
+	}
+	fmt.Printf("Directory: %s\n", e.Cwd)
+	fmt.Printf("Started:   %s, took %s\n", e.Start.Format(time.DateTime), format.Duration(e.Duration))
+	fmt.Printf("Exit code: %d\n", e.ExitCode)
+	if s := e.Summary; s != nil {
+		fmt.Printf("Targets:   %d, %d failed\n", s.Targets, len(s.FailedTargets))
+		if s.Tests > 0 {
+			fmt.Printf("Tests:     %d, %d failed\n", s.Tests, s.FailedTests)
+		}
+		if s.ActionsExecuted > 0 {
+			fmt.Printf("Actions:   %d executed\n", s.ActionsExecuted)
+		}
+		for _, t := range s.FailedTargets {
+			fmt.Printf("  failed: %s\n", t)
+		}
+	}
+	if e.SessionID != "" {
+		fmt.Printf("Agent:     %s session %s\n", e.Agent, e.SessionID)
+	}
+	fmt.Printf("Files:     %s\n", e.Dir)
+
+	f, err := os.Open(e.Log)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	defer f.Close()
+	if *fullLog {
+		fmt.Println()
+		_, err := io.Copy(os.Stdout, f)
+		return 0, err
+	}
+	lines, err := tail(f, logTail)
+	if err != nil {
+		return 1, err
+	}
+	if len(lines) > 0 {
+		fmt.Printf("\nEnd of the output (pass --log for all of it):\n")
+		for _, l := range lines {
+			fmt.Printf("  %s\n", l)
+		}
+	}
+	return 0, nil
+}
+
+// HandleRerun handles the `ok rerun` command.
+func HandleRerun(args []string) (exitCode int, err error) {
+	e, err := entryArg(args, *rerunAllWorkspaces, false)
+	if err != nil {
+		return 1, err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return 1, err
+	}
+	fmt.Fprintf(os.Stderr, "Rerunning %s\n", e.Command())
+	cmd := exec.Command(self, e.Args...)
+	cmd.Dir = e.Cwd
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return 1, err
+	}
+	return 0, nil
+}
+
+// HandleFix handles the `ok fix` command.
+func HandleFix(args []string) (exitCode int, err error) {
+	e, err := entryArg(args, *fixAllWorkspaces, true)
+	if err != nil {
+		return 1, err
+	}
+	if !e.Failed() {
+		return 1, fmt.Errorf("%s succeeded; there is nothing to fix", e.Command())
+	}
+	if Fix == nil {
+		return 1, fmt.Errorf("ok fix is not supported by this binary")
+	}
+	// Run where the command ran, so that paths in its output resolve.
+	if err := os.Chdir(e.Cwd); err != nil {
+		return 1, err
+	}
+	fmt.Fprintf(os.Stderr, "Fixing %s (%s)\n", e.Command(), e.Start.Format(time.DateTime))
+	return Fix(e)
+}
+
+// entryArg returns the entry that the optional argument refers to, or the
+// latest entry, or the latest failed entry if onlyFailed is set.
+func entryArg(args []string, all, onlyFailed bool) (*Entry, error) {
+	entries, err := workspaceEntries(all)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 {
+		return Find(entries, args[0])
+	}
+	for _, e := range entries {
+		if !onlyFailed || e.Failed() {
+			return e, nil
+		}
+	}
+	if onlyFailed {
+		return nil, fmt.Errorf("no failed commands found in the history")
+	}
+	return nil, fmt.Errorf("no commands found in the history")
+}
+
+// workspaceEntries returns the entries of the current workspace, or of every
+// workspace if all is set or the current directory isn't in one.
+func workspaceEntries(all bool) ([]*Entry, error) {
+	entries, err := List()
+	if err != nil {
+		return nil, err
+	}
+	ws, err := workspace.Path()
+	if all || err != nil {
+		return entries, nil
+	}
+	var out []*Entry
+	for _, e := range entries {
+		if e.Workspace == ws {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// tail returns the last n lines of r.
+func tail(r io.Reader, n int) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,281 @@
+// Package history keeps a record of every bazel command that ok runs, with
+// its output and build events, so that it can be listed, rerun or fixed
+// later.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/bep"
+	"ok.build/cli/config"
+	"ok.build/cli/log"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// entryName is the name of an entry's metadata file in its directory.
+	entryName = "entry.json"
+
+	// idFormat formats an entry's start time as its ID, which is also the
+	// name of its directory.
+	idFormat = "20060102-150405.000"
+
+	// defaultKeep is how many entries are kept when the history.keep config
+	// key isn't set.
+	defaultKeep = 100
+)
+
+// Entry is a bazel command that ok ran.
+type Entry struct {
+	ID string `json:"id"`
+	// Args are the args of ok as typed, without the program name.
+	Args []string `json:"args"`
+	// BazelArgs are the args that bazel ran with, after expanding aliases and
+	// running plugins, but without the flags that ok adds for its own logs.
+	BazelArgs []string      `json:"bazel_args,omitempty"`
+	Cwd       string        `json:"cwd"`
+	Workspace string        `json:"workspace,omitempty"`
+	Start     time.Time     `json:"start"`
+	Duration  time.Duration `json:"duration"`
+	ExitCode  int           `json:"exit_code"`
+	// Log and BuildEvents are the paths of bazel's output and build event
+	// file. BuildEvents is empty if the command doesn't write build events.
+	Log         string   `json:"log"`
+	BuildEvents string   `json:"build_events,omitempty"`
+	Summary     *Summary `json:"summary,omitempty"`
+	// Agent and SessionID are the provider and ID of the last agent session
+	// that worked on the command's failure, if any.
+	Agent     string `json:"agent,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+
+	// Dir holds the entry's files, including those that ok writes while
+	// handling the command, such as the context passed to the agent.
+	Dir string `json:"-"`
+	// temporary is set for entries that aren't kept because history is
+	// turned off.
+	temporary bool
+}
+
+// Summary is the outcome of a command according to its build events.
+type Summary struct {
+	Targets       int      `json:"targets"`
+	FailedTargets []string `json:"failed_targets,omitempty"`
+	Tests         int      `json:"tests,omitempty"`
+	FailedTests   int      `json:"failed_tests,omitempty"`
+	// ActionsExecuted is how many actions bazel ran, rather than taking their
+	// outputs from a cache.
+	ActionsExecuted int64 `json:"actions_executed,omitempty"`
+}
+
+// Fix handles the failure of an earlier command for `ok fix`. It is set by
+// the ok binary, which implements the fix flow for commands that just ran.
+var Fix func(e *Entry) (exitCode int, err error)
+
+// New creates the entry of a command that starts now, with args as typed.
+// Its directory is under ~/.ok/history, unless the history.keep config key
+// is 0, in which case it is a temporary directory that Close removes.
+func New(start time.Time, args []string) (*Entry, error) {
+	e := &Entry{
+		ID:    start.Format(idFormat),
+		Args:  args,
+		Start: start,
+	}
+	e.Cwd, _ = os.Getwd()
+	e.Workspace, _ = workspace.Path()
+	root, err := dir()
+	if err != nil || config.GetInt("history.keep", defaultKeep) <= 0 {
+		if err != nil {
+			log.Debugf("Not keeping history: %s", err)
+		}
+		e.temporary = true
+		e.Dir, err = os.MkdirTemp("", "ok-*")
+		return e, err
+	}
+	e.Dir = filepath.Join(root, e.ID)
+	if err := os.MkdirAll(e.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Finish records how the command ended, with its build events if there are
+// any, and saves the entry.
+func (e *Entry) Finish(exitCode int, inv *bep.Invocation) {
+	e.Duration = time.Since(e.Start)
+	e.ExitCode = exitCode
+	if inv != nil {
+		e.Summary = Summarize(inv)
+	}
+	if err := e.Save(); err != nil {
+		log.Debugf("Failed to save history entry: %s", err)
+	}
+}
+
+// Save writes the entry's metadata to its directory and removes the oldest
+// entries beyond the number given by the history.keep config key.
+func (e *Entry) Save() error {
+	if e.temporary {
+		return nil
+	}
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(e.Dir, entryName), append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	prune()
+	return nil
+}
+
+// Close removes the entry's directory if the entry isn't kept.
+func (e *Entry) Close() {
+	if e.temporary {
+		os.RemoveAll(e.Dir)
+	}
+}
+
+// Command returns the command line as typed, like "ok build //...".
+func (e *Entry) Command() string {
+	return "ok " + arg.JoinShell(e.Args)
+}
+
+// Failed returns whether the command failed.
+func (e *Entry) Failed() bool {
+	return e.ExitCode != 0
+}
+
+// ReadBuildEvents reads the command's build events, which is nil if it
+// didn't write any.
+func (e *Entry) ReadBuildEvents() (*bep.Invocation, error) {
+	if e.BuildEvents == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(e.BuildEvents); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return bep.ReadFile(e.BuildEvents)
+}
+
+// Summarize summarizes the build events of a command.
+func Summarize(inv *bep.Invocation) *Summary {
+	s := &Summary{Targets: len(inv.Targets)}
+	for _, t := range inv.FailedTargets() {
+		s.FailedTargets = append(s.FailedTargets, t.Label)
+	}
+	for _, t := range inv.Targets {
+		if t.TestSummary == nil {
+			continue
+		}
+		s.Tests++
+		if t.TestSummary.Status != "PASSED" && t.TestSummary.Status != "FLAKY" {
+			s.FailedTests++
+		}
+	}
+	if inv.Metrics != nil {
+		s.ActionsExecuted = inv.Metrics.ActionsExecuted
+	}
+	return s
+}
+
+// List returns the saved entries, newest first.
+func List() ([]*Entry, error) {
+	root, err := dir()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var entries []*Entry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		e, err := read(filepath.Join(root, d.Name()))
+		if err != nil {
+			// Commands that are still running, or were interrupted, have no
+			// metadata yet.
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Start.After(entries[j].Start) })
+	return entries, nil
+}
+
+// Find returns the entry that ref refers to among entries: a number counting
+// back from the latest entry, which is 1, or an entry's ID.
+func Find(entries []*Entry, ref string) (*Entry, error) {
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(entries) {
+			return nil, fmt.Errorf("there is no history entry %d; there are %d", n, len(entries))
+		}
+		return entries[n-1], nil
+	}
+	for _, e := range entries {
+		if e.ID == ref {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("there is no history entry %q", ref)
+}
+
+func read(dir string) (*Entry, error) {
+	b, err := os.ReadFile(filepath.Join(dir, entryName))
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{Dir: dir}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// prune removes the oldest entries beyond the number given by the
+// history.keep config key. The directories of commands that never finished
+// count towards it too, so they are removed in time.
+func prune() {
+	root, err := dir()
+	if err != nil {
+		return
+	}
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	// Entry directories are named by their start time, so sorting them by
+	// name sorts them from oldest to newest.
+	var names []string
+	for _, d := range dirs {
+		if _, err := time.Parse(idFormat, d.Name()); err == nil && d.IsDir() {
+			names = append(names, d.Name())
+		}
+	}
+	sort.Strings(names)
+	keep := config.GetInt("history.keep", defaultKeep)
+	for _, name := range names[:max(0, len(names)-keep)] {
+		if err := os.RemoveAll(filepath.Join(root, name)); err != nil {
+			log.Debugf("Failed to remove history entry %s: %s", name, err)
+		}
+	}
+}
+
+// dir returns the directory that history is kept in.
+func dir() (string, error) {
+	okDir, err := config.OkDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(okDir, "history"), nil
+}
@@ -5,26 +5,41 @@ import (
 	"os"
 	"strings"
 
-	"ok.build/cli/claude"
+	"golang.org/x/term"
+	"ok.build/cli/agent"
 )
 
 var (
-	flags = flag.NewFlagSet("ask", flag.ContinueOnError)
+	Flags = flag.NewFlagSet("please", flag.ContinueOnError)
+
+	interactive = Flags.Bool("interactive", true, "Work through the task together, asking before making choices.")
 )
 
-var (
-	usage = `
-usage: ok ` + flags.Name() + `
+const Description = `
+The task is described in plain language, for example:
 
-Asks ok to perform a task.
+  ok please add a go_test target for //foo:bar
+
+Anything piped to stdin is passed to ok along with the task.
 `
-)
 
 func HandleAsk(args []string) (int, error) {
 
 	claudePrompt := strings.Join(args, " ")
 
-	claude.Run(os.Stdin, []string{claudePrompt}, true)
+	a, err := agent.New()
+	if err != nil {
+		return 1, err
+	}
+	req := &agent.Request{Prompt: claudePrompt, Interactive: *interactive}
+	// Only piped input is context; reading a terminal would wait for the
+	// user to type an EOF.
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		req.Context = os.Stdin
+	}
+	if _, err := agent.Run(a, req); err != nil {
+		return 1, err
+	}
 
 	return 0, nil
 }
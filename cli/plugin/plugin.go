@@ -0,0 +1,329 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/command"
+	"ok.build/cli/config"
+	"ok.build/cli/log"
+	"ok.build/cli/workspace"
+)
+
+// A plugin is a directory containing any of these executables, which are run
+// around each bazel command:
+const (
+	// preBazelScript is run before bazel, with the path of a file containing
+	// bazel's args (one per line) as $1. It may rewrite the file to change the
+	// args. Args after "--" are not included, and are passed on unchanged.
+	preBazelScript = "pre_bazel.sh"
+
+	// outputScript is started before bazel and receives bazel's output on
+	// stdin as it is written. Its stdout and stderr are shown on stderr.
+	outputScript = "handle_output.sh"
+
+	// postBazelScript is run after bazel with the path of bazel's log as $1,
+	// and $OK_EXIT_CODE, $OK_BAZEL_LOG and $OK_BUILD_EVENTS set. It may add
+	// options to the picker that is shown when bazel fails, by writing
+	// "<label>\t<shell command>" lines to the file at $OK_PICKER_OPTIONS.
+	postBazelScript = "post_bazel.sh"
+
+	// hookBufferChunks is how many writes of bazel's output are buffered for
+	// an output hook that doesn't keep up with it.
+	hookBufferChunks = 1024
+)
+
+// Plugin is a plugin directory declared by the `plugin.path` config key. The
+// key may be repeated; relative paths are relative to the workspace root.
+type Plugin struct {
+	Path string
+}
+
+// Result describes a finished bazel command, for post-bazel hooks.
+type Result struct {
+	ExitCode int
+
+	// LogPath is the path of the file holding bazel's output.
+	LogPath string
+
+	// BuildEventsPath is the path of the JSON build event file, if any.
+	BuildEventsPath string
+}
+
+// Action is a picker option added by a plugin. Picking it runs Command with
+// `sh -c` in the workspace root.
+type Action struct {
+	Plugin  *Plugin
+	Label   string
+	Command string
+}
+
+// Load returns the plugins declared in config, in the order they're declared.
+// Plugins that can't be found are skipped with a warning rather than failing
+// every bazel command.
+func Load() []*Plugin {
+	var plugins []*Plugin
+	for _, path := range config.GetAll("plugin.path") {
+		if !filepath.IsAbs(path) {
+			ws, err := workspace.Path()
+			if err != nil {
+				log.Warnf("Skipping plugin %q: %s", path, err)
+				continue
+			}
+			path = filepath.Join(ws, path)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Warnf("Skipping plugin: %s", err)
+			continue
+		}
+		if !fi.IsDir() {
+			log.Warnf("Skipping plugin: %s is not a directory", path)
+			continue
+		}
+		plugins = append(plugins, &Plugin{Path: path})
+	}
+	return plugins
+}
+
+// Name returns the plugin's directory name.
+func (p *Plugin) Name() string {
+	return filepath.Base(p.Path)
+}
+
+// Hooks returns the names of the hook scripts that the plugin has.
+func (p *Plugin) Hooks() []string {
+	var hooks []string
+	for _, name := range []string{preBazelScript, outputScript, postBazelScript} {
+		if p.script(name) != "" {
+			hooks = append(hooks, name)
+		}
+	}
+	return hooks
+}
+
+// script returns the path of the given script in the plugin directory, or ""
+// if the plugin doesn't have it. Scripts that aren't executable are skipped
+// with a warning rather than failing the bazel command.
+func (p *Plugin) script(name string) string {
+	path := filepath.Join(p.Path, name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if fi.IsDir() || fi.Mode()&0111 == 0 {
+		log.Warnf("Skipping %s of plugin %s: it isn't an executable file", name, p.Name())
+		return ""
+	}
+	return path
+}
+
+func (p *Plugin) command(script string, args []string, extraEnv ...string) *exec.Cmd {
+	cmd := exec.Command(script, args...)
+	cmd.Dir = p.Path
+	cmd.Env = append(command.ExternalEnv(), extraEnv...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// RunPreBazel runs the pre-bazel hook of every plugin in order, each one
+// receiving the args as rewritten by the previous ones.
+func RunPreBazel(plugins []*Plugin, args []string, tempDir string) ([]string, error) {
+	for _, p := range plugins {
+		script := p.script(preBazelScript)
+		if script == "" {
+			continue
+		}
+		bazelArgs, execArgs := arg.SplitExecutableArgs(args)
+		argsFile := filepath.Join(tempDir, "args.txt")
+		if err := os.WriteFile(argsFile, []byte(strings.Join(bazelArgs, "\n")+"\n"), 0644); err != nil {
+			return nil, err
+		}
+		log.Debugf("Running %s", script)
+		if err := p.command(script, []string{argsFile}).Run(); err != nil {
+			return nil, fmt.Errorf("plugin %s: %s failed: %s", p.Name(), preBazelScript, err)
+		}
+		b, err := os.ReadFile(argsFile)
+		if err != nil {
+			return nil, err
+		}
+		bazelArgs = nil
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				bazelArgs = append(bazelArgs, line)
+			}
+		}
+		args = arg.JoinExecutableArgs(bazelArgs, execArgs)
+	}
+	return args, nil
+}
+
+// StartOutputHandlers starts the output hook of every plugin. Bazel's output
+// should be written to the returned writer, and wait should be called once
+// bazel exits.
+func StartOutputHandlers(plugins []*Plugin) (w io.Writer, wait func()) {
+	var writers []io.Writer
+	var waits []func()
+	for _, p := range plugins {
+		script := p.script(outputScript)
+		if script == "" {
+			continue
+		}
+		cmd := p.command(script, nil)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			log.Warnf("plugin %s: %s", p.Name(), err)
+			continue
+		}
+		if err := cmd.Start(); err != nil {
+			log.Warnf("plugin %s: failed to start %s: %s", p.Name(), outputScript, err)
+			continue
+		}
+		// A plugin that reads its input slowly, or stops reading it, mustn't
+		// hold up bazel or fail the build.
+		hook := newHookWriter(stdin)
+		writers = append(writers, hook)
+		waits = append(waits, func() {
+			hook.Close()
+			if hook.dropped > 0 {
+				log.Warnf("plugin %s: %s didn't keep up with bazel's output, so %d bytes of it were dropped", p.Name(), outputScript, hook.dropped)
+			}
+			if err := cmd.Wait(); err != nil {
+				log.Warnf("plugin %s: %s failed: %s", p.Name(), outputScript, err)
+			}
+		})
+	}
+	return io.MultiWriter(writers...), func() {
+		for _, wait := range waits {
+			wait()
+		}
+	}
+}
+
+// hookWriter writes to the stdin of an output hook from a goroutine, so that
+// writes never block. Output that doesn't fit in its buffer, while the hook
+// isn't reading, is dropped, as is everything after the hook stops reading.
+type hookWriter struct {
+	chunks  chan []byte
+	done    chan struct{}
+	dropped int
+}
+
+func newHookWriter(w io.WriteCloser) *hookWriter {
+	h := &hookWriter{chunks: make(chan []byte, hookBufferChunks), done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		defer w.Close()
+		for b := range h.chunks {
+			if _, err := w.Write(b); err != nil {
+				break
+			}
+		}
+		// Let Write drop the rest once the hook stopped reading.
+		for range h.chunks {
+		}
+	}()
+	return h
+}
+
+func (h *hookWriter) Write(p []byte) (int, error) {
+	select {
+	case h.chunks <- bytes.Clone(p):
+	default:
+		h.dropped += len(p)
+	}
+	return len(p), nil
+}
+
+// Close waits for the buffered output to be written, and closes the hook's
+// stdin.
+func (h *hookWriter) Close() {
+	close(h.chunks)
+	<-h.done
+}
+
+// RunPostBazel runs the post-bazel hook of every plugin, and returns the
+// picker actions that they added.
+func RunPostBazel(plugins []*Plugin, result *Result, tempDir string) []*Action {
+	var actions []*Action
+	for i, p := range plugins {
+		script := p.script(postBazelScript)
+		if script == "" {
+			continue
+		}
+		optionsFile := filepath.Join(tempDir, fmt.Sprintf("picker_options_%d.txt", i))
+		cmd := p.command(script, []string{result.LogPath},
+			fmt.Sprintf("OK_EXIT_CODE=%d", result.ExitCode),
+			"OK_BAZEL_LOG="+result.LogPath,
+			"OK_BUILD_EVENTS="+result.BuildEventsPath,
+			"OK_PICKER_OPTIONS="+optionsFile,
+		)
+		log.Debugf("Running %s", script)
+		if err := cmd.Run(); err != nil {
+			log.Warnf("plugin %s: %s failed: %s", p.Name(), postBazelScript, err)
+			continue
+		}
+		pluginActions, err := readActions(p, optionsFile)
+		if err != nil {
+			log.Warnf("plugin %s: %s", p.Name(), err)
+			continue
+		}
+		actions = append(actions, pluginActions...)
+	}
+	return actions
+}
+
+func readActions(p *Plugin, path string) ([]*Action, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var actions []*Action
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		label, cmd, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("invalid picker option %q, expected <label>\\t<command>", line)
+		}
+		actions = append(actions, &Action{Plugin: p, Label: label, Command: cmd})
+	}
+	return actions, scanner.Err()
+}
+
+// Run runs the action's command in the workspace root.
+func (a *Action) Run() (int, error) {
+	cmd := exec.Command("sh", "-c", a.Command)
+	if ws, err := workspace.Path(); err == nil {
+		cmd.Dir = ws
+	}
+	cmd.Env = command.ExternalEnv()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
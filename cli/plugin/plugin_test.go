@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestHookWriterDoesNotBlock(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHookWriter(w)
+	// The hook doesn't read, so the pipe and then the buffer fill up.
+	chunk := bytes.Repeat([]byte("x"), 1024)
+	for i := 0; i < 2*hookBufferChunks+64; i++ {
+		h.Write(chunk)
+	}
+	if h.dropped == 0 {
+		t.Errorf("nothing was dropped")
+	}
+	// The hook catches up.
+	go io.Copy(io.Discard, r)
+	h.Close()
+	if n, err := w.Write(chunk); err == nil {
+		t.Errorf("wrote %d bytes to the hook after Close, want an error", n)
+	}
+}
+
+func TestHookWriterWritesEverything(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHookWriter(w)
+	var got bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&got, r)
+		close(copied)
+	}()
+	var want bytes.Buffer
+	for i := 0; i < 100; i++ {
+		line := []byte("line\n")
+		want.Write(line)
+		h.Write(line)
+	}
+	h.Close()
+	<-copied
+	if got.String() != want.String() || h.dropped != 0 {
+		t.Errorf("the hook got %d bytes with %d dropped, want %d", got.Len(), h.dropped, want.Len())
+	}
+}
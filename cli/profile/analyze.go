@@ -0,0 +1,239 @@
+package profile
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Categories of bazel's profile events.
+const (
+	categoryAction       = "action processing"
+	categoryCriticalPath = "critical path component"
+	categoryPhaseMarker  = "build phase marker"
+	categoryCacheCheck   = "remote action cache check"
+	categoryDownload     = "remote output download"
+)
+
+const (
+	// executionPhase is the name of the execution phase's marker.
+	executionPhase = "Build artifacts"
+	// skymeldPhase is the name of the marker of the phase in which bazel
+	// analyzes and executes at once, which it does instead of the analysis
+	// and execution phases with Skymeld, the default since bazel 7.
+	skymeldPhase = "Analyze dependencies and build artifacts"
+
+	// unknownMnemonic is used for actions that the profile doesn't give a
+	// mnemonic for, as older bazel versions don't.
+	unknownMnemonic = "(unknown)"
+
+	// minGap is how long no action may run before it counts as a gap.
+	minGap = 500 * time.Millisecond
+)
+
+// Profile is what ok makes of a bazel profile.
+type Profile struct {
+	Path string
+
+	// Duration is the time from the first to the last event.
+	Duration time.Duration
+
+	// Phases are bazel's phases, like analysis and execution, in order.
+	Phases []*Phase
+
+	// CriticalPath lists the actions on the critical path, in order.
+	CriticalPath []*Span
+
+	// Actions lists every action that bazel executed or checked the cache
+	// for.
+	Actions []*Span
+
+	// RemoteCacheCheck and RemoteDownload are the total time spent looking
+	// up actions in the remote cache and downloading their outputs, summed
+	// over all threads.
+	RemoteCacheCheck time.Duration
+	RemoteDownload   time.Duration
+
+	// Gaps are the periods of the execution phase in which no action ran,
+	// longest first.
+	Gaps []*Span
+}
+
+// Phase is one of bazel's phases.
+type Phase struct {
+	Name     string
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// Span is a timed part of the build. Start is relative to the beginning of
+// the profile.
+type Span struct {
+	Name     string
+	Mnemonic string
+	Target   string
+	Start    time.Duration
+	Duration time.Duration
+}
+
+func (s *Span) end() time.Duration {
+	return s.Start + s.Duration
+}
+
+// Mnemonic is the time spent running actions of one kind.
+type Mnemonic struct {
+	Name    string
+	Count   int
+	Total   time.Duration
+	Slowest *Span
+}
+
+// Read reads and analyzes the profile at path.
+func Read(path string) (*Profile, error) {
+	p := &Profile{Path: path}
+	var first, last time.Duration
+	seen := false
+	var markers []*Phase
+	err := readTrace(path, func(e *event) {
+		if e.Ph == "M" || e.Ph == "C" {
+			return
+		}
+		start := e.start()
+		if !seen || start < first {
+			first = start
+		}
+		last = max(last, start+e.duration())
+		seen = true
+		switch e.Cat {
+		case categoryPhaseMarker:
+			markers = append(markers, &Phase{Name: e.Name, Start: start})
+		case categoryCriticalPath:
+			p.CriticalPath = append(p.CriticalPath, &Span{Name: criticalPathName(e.Name), Start: start, Duration: e.duration()})
+		case categoryAction:
+			mnemonic := e.Args.Mnemonic
+			if mnemonic == "" {
+				mnemonic = unknownMnemonic
+			}
+			p.Actions = append(p.Actions, &Span{Name: e.Name, Mnemonic: mnemonic, Target: e.Args.Target, Start: start, Duration: e.duration()})
+		case categoryCacheCheck:
+			p.RemoteCacheCheck += e.duration()
+		case categoryDownload:
+			p.RemoteDownload += e.duration()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.Duration = last - first
+	for _, list := range [][]*Span{p.CriticalPath, p.Actions} {
+		for _, s := range list {
+			s.Start -= first
+		}
+	}
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].Start < markers[j].Start })
+	for i, m := range markers {
+		m.Start -= first
+		end := p.Duration
+		if i+1 < len(markers) {
+			end = markers[i+1].Start - first
+		}
+		m.Duration = end - m.Start
+	}
+	p.Phases = markers
+	sort.SliceStable(p.CriticalPath, func(i, j int) bool { return p.CriticalPath[i].Start < p.CriticalPath[j].Start })
+	p.Gaps = p.gaps()
+	return p, nil
+}
+
+// criticalPathName turns "action 'Compiling foo.cc'" into "Compiling
+// foo.cc".
+func criticalPathName(name string) string {
+	if s, ok := strings.CutPrefix(name, "action '"); ok {
+		return strings.TrimSuffix(s, "'")
+	}
+	return name
+}
+
+// Phase returns the phase with the given name, or nil.
+func (p *Profile) Phase(name string) *Phase {
+	for _, ph := range p.Phases {
+		if ph.Name == name {
+			return ph
+		}
+	}
+	return nil
+}
+
+// CriticalPathDuration returns the total duration of the critical path.
+func (p *Profile) CriticalPathDuration() time.Duration {
+	var d time.Duration
+	for _, s := range p.CriticalPath {
+		d += s.Duration
+	}
+	return d
+}
+
+// IdleTime returns the total duration of the gaps.
+func (p *Profile) IdleTime() time.Duration {
+	var d time.Duration
+	for _, g := range p.Gaps {
+		d += g.Duration
+	}
+	return d
+}
+
+// Mnemonics returns the time spent on actions by mnemonic, most time first.
+func (p *Profile) Mnemonics() []*Mnemonic {
+	byName := map[string]*Mnemonic{}
+	var out []*Mnemonic
+	for _, a := range p.Actions {
+		m := byName[a.Mnemonic]
+		if m == nil {
+			m = &Mnemonic{Name: a.Mnemonic}
+			byName[a.Mnemonic] = m
+			out = append(out, m)
+		}
+		m.Count++
+		m.Total += a.Duration
+		if m.Slowest == nil || a.Duration > m.Slowest.Duration {
+			m.Slowest = a
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Total > out[j].Total })
+	return out
+}
+
+// gaps returns the periods of at least minGap in the execution phase when no
+// action was running. With Skymeld, and in profiles without phase markers,
+// they start after the first action, since bazel analyzes until then. Each
+// gap is named after the action that ended it, which is usually what bazel
+// was waiting for.
+func (p *Profile) gaps() []*Span {
+	if len(p.Actions) == 0 {
+		return nil
+	}
+	from, to := time.Duration(0), p.Duration
+	started := false
+	if execution := p.Phase(executionPhase); execution != nil {
+		from, to = execution.Start, execution.Start+execution.Duration
+		started = true
+	} else if merged := p.Phase(skymeldPhase); merged != nil {
+		from, to = merged.Start, merged.Start+merged.Duration
+	}
+	actions := append([]*Span{}, p.Actions...)
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Start < actions[j].Start })
+	var gaps []*Span
+	busyUntil := from
+	for _, a := range actions {
+		if a.end() <= from || a.Start >= to {
+			continue
+		}
+		if started && a.Start-busyUntil >= minGap {
+			gaps = append(gaps, &Span{Name: a.Name, Mnemonic: a.Mnemonic, Target: a.Target, Start: busyUntil, Duration: a.Start - busyUntil})
+		}
+		started = true
+		busyUntil = max(busyUntil, a.end())
+	}
+	sort.SliceStable(gaps, func(i, j int) bool { return gaps[i].Duration > gaps[j].Duration })
+	return gaps
+}
@@ -0,0 +1,68 @@
+package profile
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The profiles in testdata are small traces written by hand after those of
+// bazel 6, which marks the analysis and execution phases, and bazel 7, which
+// analyzes and executes in a single phase with Skymeld.
+func TestRead(t *testing.T) {
+	for _, tc := range []struct {
+		profile string
+		phases  []string
+		gaps    []string
+		note    bool
+	}{
+		{
+			profile: "phases.profile.json",
+			phases:  []string{"Initialize command 1s", "Analyze dependencies 2s", "Build artifacts 6s", "Complete build 0s"},
+			gaps:    []string{"GoLink app/app 1s at 5s"},
+		},
+		{
+			// The time between the start of the phase and the first action
+			// is spent on analysis, so it's no gap.
+			profile: "skymeld.profile.json",
+			phases:  []string{"Initialize command 1s", "Analyze dependencies and build artifacts 8s", "Complete build 0s"},
+			gaps:    []string{"GoLink app/app 1.5s at 4.5s"},
+			note:    true,
+		},
+	} {
+		t.Run(tc.profile, func(t *testing.T) {
+			p, err := Read(filepath.Join("testdata", tc.profile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Duration != 9*time.Second {
+				t.Errorf("got duration %s, want 9s", p.Duration)
+			}
+			var phases, gaps []string
+			for _, ph := range p.Phases {
+				phases = append(phases, fmt.Sprintf("%s %s", ph.Name, ph.Duration))
+			}
+			for _, g := range p.Gaps {
+				gaps = append(gaps, fmt.Sprintf("%s %s at %s", g.Name, g.Duration, g.Start))
+			}
+			if !slices.Equal(phases, tc.phases) {
+				t.Errorf("got phases %q, want %q", phases, tc.phases)
+			}
+			if !slices.Equal(gaps, tc.gaps) {
+				t.Errorf("got gaps %q, want %q", gaps, tc.gaps)
+			}
+			if got, want := p.CriticalPathDuration(), 4*time.Second; got != want {
+				t.Errorf("got a critical path of %s, want %s", got, want)
+			}
+
+			var out strings.Builder
+			Write(&out, p)
+			if got := strings.Contains(out.String(), "Skymeld"); got != tc.note {
+				t.Errorf("got report\n%s\nwhich says that the phases are merged: %t, want %t", out.String(), got, tc.note)
+			}
+		})
+	}
+}
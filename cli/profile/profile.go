@@ -0,0 +1,245 @@
+// Package profile implements `ok profile`, which analyzes the JSON trace
+// profiles that bazel writes for each build.
+package profile
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ok.build/cli/bazelisk"
+	"ok.build/cli/format"
+)
+
+const (
+	// maxCriticalPath is how many actions on the critical path are listed.
+	maxCriticalPath = 15
+	// maxMnemonics is how many mnemonics are listed.
+	maxMnemonics = 10
+	// maxChanges is how many slower mnemonics and critical path actions a
+	// comparison lists.
+	maxChanges = 5
+	// maxGaps is how many of the longest idle gaps are listed.
+	maxGaps = 5
+	// maxNameWidth is how much of an action's name is shown.
+	maxNameWidth = 80
+)
+
+var (
+	Flags = flag.NewFlagSet("profile", flag.ContinueOnError)
+
+	compare = Flags.String("compare", "", "Another profile to compare against, such as the profile of a build before a regression.")
+)
+
+const Description = `
+Analyzes a JSON trace profile, which bazel writes for each command to
+command.profile.gz in the output base, or to the path given by --profile.
+Without a file, the profile of the last bazel command in the workspace is
+analyzed.
+
+The report shows the time spent in each phase, such as analysis and
+execution, the actions on the critical path, the slowest actions by mnemonic,
+the time spent checking the remote cache and downloading outputs, and the gaps
+in which no action ran.
+
+To find out why a build got slower, compare its profile against the profile
+of an earlier build:
+
+  ok profile --compare before.profile.gz after.profile.gz
+`
+
+// HandleProfile handles the `ok profile` command.
+func HandleProfile(args []string) (exitCode int, err error) {
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	} else if path, err = lastProfile(); err != nil {
+		return 1, err
+	}
+	p, err := Read(path)
+	if err != nil {
+		return 1, err
+	}
+	Write(os.Stdout, p)
+	if *compare != "" {
+		base, err := Read(*compare)
+		if err != nil {
+			return 1, err
+		}
+		fmt.Println()
+		WriteComparison(os.Stdout, p, base)
+	}
+	return 0, nil
+}
+
+// lastProfile returns the path of the profile that bazel wrote for the last
+// command in the workspace. Tracing is turned off for the `bazel info` call,
+// so that it doesn't replace that profile with its own.
+func lastProfile() (string, error) {
+	out := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	exitCode, err := bazelisk.Run([]string{"info", "--generate_json_trace_profile=false", "output_base"}, &bazelisk.RunOpts{Stdout: out, Stderr: stderr})
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("failed to find the output base: %s", strings.TrimSpace(stderr.String()))
+	}
+	path := filepath.Join(strings.TrimSpace(out.String()), "command.profile.gz")
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("no profile found at %s; pass the path of a profile to analyze", path)
+	}
+	return path, nil
+}
+
+// Write writes a report of the profile to w.
+func Write(w io.Writer, p *Profile) {
+	fmt.Fprintf(w, "Profile: %s (%s)\n", p.Path, format.Duration(p.Duration))
+
+	if len(p.Phases) > 0 {
+		fmt.Fprintln(w, "\nPhases:")
+		for _, ph := range p.Phases {
+			fmt.Fprintf(w, "  %-40s %8s  %3.0f%%\n", ph.Name, format.Duration(ph.Duration), percent(ph.Duration, p.Duration))
+		}
+		if p.Phase(skymeldPhase) != nil {
+			fmt.Fprintln(w, "  Bazel analyzed and executed in one phase (Skymeld), so idle gaps are counted from the first action.")
+		}
+	}
+
+	if len(p.CriticalPath) > 0 {
+		fmt.Fprintf(w, "\nCritical path (%s, %s):\n", format.Duration(p.CriticalPathDuration()), format.Plural(len(p.CriticalPath), "action"))
+		for _, s := range p.CriticalPath[:min(len(p.CriticalPath), maxCriticalPath)] {
+			fmt.Fprintf(w, "  %8s  %s\n", format.Duration(s.Duration), truncate(s.Name))
+		}
+		if len(p.CriticalPath) > maxCriticalPath {
+			fmt.Fprintf(w, "  … and %s more\n", format.Plural(len(p.CriticalPath)-maxCriticalPath, "action"))
+		}
+	}
+
+	if mnemonics := p.Mnemonics(); len(mnemonics) > 0 {
+		fmt.Fprintln(w, "\nSlowest actions by mnemonic (time summed over all threads):")
+		fmt.Fprintf(w, "  %-20s %7s %8s %8s\n", "Mnemonic", "Count", "Total", "Slowest")
+		for _, m := range mnemonics[:min(len(mnemonics), maxMnemonics)] {
+			fmt.Fprintf(w, "  %-20s %7d %8s %8s  %s\n", m.Name, m.Count, format.Duration(m.Total), format.Duration(m.Slowest.Duration), truncate(m.Slowest.Name))
+		}
+	}
+
+	if p.RemoteCacheCheck > 0 || p.RemoteDownload > 0 {
+		fmt.Fprintf(w, "\nRemote cache: %s checking the cache, %s downloading outputs (summed over all threads)\n",
+			format.Duration(p.RemoteCacheCheck), format.Duration(p.RemoteDownload))
+	}
+
+	if len(p.Gaps) > 0 {
+		fmt.Fprintf(w, "\nIdle gaps: %s in which no action ran, in %s of %s or more. The longest:\n",
+			format.Duration(p.IdleTime()), format.Plural(len(p.Gaps), "gap"), format.Duration(minGap))
+		for _, g := range p.Gaps[:min(len(p.Gaps), maxGaps)] {
+			fmt.Fprintf(w, "  %8s  at %s, before %s\n", format.Duration(g.Duration), format.Duration(g.Start), truncate(g.Name))
+		}
+	}
+}
+
+// WriteComparison writes how p differs from base to w, to explain why one
+// build took longer than the other.
+func WriteComparison(w io.Writer, p, base *Profile) {
+	fmt.Fprintf(w, "Compared to %s:\n", base.Path)
+	row := func(name string, d, baseD time.Duration) {
+		fmt.Fprintf(w, "  %-40s %8s  vs %8s  %9s\n", name, format.Duration(d), format.Duration(baseD), formatDelta(d-baseD))
+	}
+	row("Total", p.Duration, base.Duration)
+	for _, ph := range p.Phases {
+		if b := base.Phase(ph.Name); b != nil {
+			row(ph.Name, ph.Duration, b.Duration)
+		}
+	}
+	row("Critical path", p.CriticalPathDuration(), base.CriticalPathDuration())
+	row("Remote cache checks", p.RemoteCacheCheck, base.RemoteCacheCheck)
+	row("Remote downloads", p.RemoteDownload, base.RemoteDownload)
+	row("Idle gaps", p.IdleTime(), base.IdleTime())
+
+	baseMnemonics := map[string]*Mnemonic{}
+	for _, m := range base.Mnemonics() {
+		baseMnemonics[m.Name] = m
+	}
+	var changes []*change
+	for _, m := range p.Mnemonics() {
+		c := &change{name: m.Name, d: m.Total, count: m.Count}
+		if b := baseMnemonics[m.Name]; b != nil {
+			c.baseD, c.baseCount = b.Total, b.Count
+		}
+		changes = append(changes, c)
+	}
+	if slower := slowerChanges(changes); len(slower) > 0 {
+		fmt.Fprintln(w, "\nMnemonics that took longer:")
+		for _, c := range slower {
+			fmt.Fprintf(w, "  %-20s %9s  (%s in %d actions vs %s in %d)\n", c.name, formatDelta(c.d-c.baseD), format.Duration(c.d), c.count, format.Duration(c.baseD), c.baseCount)
+		}
+	}
+
+	baseCritical := map[string]time.Duration{}
+	for _, s := range base.CriticalPath {
+		baseCritical[s.Name] = s.Duration
+	}
+	changes = nil
+	for _, s := range p.CriticalPath {
+		baseD, ok := baseCritical[s.Name]
+		changes = append(changes, &change{name: s.Name, d: s.Duration, baseD: baseD, added: !ok})
+	}
+	if slower := slowerChanges(changes); len(slower) > 0 {
+		fmt.Fprintln(w, "\nCritical path actions that took longer or are new to it:")
+		for _, c := range slower {
+			if c.added {
+				fmt.Fprintf(w, "  %9s  %s (not on the other critical path)\n", formatDelta(c.d), truncate(c.name))
+			} else {
+				fmt.Fprintf(w, "  %9s  %s (%s vs %s)\n", formatDelta(c.d-c.baseD), truncate(c.name), format.Duration(c.d), format.Duration(c.baseD))
+			}
+		}
+	}
+}
+
+// change is how long something took in two profiles.
+type change struct {
+	name             string
+	d, baseD         time.Duration
+	count, baseCount int
+	// added is set for critical path actions that aren't on base's.
+	added bool
+}
+
+// slowerChanges returns the changes that took longer, most added time first.
+func slowerChanges(changes []*change) []*change {
+	var slower []*change
+	for _, c := range changes {
+		if c.d-c.baseD > 0 {
+			slower = append(slower, c)
+		}
+	}
+	sort.SliceStable(slower, func(i, j int) bool { return slower[i].d-slower[i].baseD > slower[j].d-slower[j].baseD })
+	return slower[:min(len(slower), maxChanges)]
+}
+
+func formatDelta(d time.Duration) string {
+	if d < 0 {
+		return "-" + format.Duration(-d)
+	}
+	return "+" + format.Duration(d)
+}
+
+func percent(d, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(d) / float64(total)
+}
+
+func truncate(s string) string {
+	if len(s) > maxNameWidth {
+		return strings.ToValidUTF8(s[:maxNameWidth], "") + "…"
+	}
+	return s
+}
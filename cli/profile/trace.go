@@ -0,0 +1,120 @@
+package profile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// event is an event in Chrome's trace event format, which bazel writes its
+// profiles in.
+type event struct {
+	Name string `json:"name"`
+	Cat  string `json:"cat"`
+	// Ph is the phase, like "X" for complete events with a duration, "i" for
+	// instants and "M" for metadata.
+	Ph  string  `json:"ph"`
+	Ts  float64 `json:"ts"`
+	Dur float64 `json:"dur"`
+	Pid int     `json:"pid"`
+	Tid int     `json:"tid"`
+
+	Args struct {
+		Mnemonic string `json:"mnemonic"`
+		Target   string `json:"target"`
+		// Name is set by thread_name metadata events.
+		Name string `json:"name"`
+	} `json:"args"`
+}
+
+func (e *event) start() time.Duration {
+	return time.Duration(e.Ts * float64(time.Microsecond))
+}
+
+func (e *event) duration() time.Duration {
+	return time.Duration(e.Dur * float64(time.Microsecond))
+}
+
+// readTrace calls handle for each event of the trace in path, which may be
+// gzipped. Traces are either a JSON array of events or an object with a
+// traceEvents array, and are read as a stream since they can be large.
+func readTrace(path string, handle func(e *event)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var in io.Reader = r
+	if magic, err := r.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		in = gz
+	}
+	dec := json.NewDecoder(in)
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	switch tok {
+	case json.Delim('['):
+		return readEvents(dec, handle)
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if isTruncated(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if key != "traceEvents" {
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					return err
+				}
+				continue
+			}
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+				return fmt.Errorf("%s: traceEvents is not an array", path)
+			}
+			if err := readEvents(dec, handle); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not a JSON trace profile", path)
+}
+
+// readEvents reads the events of an array whose opening bracket was read.
+// A truncated array, as written by a bazel server that was killed, ends the
+// trace without an error.
+func readEvents(dec *json.Decoder, handle func(e *event)) error {
+	for dec.More() {
+		e := &event{}
+		if err := dec.Decode(e); err != nil {
+			if isTruncated(err) {
+				return nil
+			}
+			return err
+		}
+		handle(e)
+	}
+	if _, err := dec.Token(); err != nil && !isTruncated(err) {
+		return err
+	}
+	return nil
+}
+
+func isTruncated(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
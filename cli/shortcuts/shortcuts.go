@@ -1,9 +1,12 @@
 package shortcuts
 
 import (
+	"fmt"
 	"slices"
+	"strings"
 
 	"ok.build/cli/arg"
+	"ok.build/cli/config"
 )
 
 var (
@@ -17,18 +20,100 @@ var (
 	defaultTargetCommands = []string{"aquery", "build", "coverage", "cquery", "test", "query"}
 )
 
-// HandleShorcuts finds the first non-flag command and tries to expand it
-func HandleShortcuts(args []string) []string {
-	command, idx := arg.GetCommandAndIndex(args)
-	if expanded, ok := shortcuts[command]; ok {
-		args[idx] = expanded
+// Aliases returns the user-defined aliases from the `[alias]` config section.
+// Each alias maps to a command line, such as
+// `tc = test --config=ci --test_output=errors`, or to a single command, like
+// the built-in shortcuts. Aliases take precedence over shortcuts of the same
+// name.
+func Aliases() map[string]string {
+	return config.Section("alias")
+}
+
+// Shortcuts returns the built-in single-word command shortcuts that no alias
+// overrides.
+func Shortcuts() map[string]string {
+	aliases := Aliases()
+	out := map[string]string{}
+	for k, v := range shortcuts {
+		if _, ok := aliases[k]; !ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// HandleShorcuts finds the first non-flag command and tries to expand it,
+// first as an alias and then as a shortcut. Aliases may expand to other
+// aliases; an error is returned if two or more of them form a cycle.
+func HandleShortcuts(args []string) ([]string, error) {
+	_, idx := arg.GetCommandAndIndex(args)
+	if idx == -1 {
+		return args, nil
+	}
+	args, err := expand(args, idx, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	newCommand := args[idx]
+	newCommand, _ := arg.GetCommandAndIndex(args)
 
 	if len(arg.GetTargets(args)) > 0 || !slices.Contains(defaultTargetCommands, newCommand) {
-		return args
+		return args, nil
+	}
+
+	return append(args, "//..."), nil
+}
+
+// expand expands the alias or shortcut at args[idx], then recursively expands
+// the command that it produced. seen holds the chain of names expanded so far.
+func expand(args []string, idx int, seen []string) ([]string, error) {
+	name := args[idx]
+	if slices.Contains(seen, name) {
+		return nil, fmt.Errorf("alias cycle detected: %s", strings.Join(append(seen, name), " -> "))
+	}
+	var replacement []string
+	if expansion, ok := Aliases()[name]; ok {
+		words, err := arg.SplitShell(expansion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alias %q: %s", name, err)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("alias %q is empty", name)
+		}
+		replacement = words
+	} else if expanded, ok := Shortcuts()[name]; ok {
+		replacement = []string{expanded}
+	} else {
+		return args, nil
+	}
+	args = slices.Concat(args[:idx], replacement, args[idx+1:])
+
+	// The expansion may start with options, so find where its command is. An
+	// alias that runs the command of the same name, like
+	// `test = test --test_output=errors`, isn't expanded again, as in shells.
+	command, i := arg.GetCommandAndIndex(args[idx:])
+	if i == -1 || command == name {
+		return args, nil
 	}
+	return expand(args, idx+i, append(seen, name))
+}
 
-	return append(args, "//...")
+// ForCommand returns the shortcuts and aliases that expand to the given
+// command, mapped to their definitions.
+func ForCommand(command string) map[string]string {
+	out := map[string]string{}
+	definitions := Shortcuts()
+	for name, expansion := range Aliases() {
+		definitions[name] = expansion
+	}
+	for name, definition := range definitions {
+		args, err := expand([]string{name}, 0, nil)
+		if err != nil {
+			continue
+		}
+		if arg.GetCommand(args) == command {
+			out[name] = definition
+		}
+	}
+	return out
 }
@@ -0,0 +1,57 @@
+package shortcuts
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"ok.build/cli/config"
+)
+
+func TestExpand(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".ok"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := `[alias]
+test = test --test_output=errors
+tc = test --config=ci
+smoke = tc //services/... --test_tag_filters=smoke
+b = build --keep_going
+loop = again
+again = loop
+`
+	if err := os.WriteFile(filepath.Join(home, ".ok", "config"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{args: []string{"test", "//x"}, want: []string{"test", "--test_output=errors", "//x"}},
+		{args: []string{"tc"}, want: []string{"test", "--test_output=errors", "--config=ci"}},
+		{args: []string{"smoke"}, want: []string{"test", "--test_output=errors", "--config=ci", "//services/...", "--test_tag_filters=smoke"}},
+		{args: []string{"t", "//x"}, want: []string{"test", "--test_output=errors", "//x"}},
+		{args: []string{"b"}, want: []string{"build", "--keep_going"}},
+		{args: []string{"query"}, want: []string{"query"}},
+		{args: []string{"loop"}, wantErr: true},
+	} {
+		got, err := expand(slices.Clone(tc.args), 0, nil)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("expand(%q) = %q, want an error", tc.args, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("expand(%q) = %q, %v, want %q", tc.args, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package testlog
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is the outcome of a test case.
+type Status string
+
+const (
+	StatusPassed  Status = "passed"
+	StatusFailed  Status = "failed"
+	StatusError   Status = "error"
+	StatusSkipped Status = "skipped"
+)
+
+// Case is a test case from a JUnit XML file.
+type Case struct {
+	// Target is the label of the test target that the case belongs to.
+	Target string
+
+	Name      string
+	ClassName string
+	Status    Status
+	Duration  time.Duration
+
+	// Message is the failure's message, such as an assertion's, and Details
+	// its full text, usually including a stack trace.
+	Message string
+	Details string
+
+	// Frames is the stack trace parsed from Details, or from the test log if
+	// Details has none.
+	Frames []*Frame
+
+	// LogPath and XMLPath are the test's log and JUnit XML files.
+	LogPath string
+	XMLPath string
+}
+
+// Title describes the case on one line.
+func (c *Case) Title() string {
+	s := c.Target
+	if c.Name != "" {
+		s += " " + c.FullName()
+	}
+	if msg := firstLine(c.Message); msg != "" {
+		if len(msg) > maxTitleMessage {
+			msg = strings.ToValidUTF8(msg[:maxTitleMessage], "") + "…"
+		}
+		s += ": " + msg
+	}
+	return s
+}
+
+// maxTitleMessage is how much of a case's message its title shows.
+const maxTitleMessage = 100
+
+// FullName returns the case's name qualified by its class, if that isn't
+// already part of the name.
+func (c *Case) FullName() string {
+	if c.ClassName == "" || strings.Contains(c.Name, c.ClassName) {
+		return c.Name
+	}
+	return c.ClassName + "." + c.Name
+}
+
+type xmlCase struct {
+	Name      string      `xml:"name,attr"`
+	ClassName string      `xml:"classname,attr"`
+	Time      string      `xml:"time,attr"`
+	Failures  []xmlResult `xml:"failure"`
+	Errors    []xmlResult `xml:"error"`
+	Skipped   *struct{}   `xml:"skipped"`
+	SystemOut string      `xml:"system-out"`
+	SystemErr string      `xml:"system-err"`
+}
+
+type xmlResult struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// ReadXML reads the test cases from a JUnit XML file, such as the test.xml
+// files that bazel writes to bazel-testlogs. Test suites may be nested.
+func ReadXML(path string) ([]*Case, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseXML(f, path)
+}
+
+func parseXML(r io.Reader, path string) ([]*Case, error) {
+	var cases []*Case
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return cases, nil
+		}
+		if err != nil {
+			return cases, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "testcase" {
+			continue
+		}
+		var x xmlCase
+		if err := dec.DecodeElement(&x, &start); err != nil {
+			return cases, err
+		}
+		c := &Case{Name: x.Name, ClassName: x.ClassName, Status: StatusPassed, XMLPath: path}
+		if secs, err := strconv.ParseFloat(x.Time, 64); err == nil {
+			c.Duration = time.Duration(secs * float64(time.Second))
+		}
+		var result *xmlResult
+		switch {
+		case len(x.Failures) > 0:
+			c.Status, result = StatusFailed, &x.Failures[0]
+		case len(x.Errors) > 0:
+			c.Status, result = StatusError, &x.Errors[0]
+		case x.Skipped != nil:
+			c.Status = StatusSkipped
+		}
+		if result != nil {
+			c.Message = strings.TrimSpace(result.Message)
+			c.Details = strings.TrimSpace(result.Text)
+			if c.Details == "" {
+				c.Details = strings.TrimSpace(x.SystemErr + "\n" + x.SystemOut)
+			}
+			// Some test runners, like rules_go's, only say that the case
+			// failed, leaving the assertion to the details.
+			if c.Message == "" || strings.EqualFold(c.Message, "failed") || strings.EqualFold(c.Message, "error") {
+				c.Message = firstLine(c.Details)
+			}
+		}
+		cases = append(cases, c)
+	}
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
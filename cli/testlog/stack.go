@@ -0,0 +1,179 @@
+package testlog
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Frame is a location in a stack trace or test failure message.
+type Frame struct {
+	// Language is one of "go", "java", "python", "cpp" or "js".
+	Language string
+	Function string
+	File     string
+	Line     int
+}
+
+type framePattern struct {
+	language string
+	re       *regexp.Regexp
+	// file, line and function are the indices of the pattern's groups, or 0
+	// if it doesn't capture them.
+	file, line, function int
+}
+
+var framePatterns = []*framePattern{
+	// "    foo_test.go:12: want 1, got 2" from t.Errorf, and
+	// "\t/path/foo.go:12 +0x1d" from a panic.
+	{"go", regexp.MustCompile(`^\s+(\S+\.go):(\d+)(?:: | \+0x|$)`), 1, 2, 0},
+	// "\tat com.foo.BarTest.testBaz(BarTest.java:12)"
+	{"java", regexp.MustCompile(`^\s*at ([\w$.<>/]+)\(([\w$]+\.(?:java|kt|scala)):(\d+)\)`), 2, 3, 1},
+	// `  File "foo_test.py", line 12, in test_baz`
+	{"python", regexp.MustCompile(`^\s*File "([^"]+)", line (\d+), in (\S+)`), 1, 2, 3},
+	// "foo_test.cc:12: Failure" from googletest.
+	{"cpp", regexp.MustCompile(`^(\S+\.(?:cc|cpp|cxx|c|h|hpp))[:(](\d+)\)?: (?:Failure|error)`), 1, 2, 0},
+	// "    at baz (/path/foo.test.ts:12:5)" and "    at /path/foo.js:12:5"
+	{"js", regexp.MustCompile(`^\s*at (?:(\S+) \()?(?:file://)?(\S+\.[cm]?[jt]sx?):(\d+):\d+\)?$`), 2, 3, 1},
+}
+
+// ParseStackTrace returns the frames in text, which may mix stack traces and
+// other output, in the order they appear.
+func ParseStackTrace(text string) []*Frame {
+	var frames []*Frame
+	for _, line := range strings.Split(text, "\n") {
+		for _, p := range framePatterns {
+			m := p.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			f := &Frame{Language: p.language, File: m[p.file]}
+			f.Line, _ = strconv.Atoi(m[p.line])
+			if p.function > 0 {
+				f.Function = m[p.function]
+			}
+			frames = append(frames, f)
+			break
+		}
+	}
+	return frames
+}
+
+// IsTestFile returns whether path looks like a test source file.
+func IsTestFile(path string) bool {
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	return strings.HasSuffix(name, "_test") || strings.HasPrefix(name, "test_") ||
+		strings.HasSuffix(name, "Test") || strings.HasSuffix(name, "Tests") ||
+		strings.HasSuffix(name, ".test") || strings.HasSuffix(name, ".spec")
+}
+
+// execrootPattern matches the prefixes that bazel adds to source paths in
+// the sandbox, execroot and runfiles.
+var execrootPattern = regexp.MustCompile(`^.*?/(?:execroot|sandbox/[^/]+/\d+/execroot)/[^/]+/|^.*?\.runfiles/[^/]+/|^bazel-out/[^/]+/bin/`)
+
+// Resolve returns the path of the frame's file relative to the workspace,
+// or "" if it can't be found. Java frames only name the file, so the file is
+// looked up by the package of the frame's class.
+func Resolve(ws string, f *Frame) string {
+	p := execrootPattern.ReplaceAllString(f.File, "")
+	if filepath.IsAbs(p) {
+		if rel, err := filepath.Rel(ws, p); err == nil && !strings.HasPrefix(rel, "..") {
+			p = rel
+		} else {
+			return ""
+		}
+	}
+	if f.Language == "java" && !strings.Contains(p, "/") && f.Function != "" {
+		// com.foo.Bar.baz -> com/foo/Bar.java, also for nested classes.
+		parts := strings.Split(f.Function, ".")
+		if len(parts) > 2 {
+			dir := filepath.Join(parts[:len(parts)-2]...)
+			return findFile(ws, filepath.Join(dir, p))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(ws, p)); err != nil {
+		return ""
+	}
+	return p
+}
+
+// maxFilesScanned bounds how many files findFile looks at.
+const maxFilesScanned = 20000
+
+// findFile returns the workspace-relative path of a file whose path ends
+// with suffix, or "".
+func findFile(ws, suffix string) string {
+	found := ""
+	scanned := 0
+	filepath.WalkDir(ws, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if found != "" || scanned == maxFilesScanned {
+			return filepath.SkipAll
+		}
+		if d.IsDir() {
+			if path != ws && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "bazel-") || d.Name() == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		scanned++
+		if strings.HasSuffix(path, string(filepath.Separator)+suffix) {
+			found, _ = filepath.Rel(ws, path)
+		}
+		return nil
+	})
+	return found
+}
+
+// SubjectFiles guesses the source files that a test file tests, like
+// foo.go for foo_test.go or src/main/java/Foo.java for
+// src/test/java/FooTest.java, returning the ones that exist in the
+// workspace.
+func SubjectFiles(ws, testFile string) []string {
+	dir, base := filepath.Split(testFile)
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	var candidates []string
+	for _, n := range []string{
+		strings.TrimSuffix(name, "_test"),
+		strings.TrimPrefix(name, "test_"),
+		strings.TrimSuffix(strings.TrimSuffix(name, "Tests"), "Test"),
+		strings.TrimSuffix(strings.TrimSuffix(name, ".test"), ".spec"),
+	} {
+		if n != name && n != "" {
+			candidates = append(candidates, filepath.Join(dir, n+ext))
+			if ext == ".java" || ext == ".kt" {
+				candidates = append(candidates, filepath.Join(strings.Replace(dir, "/test/", "/main/", 1), n+ext))
+				candidates = append(candidates, filepath.Join(strings.Replace(dir, "javatests/", "java/", 1), n+ext))
+			}
+			if ext == ".cc" || ext == ".cpp" {
+				candidates = append(candidates, filepath.Join(dir, n+".h"))
+			}
+		}
+	}
+	var out []string
+	for _, c := range candidates {
+		if c == testFile {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(ws, c)); err == nil && !contains(out, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+// Package testlog reads the logs and JUnit XML files that bazel writes for
+// tests, to find out which test cases failed and where.
+package testlog
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"ok.build/cli/bep"
+	"ok.build/cli/diagnostic"
+	"ok.build/cli/log"
+	"ok.build/cli/workspace"
+)
+
+const (
+	// maxCasesPerTarget bounds how many failing cases are listed for a
+	// target, since a broken fixture can fail every case in a suite.
+	maxCasesPerTarget = 10
+	// excerptLines is how many lines of a test's log an excerpt has.
+	excerptLines = 60
+)
+
+// testFiles are the log and JUnit XML files of a test run.
+type testFiles struct {
+	target  string
+	logPath string
+	xmlPath string
+}
+
+// Failed returns the failing test cases of the failed tests, as reported by
+// the build events or, if bazel didn't write them, by its output. Tests that
+// don't write JUnit XML, or whose XML has no failing case, are returned as a
+// single case without a name. Cases that failed in several runs, shards or
+// attempts are only returned once.
+func Failed(inv *bep.Invocation, diagnostics []*diagnostic.Diagnostic) []*Case {
+	var cases []*Case
+	seen := map[string]bool{}
+	for _, files := range failedTestFiles(inv, diagnostics) {
+		for _, c := range readCases(files) {
+			key := c.Target + "\x00" + c.FullName()
+			if !seen[key] {
+				seen[key] = true
+				cases = append(cases, c)
+			}
+		}
+	}
+	return cases
+}
+
+func failedTestFiles(inv *bep.Invocation, diagnostics []*diagnostic.Diagnostic) []*testFiles {
+	var files []*testFiles
+	seen := map[string]bool{}
+	add := func(f *testFiles) {
+		key := f.logPath + "\x00" + f.xmlPath
+		if !seen[key] {
+			seen[key] = true
+			files = append(files, f)
+		}
+	}
+	if inv != nil {
+		for _, t := range inv.FailedTargets() {
+			for _, r := range t.TestResults {
+				if r.Status == "PASSED" || r.Status == "FLAKY" || (r.LogPath == "" && r.XMLPath == "") {
+					continue
+				}
+				add(&testFiles{target: t.Label, logPath: r.LogPath, xmlPath: r.XMLPath})
+			}
+		}
+	}
+	if len(files) > 0 {
+		return files
+	}
+	for _, d := range diagnostics {
+		if d.Kind != diagnostic.KindTest || d.Label == "" {
+			continue
+		}
+		logPath := d.File
+		if logPath == "" {
+			logPath = testlogsPath(d.Label)
+		}
+		if logPath == "" {
+			continue
+		}
+		add(&testFiles{target: d.Label, logPath: logPath, xmlPath: filepath.Join(filepath.Dir(logPath), "test.xml")})
+	}
+	return files
+}
+
+// testlogsPath returns the path of a test's log in the workspace's
+// bazel-testlogs directory, or "" if it doesn't exist.
+func testlogsPath(label string) string {
+	ws, err := workspace.Path()
+	if err != nil {
+		return ""
+	}
+	l := strings.TrimPrefix(strings.TrimPrefix(label, "@@"), "@")
+	if !strings.HasPrefix(l, "//") {
+		return ""
+	}
+	pkg, name, ok := strings.Cut(l[2:], ":")
+	if !ok {
+		name = filepath.Base(pkg)
+	}
+	logPath := filepath.Join(ws, "bazel-testlogs", pkg, name, "test.log")
+	if _, err := os.Stat(logPath); err != nil {
+		return ""
+	}
+	return logPath
+}
+
+func readCases(files *testFiles) []*Case {
+	var failing []*Case
+	if files.xmlPath != "" {
+		all, err := ReadXML(files.xmlPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Debugf("Failed to read %s: %s", files.xmlPath, err)
+		}
+		for _, c := range all {
+			if c.Status == StatusFailed || c.Status == StatusError {
+				failing = append(failing, c)
+			}
+		}
+	}
+	// Bazel writes an XML file for tests that don't, with one case named
+	// after the test's binary, like "pkg/name", and the log as its output,
+	// which says no more than the log itself.
+	if len(failing) == 1 && failing[0].ClassName == "" && failing[0].Name == binaryName(files.target) {
+		failing = nil
+	}
+	logText := ""
+	if b, err := os.ReadFile(files.logPath); err == nil {
+		logText = string(b)
+	}
+	if len(failing) == 0 {
+		c := &Case{Status: StatusFailed, Message: lastError(logText), Details: tail(logText, excerptLines)}
+		failing = append(failing, c)
+	}
+	if len(failing) > maxCasesPerTarget {
+		failing = failing[:maxCasesPerTarget]
+	}
+	for _, c := range failing {
+		c.Target = files.target
+		c.LogPath = files.logPath
+		c.XMLPath = files.xmlPath
+		c.Frames = ParseStackTrace(c.Details)
+		if len(c.Frames) == 0 {
+			c.Frames = ParseStackTrace(excerpt(logText, c.Name))
+		}
+		// Go's testing package only names the file, which is in the test's
+		// package.
+		for _, f := range c.Frames {
+			if f.Language == "go" && !strings.Contains(f.File, "/") {
+				f.File = path.Join(path.Dir(binaryName(files.target)), f.File)
+			}
+		}
+	}
+	return failing
+}
+
+// binaryName returns the path of a test's binary relative to its repository,
+// like "pkg/name" for //pkg:name.
+func binaryName(label string) string {
+	l := strings.TrimPrefix(strings.TrimPrefix(label, "@@"), "@")
+	_, l, _ = strings.Cut(l, "//")
+	pkg, name, ok := strings.Cut(l, ":")
+	if !ok {
+		return pkg + "/" + filepath.Base(pkg)
+	}
+	if pkg == "" {
+		return name
+	}
+	return pkg + "/" + name
+}
+
+// LogExcerpt returns the part of the case's log about the case, or else the
+// end of the log.
+func (c *Case) LogExcerpt() string {
+	b, err := os.ReadFile(c.LogPath)
+	if err != nil {
+		return ""
+	}
+	return excerpt(string(b), c.Name)
+}
+
+// excerpt returns the lines of log starting at the first one that mentions
+// name, like Go's "=== RUN TestFoo" or googletest's "[ RUN      ] Foo.Bar",
+// or else the last lines of the log.
+func excerpt(log, name string) string {
+	lines := strings.Split(strings.TrimRight(log, "\n"), "\n")
+	if name != "" {
+		for i, line := range lines {
+			if strings.Contains(line, name) {
+				return strings.Join(lines[i:min(i+excerptLines, len(lines))], "\n")
+			}
+		}
+	}
+	return tail(log, excerptLines)
+}
+
+// lastError returns the last line of log that looks like an error, for tests
+// without JUnit XML.
+func lastError(log string) string {
+	lines := strings.Split(strings.TrimRight(log, "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := strings.TrimSpace(lines[i])
+		if l == "FAIL" || strings.HasPrefix(l, "FAIL\t") {
+			// Go's summary of the package.
+			continue
+		}
+		lower := strings.ToLower(l)
+		if strings.Contains(lower, "error") || strings.Contains(lower, "fail") || strings.Contains(lower, "assert") || strings.Contains(lower, "panic") {
+			return l
+		}
+	}
+	return ""
+}
+
+func tail(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,290 @@
+// Package testsummary prints a compact summary of a bazel test command from
+// its build events, which is easier to scan than bazel's own summary when
+// many tests run.
+package testsummary
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ok.build/cli/arg"
+	"ok.build/cli/bep"
+	"ok.build/cli/format"
+	"ok.build/cli/testlog"
+)
+
+const (
+	// maxSlowest is how many of the slowest tests are listed.
+	maxSlowest = 5
+	// maxSharded is how many sharded tests get a shard breakdown.
+	maxSharded = 5
+	// maxFailedTargets is how many failed tests are listed, and
+	// maxCasesPerTarget how many of each one's failed cases.
+	maxFailedTargets  = 20
+	maxCasesPerTarget = 5
+	// maxCaseLine is how long a failed case's line may be.
+	maxCaseLine = 120
+)
+
+// Test statuses, grouped the way the summary counts them.
+var (
+	failedStatuses  = []string{"FAILED", "INCOMPLETE", "REMOTE_FAILURE", "FAILED_TO_BUILD"}
+	skippedStatuses = []string{"NO_STATUS", "TOOL_HALTED_BEFORE_TESTING"}
+)
+
+// test is a test target and how it did.
+type test struct {
+	target *bep.Target
+	status string
+	cached bool
+}
+
+// Write writes the summary of the test command in args, whose build events
+// are in inv, to w. Nothing is written if no tests ran. args shouldn't
+// include the flags that ok adds, since the command that reruns the failed
+// tests is made from them.
+func Write(w io.Writer, inv *bep.Invocation, args []string) {
+	tests := collect(inv)
+	if len(tests) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nTest summary: %s in %s\n", format.Plural(len(tests), "test"), inv.Duration().Round(time.Second))
+	writeCounts(w, tests)
+	writeSlowest(w, tests)
+	writeShards(w, tests)
+	failed := writeFailures(w, inv, tests)
+	if len(failed) > 0 {
+		fmt.Fprintf(w, "Rerun the failed tests with:\n  %s\n", rerunCommand(args, failed))
+	}
+}
+
+// collect returns the test targets in inv, in the order bazel reported them.
+// Incompatible tests that bazel skipped have no summary, only an abort
+// event.
+func collect(inv *bep.Invocation) []*test {
+	skipped := map[string]bool{}
+	for _, a := range inv.Aborted {
+		if a.Reason == "SKIPPED" && a.Label != "" {
+			skipped[a.Label] = true
+		}
+	}
+	var tests []*test
+	for _, t := range inv.Targets {
+		switch {
+		case t.TestSummary != nil:
+			s := t.TestSummary
+			tests = append(tests, &test{
+				target: t,
+				status: s.Status,
+				cached: s.TotalRunCount > 0 && s.CachedCount >= s.TotalRunCount,
+			})
+		case skipped[t.Label] && isTest(t):
+			tests = append(tests, &test{target: t, status: "NO_STATUS"})
+		case t.Outcome == bep.OutcomeFailed && isTest(t):
+			tests = append(tests, &test{target: t, status: "FAILED_TO_BUILD"})
+		}
+	}
+	return tests
+}
+
+func isTest(t *bep.Target) bool {
+	return t.TestSize != "" || strings.HasSuffix(t.Kind, "_test")
+}
+
+func writeCounts(w io.Writer, tests []*test) {
+	var passed, cached, failed, flaky, timedOut, skipped int
+	for _, t := range tests {
+		switch {
+		case t.status == "PASSED":
+			passed++
+		case t.status == "FLAKY":
+			flaky++
+		case t.status == "TIMEOUT":
+			timedOut++
+		case slices.Contains(failedStatuses, t.status):
+			failed++
+		case slices.Contains(skippedStatuses, t.status):
+			skipped++
+		}
+		if t.cached {
+			cached++
+		}
+	}
+	counts := []string{fmt.Sprintf("%s passed", format.Number(passed))}
+	for _, c := range []struct {
+		n    int
+		what string
+	}{
+		{failed, "failed"},
+		{flaky, "flaky"},
+		{timedOut, "timed out"},
+		{skipped, "skipped"},
+		{cached, "cached"},
+	} {
+		if c.n > 0 {
+			counts = append(counts, fmt.Sprintf("%s %s", format.Number(c.n), c.what))
+		}
+	}
+	fmt.Fprintf(w, "  %s\n", strings.Join(counts, ", "))
+}
+
+// writeSlowest lists the tests that took longest to run, leaving out cached
+// tests, which didn't run this time.
+func writeSlowest(w io.Writer, tests []*test) {
+	var ran []*test
+	for _, t := range tests {
+		if !t.cached && t.target.TestSummary != nil && t.target.TestSummary.Duration > 0 {
+			ran = append(ran, t)
+		}
+	}
+	if len(ran) < 2 {
+		return
+	}
+	sort.SliceStable(ran, func(i, j int) bool {
+		return ran[i].target.TestSummary.Duration > ran[j].target.TestSummary.Duration
+	})
+	fmt.Fprintln(w, "Slowest tests:")
+	for _, t := range ran[:min(len(ran), maxSlowest)] {
+		fmt.Fprintf(w, "  %8s  %s\n", format.Duration(t.target.TestSummary.Duration), t.target.Label)
+	}
+}
+
+// writeShards breaks sharded tests down by shard, listing the ones with
+// failing shards first.
+func writeShards(w io.Writer, tests []*test) {
+	type shardedTest struct {
+		label  string
+		shards []*bep.TestResult
+		failed []int
+	}
+	var sharded []*shardedTest
+	for _, t := range tests {
+		if t.target.TestSummary == nil || t.target.TestSummary.ShardCount < 2 {
+			continue
+		}
+		st := &shardedTest{label: t.target.Label, shards: finalShardResults(t.target)}
+		for _, r := range st.shards {
+			if r.Status != "PASSED" && r.Status != "FLAKY" {
+				st.failed = append(st.failed, r.Shard)
+			}
+		}
+		sharded = append(sharded, st)
+	}
+	if len(sharded) == 0 {
+		return
+	}
+	sort.SliceStable(sharded, func(i, j int) bool { return len(sharded[i].failed) > len(sharded[j].failed) })
+	fmt.Fprintln(w, "Sharded tests:")
+	for _, st := range sharded[:min(len(sharded), maxSharded)] {
+		var fastest, slowest time.Duration
+		for i, r := range st.shards {
+			if i == 0 || r.Duration < fastest {
+				fastest = r.Duration
+			}
+			slowest = max(slowest, r.Duration)
+		}
+		fmt.Fprintf(w, "  %s: %d shards, %s to %s", st.label, len(st.shards), format.Duration(fastest), format.Duration(slowest))
+		if len(st.failed) > 0 {
+			shards := make([]string, len(st.failed))
+			for i, s := range st.failed {
+				shards[i] = strconv.Itoa(s)
+			}
+			fmt.Fprintf(w, ", failed in shard %s", strings.Join(shards, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(sharded) > maxSharded {
+		fmt.Fprintf(w, "  … and %s more\n", format.Plural(len(sharded)-maxSharded, "sharded test"))
+	}
+}
+
+// finalShardResults returns the last attempt of each shard of the first run,
+// ordered by shard.
+func finalShardResults(t *bep.Target) []*bep.TestResult {
+	byShard := map[int]*bep.TestResult{}
+	for _, r := range t.TestResults {
+		if r.Run > 1 {
+			continue
+		}
+		if prev, ok := byShard[r.Shard]; !ok || r.Attempt >= prev.Attempt {
+			byShard[r.Shard] = r
+		}
+	}
+	var results []*bep.TestResult
+	for _, r := range byShard {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Shard < results[j].Shard })
+	return results
+}
+
+// writeFailures lists the failed tests with their failed cases and returns
+// their labels.
+func writeFailures(w io.Writer, inv *bep.Invocation, tests []*test) []string {
+	var failed []string
+	for _, t := range tests {
+		if t.status == "TIMEOUT" || slices.Contains(failedStatuses, t.status) {
+			failed = append(failed, t.target.Label)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	casesByTarget := map[string][]*testlog.Case{}
+	for _, c := range testlog.Failed(inv, nil) {
+		if c.Name != "" {
+			casesByTarget[c.Target] = append(casesByTarget[c.Target], c)
+		}
+	}
+	fmt.Fprintln(w, "Failed tests:")
+	for _, t := range tests {
+		label := t.target.Label
+		if !slices.Contains(failed[:min(len(failed), maxFailedTargets)], label) {
+			continue
+		}
+		fmt.Fprintf(w, "  %s (%s)\n", label, strings.ToLower(strings.ReplaceAll(t.status, "_", " ")))
+		cases := casesByTarget[label]
+		for _, c := range cases[:min(len(cases), maxCasesPerTarget)] {
+			s := c.FullName()
+			if c.Message != "" {
+				s += ": " + c.Message
+			}
+			if s = firstLine(s); len(s) > maxCaseLine {
+				s = strings.ToValidUTF8(s[:maxCaseLine], "") + "…"
+			}
+			fmt.Fprintf(w, "    - %s\n", s)
+		}
+		if len(cases) > maxCasesPerTarget {
+			fmt.Fprintf(w, "    … and %s more\n", format.Plural(len(cases)-maxCasesPerTarget, "failed case"))
+		}
+	}
+	if len(failed) > maxFailedTargets {
+		fmt.Fprintf(w, "  … and %s more\n", format.Plural(len(failed)-maxFailedTargets, "failed test"))
+	}
+	return failed
+}
+
+// rerunCommand returns the command that reruns only the given tests, with the
+// options of the original command.
+func rerunCommand(args, labels []string) string {
+	args = arg.RemoveTargets(args)
+	for {
+		if _, i, _ := arg.Find(args, "build_event_json_file"); i < 0 {
+			break
+		}
+		_, args = arg.Pop(args, "build_event_json_file")
+	}
+	return "ok " + arg.JoinShell(append(append(args, "--"), labels...))
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
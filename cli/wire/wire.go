@@ -0,0 +1,86 @@
+// Package wire reads serialized protocol buffer messages without generated
+// code, for the few messages that ok reads from bazel, such as its flag
+// schema and compact execution logs.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrMalformed is returned for data that isn't a valid serialized message.
+var ErrMalformed = errors.New("malformed proto")
+
+// Walk calls fn for each field of a serialized proto message, in order.
+// Varint and fixed-size fields are passed as n, and length-delimited fields,
+// like strings, bytes, messages and packed repeated fields, as value.
+func Walk(b []byte, fn func(field int, value []byte, n uint64) error) error {
+	for len(b) > 0 {
+		tag, l := Varint(b)
+		if l == 0 {
+			return ErrMalformed
+		}
+		b = b[l:]
+		field, wireType := int(tag>>3), tag&7
+		var value []byte
+		var n uint64
+		switch wireType {
+		case 0:
+			n, l = Varint(b)
+			if l == 0 {
+				return ErrMalformed
+			}
+			b = b[l:]
+		case 1:
+			if len(b) < 8 {
+				return ErrMalformed
+			}
+			n, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			size, l := Varint(b)
+			if l == 0 || uint64(len(b)-l) < size {
+				return ErrMalformed
+			}
+			value, b = b[l:l+int(size)], b[l+int(size):]
+		case 5:
+			if len(b) < 4 {
+				return ErrMalformed
+			}
+			n, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in proto", wireType)
+		}
+		if err := fn(field, value, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Varint decodes a varint, returning its value and length, or a length of 0
+// if b doesn't start with a valid varint.
+func Varint(b []byte) (uint64, int) {
+	var n uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		n |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i] < 0x80 {
+			return n, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// Packed decodes the value of a packed repeated varint field.
+func Packed(b []byte) ([]uint64, error) {
+	var out []uint64
+	for len(b) > 0 {
+		n, l := Varint(b)
+		if l == 0 {
+			return nil, ErrMalformed
+		}
+		out = append(out, n)
+		b = b[l:]
+	}
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// Files whose presence marks the root of a Bazel workspace, in the order
+	// that they are checked.
+	rootFiles = []string{"MODULE.bazel", "REPO.bazel", "WORKSPACE.bazel", "WORKSPACE"}
+
+	pathOnce sync.Once
+	path     string
+	pathErr  error
+)
+
+// Path returns the root directory of the Bazel workspace that contains the
+// current working directory. The result is computed once and cached.
+func Path() (string, error) {
+	pathOnce.Do(func() {
+		path, pathErr = find()
+	})
+	return path, pathErr
+}
+
+func find() (string, error) {
+	// `bazel run` sets this for binaries that are run from a workspace.
+	if dir := os.Getenv("BUILD_WORKSPACE_DIRECTORY"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, f := range rootFiles {
+			if fi, err := os.Stat(filepath.Join(dir, f)); err == nil && !fi.IsDir() {
+				return dir, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("not in a bazel workspace (no %s found)", rootFiles[0])
+		}
+		dir = parent
+	}
+}
+
+// CurrentPackage returns the label of the package that contains the current
+// working directory, such as "//foo/bar", or "//" at the workspace root.
+func CurrentPackage() (string, error) {
+	root, err := Path()
+	if err != nil {
+		return "", err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return "//", nil
+	}
+	return "//" + filepath.ToSlash(rel), nil
+}
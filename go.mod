@@ -7,6 +7,7 @@ require (
 	github.com/bazelbuild/bazelisk v1.25.1-0.20250219134847-cdb99bfb1b7d
 	github.com/cqroot/prompt v0.9.4
 	github.com/creack/pty v1.1.24
+	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-isatty v0.0.20
 	golang.org/x/term v0.31.0
 )